@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/auth"
+	"github.com/relepega/doujinstyle-downloader/internal/configManager"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/initters"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+const commandsUsage = `Usage: doujinstyle-downloader [command]
+
+Without a command the application starts. Commands:
+
+  export [-format json|csv] [-o file] [-ids id1|id2]
+        Writes the tasks, all of them unless IDs are given, to the file or to stdout
+  import file
+        Queues the tasks of a JSON or CSV export, "-" reading it from stdin
+  passwd
+        Reads the password of the "admin" user from stdin, stores its hash and enables
+        the authentication
+  user list|add name role|remove name
+        Manages the other users of the web UI, reading the password of an added user
+        from stdin. Roles are viewer, operator and admin
+  token list|create name [role]|revoke name
+        Manages the API tokens, operators unless another role is given. A created token
+        is printed once, only its hash is stored
+
+Commands use the database and the config file of the application. Stop the application
+before running them when it stores the tasks in a JSON file, or its next save will undo
+them, and before changing the password or the tokens, which it reads on start.
+`
+
+// Runs the command in args, returning the exit code of the process
+func runCommand(args []string) int {
+	var err error
+
+	switch args[0] {
+	case "export":
+		err = exportCommand(args[1:])
+	case "import":
+		err = importCommand(args[1:])
+	case "passwd":
+		err = passwdCommand(args[1:])
+	case "user":
+		err = userCommand(args[1:])
+	case "token":
+		err = tokenCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(commandsUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], commandsUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// Opens the database of the config file
+func openStore() (dsdl.TaskStore, error) {
+	cfg := initters.InitConfig()
+
+	store, err := initters.OpenStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open the database: %v", err)
+	}
+
+	return store, nil
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "json", `"json" or "csv"`)
+	output := fs.String("o", "", "file to write, stdout if missing")
+	ids := fs.String("ids", "", "IDs of the tasks to export, separated by '|'")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := dsdl.ParseExportFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var tasks []*task.Task
+
+	if *ids != "" {
+		for id := range strings.SplitSeq(*ids, "|") {
+			if id == "" {
+				continue
+			}
+
+			t, err := store.Get(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("Task not found: %s", id)
+			}
+			if err != nil {
+				return err
+			}
+
+			tasks = append(tasks, t)
+		}
+	} else {
+		tasks, err = store.GetAll()
+		if err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	if err := dsdl.Export(w, tasks, f); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d tasks\n", len(tasks))
+
+	return nil
+}
+
+func importCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("import needs the file to import, or \"-\" for stdin")
+	}
+
+	var r io.Reader = os.Stdin
+
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		r = file
+	}
+
+	exported, err := dsdl.ReadExport(r)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	counts := make(map[dsdl.ImportStatus]int)
+
+	for _, res := range dsdl.Import(store, initters.Aggregators(), exported) {
+		counts[res.Status]++
+
+		line := fmt.Sprintf("%d\t%s\t%s\t%s", res.Row, res.Status, res.Aggregator, res.Slug)
+		if res.Err != "" {
+			line += "\t" + res.Err
+		}
+
+		fmt.Println(line)
+	}
+
+	fmt.Fprintf(
+		os.Stderr,
+		"Imported %d tasks: %d inserted, %d duplicates, %d with an invalid service, %d invalid\n",
+		len(exported),
+		counts[dsdl.ImportInserted],
+		counts[dsdl.ImportDuplicate],
+		counts[dsdl.ImportInvalidAggregator],
+		counts[dsdl.ImportInvalid],
+	)
+
+	return nil
+}
+
+// Reads a password from stdin, returning its hash
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "New password: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return auth.HashPassword(strings.TrimRight(line, "\r\n"))
+}
+
+func passwdCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("passwd reads the password from stdin and takes no arguments")
+	}
+
+	hash, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	cfg := initters.InitConfig()
+
+	cfg.Auth.Enabled = true
+	cfg.Auth.PasswordHash = hash
+
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Password saved, the authentication is enabled")
+
+	return nil
+}
+
+func tokenCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("token needs a subcommand: list, create or revoke")
+	}
+
+	a := initters.InitAuth(initters.InitConfig())
+
+	switch args[0] {
+	case "list":
+		for _, t := range a.Tokens() {
+			fmt.Printf("%s\t%s\t%s\n", t.Name, t.Role, t.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+
+		return nil
+
+	case "create":
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("token create needs the name of the token, and optionally its role")
+		}
+
+		role := auth.RoleOperator
+
+		if len(args) == 3 {
+			var err error
+
+			role, err = auth.ParseRole(args[2])
+			if err != nil {
+				return err
+			}
+		}
+
+		token, err := a.CreateToken(args[1], role)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(token)
+		fmt.Fprintln(os.Stderr, "Token created. Copy it now, it won't be shown again")
+
+		return nil
+
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("token revoke needs the name of the token")
+		}
+
+		return a.RevokeToken(args[1])
+
+	default:
+		return fmt.Errorf("Unknown token subcommand %q, expected list, create or revoke", args[0])
+	}
+}
+
+func userCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("user needs a subcommand: list, add or remove")
+	}
+
+	cfg := initters.InitConfig()
+
+	find := func(name string) int {
+		for i, u := range cfg.Auth.Users {
+			if u.Name == name {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	switch args[0] {
+	case "list":
+		if cfg.Auth.PasswordHash != "" {
+			fmt.Printf("%s\t%s\n", initters.AdminUser, auth.RoleAdmin)
+		}
+
+		for _, u := range cfg.Auth.Users {
+			fmt.Printf("%s\t%s\n", u.Name, u.Role)
+		}
+
+		return nil
+
+	case "add":
+		if len(args) != 3 {
+			return fmt.Errorf("user add needs the name and the role of the user")
+		}
+
+		name := strings.TrimSpace(args[1])
+
+		if name == "" || name == initters.AdminUser {
+			return fmt.Errorf("Not a valid user name: %q. The password of %q is set by the passwd command", name, initters.AdminUser)
+		}
+
+		if find(name) >= 0 {
+			return fmt.Errorf("The user %q already exists", name)
+		}
+
+		role, err := auth.ParseRole(args[2])
+		if err != nil {
+			return err
+		}
+
+		hash, err := readPassword()
+		if err != nil {
+			return err
+		}
+
+		cfg.Auth.Users = append(cfg.Auth.Users, configManager.AuthUser{
+			Name:         name,
+			PasswordHash: hash,
+			Role:         role.String(),
+		})
+
+	case "remove":
+		if len(args) != 2 {
+			return fmt.Errorf("user remove needs the name of the user")
+		}
+
+		i := find(args[1])
+		if i < 0 {
+			return fmt.Errorf("User not found: %q", args[1])
+		}
+
+		cfg.Auth.Users = append(cfg.Auth.Users[:i], cfg.Auth.Users[i+1:]...)
+
+	default:
+		return fmt.Errorf("Unknown user subcommand %q, expected list, add or remove", args[0])
+	}
+
+	return cfg.Save()
+}
@@ -20,6 +20,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// init logger
 	logdir := filepath.Join(".", "Logs")
 	err := appUtils.MkdirAll(logdir)
@@ -45,14 +49,6 @@ func main() {
 	// init modules
 	cfg := initters.InitConfig()
 
-	// clear temp download dir
-	if appUtils.DirectoryExists(cfg.Download.Tempdir) {
-		err := os.RemoveAll(cfg.Download.Tempdir)
-		if err != nil {
-			log.Fatalln("Could not clear temp directory", err)
-		}
-	}
-
 	webserverHost := cfg.Server.Host
 	if strings.ToLower(cfg.Server.Host) == "auto" {
 		webserverHost = appUtils.GetLocalIPAddr()
@@ -60,6 +56,12 @@ func main() {
 
 	engine := initters.InitEngine(cfg)
 
+	// clear temp download dir, keeping the partial files restored tasks can resume
+	err = engine.PruneTempDir(cfg.Download.Tempdir)
+	if err != nil {
+		log.Fatalln("Could not clear temp directory", err)
+	}
+
 	server := webserver.NewWebServer(
 		webserverHost,
 		cfg.Server.Port,
@@ -67,15 +69,21 @@ func main() {
 		cfg.Server.SSL.Key,
 		cfg.Server.SSL.Cert,
 		engine,
+		initters.InitAuth(cfg),
 	)
 	server.Start()
 
 	// engine runner
 	stopRunner := make(chan struct{})
+	runnerDone := make(chan struct{})
 	go func(engine *dsdl.DSDL, stopRunner chan struct{}) {
 		initters.QueueRunner(engine, cfg, stopRunner)
+		close(runnerDone)
 	}(engine, stopRunner)
 
+	// archiving of the ended tasks, stopped together with the queue runner
+	go initters.RetentionRunner(engine, stopRunner)
+
 	// create channel that waits for a SIGTERM event
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
@@ -84,8 +92,8 @@ func main() {
 	log.Println("Main: Termination signal caught")
 
 	log.Println("Main: Stopping QueueRunner")
-	stopRunner <- struct{}{}
 	close(stopRunner)
+	<-runnerDone
 
 	log.Println("Main: Creating shutdown context")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
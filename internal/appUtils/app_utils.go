@@ -1,17 +1,18 @@
 package appUtils
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
 	"github.com/relepega/doujinstyle-downloader/internal/store"
 )
 
@@ -28,6 +29,19 @@ func MkdirAll(dir string) error {
 	return nil
 }
 
+// Waits for d, returning early with the cancellation cause if ctx is done first
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return dsdlerr.FromContext(ctx)
+	case <-t.C:
+		return nil
+	}
+}
+
 func FileExists(fp string) (bool, error) {
 	_, err := os.Stat(fp)
 	if err != nil {
@@ -85,129 +99,6 @@ func GetLocalIPAddr() string {
 	return localAddr.IP.String()
 }
 
-func DownloadFile(
-	url,
-	tempDir,
-	finalFilepath string,
-	setProgress func(p int8),
-) (err error) {
-	if setProgress == nil {
-		return fmt.Errorf("DownloadFile: setProgress cannot be nil")
-	}
-
-	exists, err := FileExists(finalFilepath)
-	if err != nil {
-		return err
-	}
-
-	if exists {
-		setProgress(100)
-		return nil
-	}
-
-	// write to a temp file first to avoid incomplete downloads
-	tempf, err := os.CreateTemp(tempDir, "*")
-	if err != nil {
-		return err
-	}
-	defer tempf.Close()
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad HTTP status: %s", resp.Status)
-	}
-
-	// Get the total size of the file
-	var totalSize int64
-	totalSize, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
-	if err != nil {
-		totalSize = -1
-	}
-
-	// Create a buffer for copying
-	buf := make([]byte, 32*1024)
-
-	// Initialize the current size to zero
-	var currentSize int64
-
-	// Copy chunk by chunk
-	for {
-		n, readErr := resp.Body.Read(buf)
-		if readErr != nil && readErr != io.EOF {
-			return readErr
-		}
-
-		// this is here just in case there's the need for this check
-		// if n == 0 {
-		// 	continue
-		// }
-
-		// Update the current size
-		currentSize += int64(n)
-
-		// Calculate and update the progress
-		var currentProgress int8
-		if totalSize == -1 {
-			currentProgress = 127
-		} else {
-			currentProgress = int8((float64(currentSize) / float64(totalSize)) * 100)
-		}
-
-		setProgress(currentProgress)
-
-		// Write the chunk to the temp file
-		_, err := tempf.Write(buf[:n])
-		if err != nil {
-			return err
-		}
-
-		if readErr == io.EOF {
-			break
-		}
-	}
-
-	tempfn := tempf.Name()
-
-	// Check if the total size matches the Content-Length header
-	if currentSize != totalSize {
-		tempf.Close()
-		os.Remove(tempfn)
-
-		return fmt.Errorf("downloaded file size differs from the one reported by the server")
-	}
-
-	// Reset the file pointer to the beginning of the file
-	_, err = tempf.Seek(0, 0)
-	if err != nil {
-		return err
-	}
-
-	// Copy content to final location
-	out, err := os.Create(finalFilepath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, tempf)
-
-	// delete temp file
-	tempf.Close()
-	os.Remove(tempfn)
-
-	return nil
-}
-
 func SanitizePath(s string) string {
 	r := strings.NewReplacer(
 		"\\",
@@ -247,10 +138,14 @@ func SanitizePath(s string) string {
 func ParseJson[T any](url string, data *T) error {
 	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		return dsdlerr.Wrap(dsdlerr.Network, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return statusErr(resp)
+	}
+
 	err = json.NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
 		return err
@@ -0,0 +1,426 @@
+package appUtils
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
+)
+
+const (
+	partialFileExt     = ".part"
+	partialMetadataExt = ".part.json"
+
+	// amount of bytes written between two checkpoints
+	checkpointInterval = 8 * 1024 * 1024
+)
+
+// Resume metadata of a partially downloaded file.
+//
+// It is stored next to the partial file, so that a later call to DownloadFile
+// can continue the transfer instead of starting again from byte 0
+type PartialDownload struct {
+	// Url the partial data has been downloaded from
+	Url string
+	// Path of the file holding the partial data
+	TempFilepath string
+	// Amount of bytes already written to TempFilepath
+	BytesWritten int64
+	// Validators returned by the server, used in the If-Range header
+	ETag         string
+	LastModified string
+}
+
+// Returns the value to send in the If-Range header.
+//
+// Weak ETags are not allowed in If-Range, so Last-Modified is used instead.
+// An empty string means that the partial data cannot be safely resumed
+func (pd *PartialDownload) validator() string {
+	if pd.ETag != "" && !strings.HasPrefix(pd.ETag, "W/") {
+		return pd.ETag
+	}
+
+	return pd.LastModified
+}
+
+// Returns whether path is a partial file or its metadata
+func IsPartialDownloadFile(path, tempFilepath string) bool {
+	return path == tempFilepath ||
+		path == strings.TrimSuffix(tempFilepath, partialFileExt)+partialMetadataExt
+}
+
+// Returns the stable path of the partial file used to download finalFilepath into tempDir
+func PartialFilepath(tempDir, finalFilepath string) string {
+	return filepath.Join(tempDir, fmt.Sprintf("%x%s", sha1.Sum([]byte(finalFilepath)), partialFileExt))
+}
+
+func loadPartialDownload(tempFilepath string) *PartialDownload {
+	pd := &PartialDownload{TempFilepath: tempFilepath}
+
+	info, err := os.Stat(tempFilepath)
+	if err != nil {
+		return pd
+	}
+
+	data, err := os.ReadFile(strings.TrimSuffix(tempFilepath, partialFileExt) + partialMetadataExt)
+	if err != nil {
+		return pd
+	}
+
+	if err := json.Unmarshal(data, pd); err != nil {
+		return &PartialDownload{TempFilepath: tempFilepath}
+	}
+
+	// the file on disk is the source of truth, the metadata could be outdated
+	pd.TempFilepath = tempFilepath
+	pd.BytesWritten = info.Size()
+
+	return pd
+}
+
+func savePartialDownload(pd *PartialDownload) error {
+	data, err := json.Marshal(pd)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(
+		strings.TrimSuffix(pd.TempFilepath, partialFileExt)+partialMetadataExt,
+		data,
+		0o644,
+	)
+}
+
+// Removes both the partial file and its metadata
+func discardPartialDownload(pd *PartialDownload) {
+	DiscardPartialFile(pd.TempFilepath)
+}
+
+// Removes a partial file and its metadata, along with their directory if it is left empty
+func DiscardPartialFile(tempFilepath string) {
+	os.Remove(tempFilepath)
+	os.Remove(strings.TrimSuffix(tempFilepath, partialFileExt) + partialMetadataExt)
+	os.Remove(filepath.Dir(tempFilepath))
+}
+
+// Parses a "bytes start-end/total" Content-Range header.
+//
+// total is -1 if the server does not know the full size
+func parseContentRange(s string) (start, total int64, err error) {
+	var rng, size string
+
+	if _, err := fmt.Sscanf(s, "bytes %s", &rng); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", s)
+	}
+
+	rng, size, ok := strings.Cut(rng, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", s)
+	}
+
+	first, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", s)
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", s)
+	}
+
+	if size == "*" {
+		return start, -1, nil
+	}
+
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", s)
+	}
+
+	return start, total, nil
+}
+
+// Categorizes an unexpected HTTP response status
+func statusErr(resp *http.Response) error {
+	var c dsdlerr.Category
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		c = dsdlerr.NotFound
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == 509:
+		c = dsdlerr.QuotaExceeded
+	case resp.StatusCode >= 500:
+		c = dsdlerr.Network
+	default:
+		c = dsdlerr.Unknown
+	}
+
+	return dsdlerr.New(c, "bad HTTP status: %s", resp.Status)
+}
+
+// Moves src to dst, falling back to a copy when they are on different devices
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	in.Close()
+
+	return os.Remove(src)
+}
+
+/*
+Downloads url into finalFilepath.
+
+The data is written to a partial file inside tempDir first, whose name is derived
+from finalFilepath. If the transfer breaks, the partial file is kept and the next
+call with the same tempDir and finalFilepath resumes it with a Range/If-Range
+request, as long as the server advertised "Accept-Ranges: bytes" and sent a
+validator. If it did not, or if the file changed on the server, the download
+restarts cleanly from byte 0.
+
+Cancelling ctx stops the transfer, keeping the partial data like any other failure.
+
+checkpoint, if not nil, is called with the state of the partial file when the
+transfer starts, periodically while it goes on and when it fails, so that the
+caller can persist it somewhere safer than memory.
+*/
+func DownloadFile(
+	ctx context.Context,
+	url,
+	tempDir,
+	finalFilepath string,
+	setProgress func(p progress.Progress),
+	checkpoint func(pd *PartialDownload),
+) (err error) {
+	if setProgress == nil {
+		return fmt.Errorf("DownloadFile: setProgress cannot be nil")
+	}
+
+	exists, err := FileExists(finalFilepath)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		p := progress.New(progress.Downloading)
+		p.Pct = 100
+		setProgress(p)
+
+		return nil
+	}
+
+	err = MkdirAll(tempDir)
+	if err != nil {
+		return err
+	}
+
+	pd := loadPartialDownload(PartialFilepath(tempDir, finalFilepath))
+	pd.Url = url
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resuming := pd.BytesWritten > 0 && pd.validator() != ""
+	if resuming {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", pd.BytesWritten))
+		req.Header.Set("If-Range", pd.validator())
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return dsdlerr.FromContext(ctx)
+		}
+
+		return dsdlerr.Wrap(dsdlerr.Network, err)
+	}
+	defer resp.Body.Close()
+
+	var tempf *os.File
+	var totalSize int64
+
+	switch {
+	case resuming && resp.StatusCode == http.StatusPartialContent:
+		var start int64
+
+		start, totalSize, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != pd.BytesWritten {
+			discardPartialDownload(pd)
+			return dsdlerr.New(
+				dsdlerr.Network,
+				"server resumed the download from an unexpected offset",
+			)
+		}
+
+		tempf, err = os.OpenFile(pd.TempFilepath, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+
+	case resuming && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file doesn't match the remote one anymore: start over
+		resp.Body.Close()
+		discardPartialDownload(pd)
+
+		return DownloadFile(ctx, url, tempDir, finalFilepath, setProgress, checkpoint)
+
+	case resp.StatusCode == http.StatusOK:
+		// either a fresh download, or the server ignored the range because
+		// the file changed in the meantime: in both cases start from byte 0
+		pd.BytesWritten = 0
+
+		totalSize, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			totalSize = -1
+		}
+
+		tempf, err = os.Create(pd.TempFilepath)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return statusErr(resp)
+	}
+	defer tempf.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		pd.ETag = ""
+		pd.LastModified = ""
+
+		// only keep validators if the server allows to resume the transfer later on
+		if resp.Header.Get("Accept-Ranges") == "bytes" {
+			pd.ETag = resp.Header.Get("ETag")
+			pd.LastModified = resp.Header.Get("Last-Modified")
+		}
+	}
+
+	err = savePartialDownload(pd)
+	if err != nil {
+		return err
+	}
+
+	if checkpoint == nil {
+		checkpoint = func(pd *PartialDownload) {}
+	}
+
+	checkpoint(pd)
+	lastCheckpoint := pd.BytesWritten
+
+	// on failure keep the partial data only if it can be resumed
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if pd.validator() == "" {
+			tempf.Close()
+			discardPartialDownload(pd)
+			return
+		}
+
+		savePartialDownload(pd)
+		checkpoint(pd)
+	}()
+
+	meter := progress.NewMeter(pd.BytesWritten)
+	setProgress(meter.Update(pd.BytesWritten, totalSize))
+
+	// Create a buffer for copying
+	buf := make([]byte, 32*1024)
+
+	// Copy chunk by chunk
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if readErr != nil && readErr != io.EOF {
+			if ctx.Err() != nil {
+				return dsdlerr.FromContext(ctx)
+			}
+
+			return dsdlerr.Wrap(dsdlerr.Network, readErr)
+		}
+
+		// Write the chunk to the temp file
+		_, err := tempf.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		// Update the current size
+		pd.BytesWritten += int64(n)
+
+		if pd.BytesWritten-lastCheckpoint >= checkpointInterval {
+			checkpoint(pd)
+			lastCheckpoint = pd.BytesWritten
+		}
+
+		setProgress(meter.Update(pd.BytesWritten, totalSize))
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	// Check if the total size matches the one reported by the server
+	if totalSize != -1 && pd.BytesWritten != totalSize {
+		discardPartialDownload(pd)
+		pd.ETag = ""
+		pd.LastModified = ""
+
+		return dsdlerr.New(
+			dsdlerr.Network,
+			"downloaded file size differs from the one reported by the server",
+		)
+	}
+
+	err = tempf.Close()
+	if err != nil {
+		return err
+	}
+
+	// Move content to final location
+	p := meter.Update(pd.BytesWritten, totalSize)
+	p.Phase = progress.Finalizing
+	setProgress(p)
+
+	err = moveFile(pd.TempFilepath, finalFilepath)
+	if err != nil {
+		return err
+	}
+
+	discardPartialDownload(pd)
+
+	return nil
+}
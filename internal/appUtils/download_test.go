@@ -0,0 +1,186 @@
+package appUtils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
+)
+
+func testPayload() []byte {
+	return bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	payload := testPayload()
+	cut := len(payload) / 2
+
+	var requests []string
+	modtime := time.Now()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+
+		// break the first transfer halfway through
+		if len(requests) == 1 {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(payload[:cut])
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file", modtime, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	tempDir := filepath.Join(dir, "tmp")
+	final := filepath.Join(dir, "file.bin")
+
+	err := DownloadFile(context.Background(), srv.URL, tempDir, final, func(p progress.Progress) {}, nil)
+	if err == nil {
+		t.Fatal("Expected the first download to fail")
+	}
+
+	info, err := os.Stat(PartialFilepath(tempDir, final))
+	if err != nil {
+		t.Fatalf("Partial file has not been kept: %v", err)
+	}
+	if info.Size() != int64(cut) {
+		t.Fatalf("Expected %d partial bytes, got %d", cut, info.Size())
+	}
+
+	err = DownloadFile(context.Background(), srv.URL, tempDir, final, func(p progress.Progress) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "bytes=" + strconv.Itoa(cut) + "-"; requests[1] != want {
+		t.Fatalf("Expected Range %q, got %q", want, requests[1])
+	}
+
+	data, err := os.ReadFile(final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Fatal("Resumed file differs from the original one")
+	}
+
+	if ok, _ := FileExists(PartialFilepath(tempDir, final)); ok {
+		t.Fatal("Partial file has not been removed")
+	}
+}
+
+func TestDownloadFileRestartWithoutRanges(t *testing.T) {
+	payload := testPayload()
+	cut := len(payload) / 2
+
+	var requests []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.WriteHeader(http.StatusOK)
+
+		if len(requests) == 1 {
+			w.Write(payload[:cut])
+			return
+		}
+
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	tempDir := filepath.Join(dir, "tmp")
+	final := filepath.Join(dir, "file.bin")
+
+	err := DownloadFile(context.Background(), srv.URL, tempDir, final, func(p progress.Progress) {}, nil)
+	if err == nil {
+		t.Fatal("Expected the first download to fail")
+	}
+
+	if ok, _ := FileExists(PartialFilepath(tempDir, final)); ok {
+		t.Fatal("Partial file without validators should have been discarded")
+	}
+
+	err = DownloadFile(context.Background(), srv.URL, tempDir, final, func(p progress.Progress) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests[1] != "" {
+		t.Fatalf("Expected a clean restart, got Range %q", requests[1])
+	}
+
+	data, err := os.ReadFile(final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Fatal("Downloaded file differs from the original one")
+	}
+}
+
+func TestDownloadFileCancel(t *testing.T) {
+	payload := testPayload()
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(payload[:1024])
+		w.(http.Flusher).Flush()
+
+		// stall the transfer until the test is over
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	dir := t.TempDir()
+	final := filepath.Join(dir, "file.bin")
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	started := make(chan struct{})
+	var once bool
+
+	done := make(chan error, 1)
+	go func() {
+		done <- DownloadFile(ctx, srv.URL, filepath.Join(dir, "tmp"), final, func(p progress.Progress) {
+			if !once {
+				once = true
+				close(started)
+			}
+		}, nil)
+	}()
+
+	<-started
+	cancel(dsdlerr.ErrPaused)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, dsdlerr.ErrPaused) {
+			t.Fatalf("Expected the cancellation cause, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DownloadFile didn't stop after its context was cancelled")
+	}
+}
@@ -0,0 +1,384 @@
+/*
+Package auth checks who is talking to the webserver: the users of the web UI
+log in with a password and keep a session cookie, while scripts send an API
+token as a bearer token. Both carry a Role, limiting what they can do.
+
+Neither the password nor the tokens are stored in clear: the password is
+hashed with PBKDF2, the tokens, being long random strings, with SHA-256.
+*/
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PBKDF2-SHA256 iterations of a new password hash
+	passwordIterations = 600_000
+	saltSize           = 16
+	keySize            = 32
+	// Random bytes of a session ID or an API token
+	secretSize = 32
+	// Makes the API tokens recognizable, e.g. by secret scanners
+	TokenPrefix = "dsdl_"
+
+	// How long a login lasts when the options don't say
+	DefaultSessionTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrNoUsers         = errors.New("No user can log in")
+	ErrInvalidPassword = errors.New("Invalid user or password")
+	ErrTokenExists     = errors.New("A token with this name already exists")
+	ErrTokenName       = errors.New("Token names can only contain letters, digits, '.', '-' and '_'")
+	ErrTokenNotFound   = errors.New("Token not found")
+)
+
+// Checked in place of the hash of an unknown user, so that a login takes as long
+// whether the user exists or not
+var dummyPasswordHash = fmt.Sprintf(
+	"pbkdf2-sha256$%d$%s$%s",
+	passwordIterations,
+	"Hjk16Yoar2qcLwt1KexAiQ",
+	"3PkI0KT41OFQOuHIgfDk253Arf1StXlDPu2hRcExO1M",
+)
+
+// Token names end up in URLs, e.g. the one revoking them
+var validTokenName = regexp.MustCompile(`^[\w.-]+$`)
+
+// A user of the web UI
+type User struct {
+	Name string
+	// Result of HashPassword
+	PasswordHash string
+	Role         Role
+}
+
+// An API token, of which only the hash is known
+type Token struct {
+	Name      string
+	Hash      string
+	Role      Role
+	CreatedAt time.Time
+}
+
+type session struct {
+	Identity
+	Expires time.Time
+}
+
+type Options struct {
+	// Require a login for the web UI and a token for the API
+	Enabled bool
+	// Users able to log in, with unique names
+	Users  []User
+	Tokens []Token
+	// How long a login lasts, DefaultSessionTTL if 0
+	SessionTTL time.Duration
+	// Persists the tokens after they have been created or revoked, may be nil
+	SaveTokens func([]Token) error
+}
+
+type Authenticator struct {
+	mu sync.Mutex
+
+	enabled    bool
+	users      []User
+	tokens     []Token
+	saveTokens func([]Token) error
+
+	// by ID
+	sessions   map[string]session
+	sessionTTL time.Duration
+}
+
+func New(opts Options) *Authenticator {
+	ttl := opts.SessionTTL
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	return &Authenticator{
+		enabled:    opts.Enabled,
+		users:      slices.Clone(opts.Users),
+		tokens:     slices.Clone(opts.Tokens),
+		saveTokens: opts.SaveTokens,
+		sessions:   make(map[string]session),
+		sessionTTL: ttl,
+	}
+}
+
+// Reports whether requests have to be authenticated
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Reports whether the web UI can log in, some user having been set up
+func (a *Authenticator) HasUsers() bool {
+	return len(a.users) != 0
+}
+
+// Returns a random string of secretSize bytes, prefixed by prefix
+func newSecret(prefix string) (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hashes a password as "pbkdf2-sha256$<iterations>$<salt>$<key>"
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", fmt.Errorf("The password cannot be empty")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, keySize)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"pbkdf2-sha256$%d$%s$%s",
+		passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Reports whether password matches a hash made by HashPassword
+func CheckPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// Hashes an API token as "sha256$<hex>"
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return "sha256$" + hex.EncodeToString(sum[:])
+}
+
+// Checks the password of a user, returning the ID of a new session and when it expires
+func (a *Authenticator) Login(name, password string) (string, time.Time, error) {
+	if !a.HasUsers() {
+		return "", time.Time{}, ErrNoUsers
+	}
+
+	i := slices.IndexFunc(a.users, func(u User) bool {
+		return u.Name == name
+	})
+
+	hash := dummyPasswordHash
+	if i >= 0 {
+		hash = a.users[i].PasswordHash
+	}
+
+	// the password is checked even for unknown users, not to tell them apart by timing
+	if !CheckPassword(hash, password) || i < 0 {
+		return "", time.Time{}, ErrInvalidPassword
+	}
+
+	user := a.users[i]
+
+	id, err := newSecret("")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expires := now.Add(a.sessionTTL)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for s, sess := range a.sessions {
+		if !sess.Expires.After(now) {
+			delete(a.sessions, s)
+		}
+	}
+
+	a.sessions[id] = session{
+		Identity: Identity{Name: user.Name, Role: user.Role},
+		Expires:  expires,
+	}
+
+	return id, expires, nil
+}
+
+// Returns who logged in with the session, if it hasn't expired nor logged out
+func (a *Authenticator) Session(id string) (Identity, bool) {
+	if id == "" {
+		return Identity{}, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sess, ok := a.sessions[id]
+	if !ok {
+		return Identity{}, false
+	}
+
+	if !sess.Expires.After(time.Now()) {
+		delete(a.sessions, id)
+		return Identity{}, false
+	}
+
+	return sess.Identity, true
+}
+
+func (a *Authenticator) Logout(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.sessions, id)
+}
+
+// Returns the name and the role of the token, if it is a known one
+func (a *Authenticator) CheckToken(token string) (Identity, bool) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return Identity{}, false
+	}
+
+	hash := []byte(HashToken(token))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			return Identity{Name: t.Name, Role: t.Role}, true
+		}
+	}
+
+	return Identity{}, false
+}
+
+// Returns the tokens, sorted by creation
+func (a *Authenticator) Tokens() []Token {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tokens := slices.Clone(a.tokens)
+
+	slices.SortStableFunc(tokens, func(x, y Token) int {
+		return x.CreatedAt.Compare(y.CreatedAt)
+	})
+
+	return tokens
+}
+
+// Must be called with a.mu held
+func (a *Authenticator) save() error {
+	if a.saveTokens == nil {
+		return nil
+	}
+
+	return a.saveTokens(slices.Clone(a.tokens))
+}
+
+// Creates a token with the given role, returning it in clear. It is the only time it can be read
+func (a *Authenticator) CreateToken(name string, role Role) (string, error) {
+	if !validTokenName.MatchString(name) {
+		return "", ErrTokenName
+	}
+
+	if _, ok := roleNames[role]; !ok {
+		return "", fmt.Errorf("Not a valid role: %v", role)
+	}
+
+	token, err := newSecret(TokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, t := range a.tokens {
+		if t.Name == name {
+			return "", ErrTokenExists
+		}
+	}
+
+	a.tokens = append(a.tokens, Token{
+		Name:      name,
+		Hash:      HashToken(token),
+		Role:      role,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	})
+
+	if err := a.save(); err != nil {
+		a.tokens = a.tokens[:len(a.tokens)-1]
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Deletes a token, so that it stops working right away
+func (a *Authenticator) RevokeToken(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	i := slices.IndexFunc(a.tokens, func(t Token) bool {
+		return t.Name == name
+	})
+	if i < 0 {
+		return ErrTokenNotFound
+	}
+
+	old := a.tokens
+	a.tokens = slices.Delete(slices.Clone(a.tokens), i, i+1)
+
+	if err := a.save(); err != nil {
+		a.tokens = old
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(hash, "hunter2") {
+		t.Errorf("HashPassword: The hash contains the password: %q", hash)
+	}
+
+	if !CheckPassword(hash, "hunter2") {
+		t.Error("CheckPassword: Expected the password to match its hash")
+	}
+
+	for _, wrong := range []string{"", "hunter3", "Hunter2"} {
+		if CheckPassword(hash, wrong) {
+			t.Errorf("CheckPassword: Expected %q not to match", wrong)
+		}
+	}
+
+	for _, malformed := range []string{"", "hunter2", "pbkdf2-sha256$x$y$z", "md5$1$abc$def"} {
+		if CheckPassword(malformed, "hunter2") {
+			t.Errorf("CheckPassword: Expected the malformed hash %q not to match", malformed)
+		}
+	}
+
+	if _, err := HashPassword(""); err == nil {
+		t.Error("HashPassword: Expected an error for an empty password")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(Options{
+		Enabled:    true,
+		Users:      []User{{Name: "alice", PasswordHash: hash, Role: RoleViewer}},
+		SessionTTL: time.Hour,
+	})
+
+	if _, _, err := a.Login("alice", "wrong"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Login: Expected ErrInvalidPassword, got %v", err)
+	}
+
+	if _, _, err := a.Login("bob", "hunter2"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Login: Expected ErrInvalidPassword for an unknown user, got %v", err)
+	}
+
+	// unknown users are checked against the dummy hash, which must cost as much as a real one
+	dummy, stored := strings.Split(dummyPasswordHash, "$"), strings.Split(hash, "$")
+	if len(dummy) != 4 || dummy[0] != stored[0] || dummy[1] != stored[1] ||
+		len(dummy[2]) != len(stored[2]) || len(dummy[3]) != len(stored[3]) {
+		t.Errorf("dummyPasswordHash: Expected the layout of a hash made by HashPassword, got %q", dummyPasswordHash)
+	}
+
+	id, expires, err := a.Login("alice", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if until := time.Until(expires); until <= 0 || until > time.Hour {
+		t.Errorf("Login: Expected the session to expire within an hour, got %v", expires)
+	}
+
+	if who, ok := a.Session(id); !ok || who != (Identity{Name: "alice", Role: RoleViewer}) {
+		t.Errorf("Session: Expected alice to be logged in as a viewer, got %+v, %v", who, ok)
+	}
+
+	if _, ok := a.Session(""); ok {
+		t.Error("Session: Expected an empty session to be refused")
+	}
+
+	if _, ok := a.Session("unknown"); ok {
+		t.Error("Session: Expected an unknown session to be refused")
+	}
+
+	a.Logout(id)
+
+	if _, ok := a.Session(id); ok {
+		t.Error("Session: Expected the session to end on logout")
+	}
+
+	if _, _, err := New(Options{Enabled: true}).Login("", ""); !errors.Is(err, ErrNoUsers) {
+		t.Errorf("Login: Expected ErrNoUsers without users, got %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	var saved []Token
+
+	a := New(Options{
+		Enabled: true,
+		SaveTokens: func(tokens []Token) error {
+			saved = tokens
+			return nil
+		},
+	})
+
+	token, err := a.CreateToken("userscript", RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(token, TokenPrefix) {
+		t.Errorf("CreateToken: Expected the %q prefix, got %q", TokenPrefix, token)
+	}
+
+	if len(saved) != 1 || saved[0].Hash != HashToken(token) || strings.Contains(saved[0].Hash, token) {
+		t.Fatalf("CreateToken: Expected the hash of the token to be saved, got %+v", saved)
+	}
+
+	if _, err := a.CreateToken("userscript", RoleViewer); !errors.Is(err, ErrTokenExists) {
+		t.Errorf("CreateToken: Expected ErrTokenExists, got %v", err)
+	}
+
+	if who, ok := a.CheckToken(token); !ok || who != (Identity{Name: "userscript", Role: RoleOperator}) {
+		t.Errorf("CheckToken: Expected the userscript operator token, got %+v, %v", who, ok)
+	}
+
+	if _, err := a.CreateToken("norole", 0); err == nil {
+		t.Error("CreateToken: Expected an error for an invalid role")
+	}
+
+	for _, name := range []string{"", "two words", "a/b"} {
+		if _, err := a.CreateToken(name, RoleViewer); !errors.Is(err, ErrTokenName) {
+			t.Errorf("CreateToken: Expected ErrTokenName for %q, got %v", name, err)
+		}
+	}
+
+	if _, ok := a.CheckToken(token + "x"); ok {
+		t.Error("CheckToken: Expected a wrong token to be refused")
+	}
+
+	// tokens from the config are known by their hash only
+	b := New(Options{Enabled: true, Tokens: saved})
+
+	if _, ok := b.CheckToken(token); !ok {
+		t.Error("CheckToken: Expected a saved token to be accepted")
+	}
+
+	if err := a.RevokeToken("userscript"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := a.CheckToken(token); ok || len(saved) != 0 {
+		t.Errorf("RevokeToken: Expected the token to stop working, %d saved", len(saved))
+	}
+
+	if err := a.RevokeToken("userscript"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("RevokeToken: Expected ErrTokenNotFound, got %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	for _, r := range Roles() {
+		parsed, err := ParseRole(" " + strings.ToUpper(r.String()) + " ")
+		if err != nil || parsed != r {
+			t.Errorf("ParseRole: Expected %v, got %v (%v)", r, parsed, err)
+		}
+	}
+
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("ParseRole: Expected an error for an unknown role")
+	}
+
+	operator := Identity{Name: "script", Role: RoleOperator}
+
+	if !operator.Can(RoleViewer) || !operator.Can(RoleOperator) || operator.Can(RoleAdmin) {
+		t.Error("Can: Expected an operator to have the viewer and operator roles only")
+	}
+}
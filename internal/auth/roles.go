@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What an identity is allowed to do. Every role can do everything the lower ones can
+type Role int
+
+const (
+	// Sees the queue, the progress and the history
+	RoleViewer Role = iota + 1
+	// Also adds, removes, retries and reorders the tasks
+	RoleOperator
+	// Also manages the API tokens and the maintenance of the server
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Returns every role, from the lowest to the highest
+func Roles() []Role {
+	return []Role{RoleViewer, RoleOperator, RoleAdmin}
+}
+
+// Parses a role name, e.g. "operator"
+func ParseRole(name string) (Role, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	for r, n := range roleNames {
+		if n == name {
+			return r, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Not a valid role: %q, expected viewer, operator or admin", name)
+}
+
+// Who sent a request: a user of the web UI or the name of an API token
+type Identity struct {
+	Name string
+	Role Role
+}
+
+// Reports whether the identity has at least the given role
+func (id Identity) Can(role Role) bool {
+	return id.Role >= role
+}
@@ -3,6 +3,7 @@ package configManager
 import (
 	"encoding/json"
 	"os"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -12,6 +13,23 @@ const (
 	latestVersion = "0.4.0-b3"
 )
 
+// An API token, of which only the hash is stored
+type APIToken struct {
+	Name string
+	Hash string
+	// "viewer", "operator" or "admin". Tokens without a role, created before roles existed, are admins
+	Role      string
+	CreatedAt time.Time
+}
+
+// A user of the web UI
+type AuthUser struct {
+	Name         string
+	PasswordHash string
+	// "viewer", "operator" or "admin"
+	Role string
+}
+
 type Config struct {
 	Server struct {
 		Host string
@@ -26,6 +44,45 @@ type Config struct {
 		ConcurrentJobs int8
 		Directory      string
 		Tempdir        string
+		// Maximum seconds a single attempt can last before being stopped. 0 disables the limit
+		Timeout int
+		Retry   struct {
+			// Total number of attempts, the first one included. 1 disables automatic retries
+			MaxAttempts int
+			// Seconds before the first retry, doubled on every following one
+			BaseDelay int
+			// Maximum seconds between two attempts
+			MaxDelay int
+			// Fraction of the delay (0-1) randomly added or subtracted
+			Jitter float64
+		}
+	}
+	Database struct {
+		// Storage backend: "sqlite", "json" or "memory". Tasks kept in memory are lost on restart
+		Backend string
+		// Path of the database file, or a SQLite DSN. Empty for the default location of the backend
+		Path string
+		// Keep the tasks in memory when the database cannot be opened, instead of refusing to start
+		MemoryFallback bool
+	}
+	Retention struct {
+		// Days completed and skipped tasks stay in the queue before being archived, 0 keeping them forever.
+		// Failed and canceled tasks are archived once acknowledged
+		SucceededDays int
+		// Days removed tasks stay in the trash before being deleted for good, 0 keeping them forever
+		TrashDays int
+	}
+	Auth struct {
+		// Require a login for the web UI and an API token for the scripts
+		Enabled bool
+		// Hash of the password of the "admin" user, set by the "passwd" command
+		PasswordHash string
+		// Other users of the web UI, managed by the "user" command
+		Users []AuthUser
+		// Hours a login lasts
+		SessionHours int
+		// API tokens, managed by the "token" command or from the web UI
+		Tokens []APIToken
 	}
 	Dev struct {
 		PlaywrightDebug bool
@@ -54,6 +111,25 @@ func NewConfig() *Config {
 	cfg.Download.Directory = "./Downloads"
 	cfg.Download.Tempdir = "./Downloads/.tmp"
 
+	cfg.Download.Timeout = 0
+	cfg.Download.Retry.MaxAttempts = 5
+	cfg.Download.Retry.BaseDelay = 10
+	cfg.Download.Retry.MaxDelay = 600
+	cfg.Download.Retry.Jitter = 0.2
+
+	cfg.Database.Backend = "sqlite"
+	cfg.Database.Path = ""
+	cfg.Database.MemoryFallback = true
+
+	cfg.Retention.SucceededDays = 30
+	cfg.Retention.TrashDays = 7
+
+	cfg.Auth.Enabled = false
+	cfg.Auth.PasswordHash = ""
+	cfg.Auth.Users = []AuthUser{}
+	cfg.Auth.SessionHours = 24 * 7
+	cfg.Auth.Tokens = []APIToken{}
+
 	cfg.Dev.PlaywrightDebug = false
 	cfg.Dev.ServerLogging = false
 
@@ -145,6 +221,93 @@ func updateCfg(old *Config, latest *Config) *Config {
 		if ok {
 			latest.Download.Tempdir = old.Download.Tempdir
 		}
+
+		_, ok = downloadCfg["Timeout"]
+		if ok {
+			latest.Download.Timeout = old.Download.Timeout
+		}
+
+		retryCfg, ok := downloadCfg["Retry"].(map[string]any)
+		if ok {
+			_, ok = retryCfg["MaxAttempts"]
+			if ok {
+				latest.Download.Retry.MaxAttempts = old.Download.Retry.MaxAttempts
+			}
+
+			_, ok = retryCfg["BaseDelay"]
+			if ok {
+				latest.Download.Retry.BaseDelay = old.Download.Retry.BaseDelay
+			}
+
+			_, ok = retryCfg["MaxDelay"]
+			if ok {
+				latest.Download.Retry.MaxDelay = old.Download.Retry.MaxDelay
+			}
+
+			_, ok = retryCfg["Jitter"]
+			if ok {
+				latest.Download.Retry.Jitter = old.Download.Retry.Jitter
+			}
+		}
+	}
+
+	databaseCfg, ok := oldCfg["Database"].(map[string]any)
+	if ok {
+		_, ok = databaseCfg["Backend"]
+		if ok {
+			latest.Database.Backend = old.Database.Backend
+		}
+
+		_, ok = databaseCfg["Path"]
+		if ok {
+			latest.Database.Path = old.Database.Path
+		}
+
+		_, ok = databaseCfg["MemoryFallback"]
+		if ok {
+			latest.Database.MemoryFallback = old.Database.MemoryFallback
+		}
+	}
+
+	retentionCfg, ok := oldCfg["Retention"].(map[string]any)
+	if ok {
+		_, ok = retentionCfg["SucceededDays"]
+		if ok {
+			latest.Retention.SucceededDays = old.Retention.SucceededDays
+		}
+
+		_, ok = retentionCfg["TrashDays"]
+		if ok {
+			latest.Retention.TrashDays = old.Retention.TrashDays
+		}
+	}
+
+	authCfg, ok := oldCfg["Auth"].(map[string]any)
+	if ok {
+		_, ok = authCfg["Enabled"]
+		if ok {
+			latest.Auth.Enabled = old.Auth.Enabled
+		}
+
+		_, ok = authCfg["PasswordHash"]
+		if ok {
+			latest.Auth.PasswordHash = old.Auth.PasswordHash
+		}
+
+		_, ok = authCfg["Users"]
+		if ok && old.Auth.Users != nil {
+			latest.Auth.Users = old.Auth.Users
+		}
+
+		_, ok = authCfg["SessionHours"]
+		if ok {
+			latest.Auth.SessionHours = old.Auth.SessionHours
+		}
+
+		_, ok = authCfg["Tokens"]
+		if ok && old.Auth.Tokens != nil {
+			latest.Auth.Tokens = old.Auth.Tokens
+		}
 	}
 
 	devCfg, ok := oldCfg["Dev"].(map[string]any)
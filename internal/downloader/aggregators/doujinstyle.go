@@ -1,6 +1,7 @@
 package aggregators
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
 )
 
 const (
@@ -53,71 +55,75 @@ func (d *Doujinstyle) Page() playwright.Page {
 	return d.page
 }
 
-func (d *Doujinstyle) Is404() (bool, error) {
+func (d *Doujinstyle) Is404(ctx context.Context) (bool, error) {
 	valInterface, err := d.page.Evaluate(
 		"document.querySelector('h3').innerText == 'Insufficient information to display content.'",
 	)
 	if err != nil {
-		return false, fmt.Errorf("Could not evaluate selector: %v", err)
+		return false, dsdlerr.New(dsdlerr.SelectorBroken, "Could not evaluate selector: %v", err)
 	}
 
 	val, ok := valInterface.(bool)
 	if !ok {
-		return false, fmt.Errorf("Could not convert value: %v", err)
+		return false, dsdlerr.New(dsdlerr.SelectorBroken, "Could not convert value: %v", err)
 	}
 
 	return val, nil
 }
 
-func (d *Doujinstyle) EvaluateFileName() (string, error) {
+func (d *Doujinstyle) EvaluateFileName(ctx context.Context) (string, error) {
 	album, err := d.page.Evaluate("document.querySelector('h2').innerText")
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	artist, err := d.page.Evaluate("document.querySelectorAll('.pageSpan2')[0].innerText")
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	format, err := d.page.Evaluate(`
 	   Array.from(document.querySelectorAll("mainbar > div > .pageWrap > .pageSpan1")).find(el => el.innerText == "Format:").nextElementSibling.innerText || ""
 	`)
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	val, err := d.page.Evaluate("document.querySelectorAll('.pageSpan2')[1].innerText")
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 	strVal, ok := val.(string)
 	if !ok {
-		return "", fmt.Errorf("value is not a string: %v", val)
+		return "", dsdlerr.New(dsdlerr.SelectorBroken, "value is not a string: %v", val)
 	}
 	event := d.getExhibitions(strVal)
 
 	return appUtils.SanitizePath(fmt.Sprintf("%s — %s%s [%s]", artist, album, event, format)), nil
 }
 
-func (d *Doujinstyle) EvaluateFileExt() (string, error) {
+func (d *Doujinstyle) EvaluateFileExt(ctx context.Context) (string, error) {
 	return "", fmt.Errorf(dsdl.AGGR_ERR_UNAVAILABLE_FT)
 }
 
-func (d *Doujinstyle) EvaluateDownloadPage() (playwright.Page, error) {
+func (d *Doujinstyle) EvaluateDownloadPage(ctx context.Context) (playwright.Page, error) {
 	dlPage, err := d.page.Context().ExpectPage(func() error {
 		_, err := d.page.Evaluate("document.querySelector('#downloadForm').click()")
-		return err
+		return dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	})
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, dsdlerr.FromContext(ctx)
+		}
+
+		return nil, dsdlerr.Wrap(dsdlerr.Network, err)
 	}
 
 	err = dlPage.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
 		State: playwright.LoadStateDomcontentloaded,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("%s: %v", DEFAULT_PAGE_NOT_LOADED_ERR, err)
+		return nil, dsdlerr.New(dsdlerr.Network, "%s: %v", DEFAULT_PAGE_NOT_LOADED_ERR, err)
 	}
 
 	return dlPage, nil
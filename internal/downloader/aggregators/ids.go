@@ -0,0 +1,59 @@
+package aggregators
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Returns the numeric ID of a doujinstyle album, given either the ID itself or the URL of its page
+func DoujinstyleID(slug string) (string, error) {
+	id := strings.TrimSpace(slug)
+
+	if strings.HasPrefix(id, "http") {
+		u, err := url.Parse(id)
+		if err != nil {
+			return "", fmt.Errorf("Doujinstyle: Not a valid album URL: %q", slug)
+		}
+
+		id = u.Query().Get("id")
+	}
+
+	if id == "" || strings.Trim(id, "0123456789") != "" {
+		return "", fmt.Errorf("Doujinstyle: Not a valid album ID: %q", slug)
+	}
+
+	// drops the leading zeros, "022816" being the same album as "22816"
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return "", fmt.Errorf("Doujinstyle: Not a valid album ID: %q", slug)
+	}
+
+	return strconv.Itoa(n), nil
+}
+
+// Returns the path of a sukidesuost album, given either the path itself or the URL of its page
+func SukiDesuOstID(slug string) (string, error) {
+	id := strings.TrimSpace(slug)
+
+	if strings.Contains(id, SDO_HOSTNAME) {
+		if !strings.HasPrefix(id, "http") {
+			id = "https://" + id
+		}
+
+		u, err := url.Parse(id)
+		if err != nil {
+			return "", fmt.Errorf("SukiDesuOST: Not a valid album URL: %q", slug)
+		}
+
+		id = u.Path
+	}
+
+	id = strings.ToLower(strings.Trim(id, "/"))
+	if id == "" {
+		return "", fmt.Errorf("SukiDesuOST: Not a valid album: %q", slug)
+	}
+
+	return id, nil
+}
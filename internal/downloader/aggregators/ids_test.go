@@ -0,0 +1,24 @@
+package aggregators
+
+import "testing"
+
+func TestDoujinstyleID(t *testing.T) {
+	for slug, want := range map[string]string{
+		"22816":   "22816",
+		" 22816 ": "22816",
+		"022816":  "22816",
+		"https://doujinstyle.com/?p=page&type=1&id=22816":  "22816",
+		"https://doujinstyle.com/?p=page&type=1&id=022816": "22816",
+	} {
+		id, err := DoujinstyleID(slug)
+		if err != nil || id != want {
+			t.Errorf("DoujinstyleID(%q): Expected %q, got %q (%v)", slug, want, id, err)
+		}
+	}
+
+	for _, slug := range []string{"", "album", "-1", "1e3", "https://doujinstyle.com/?p=page", "99999999999999999999"} {
+		if id, err := DoujinstyleID(slug); err == nil {
+			t.Errorf("DoujinstyleID(%q): Expected an error, got %q", slug, id)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package aggregators
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -8,6 +9,7 @@ import (
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
 )
 
 const (
@@ -54,28 +56,28 @@ func (s *SukiDesuOST) Page() playwright.Page {
 	return s.page
 }
 
-func (sdo *SukiDesuOST) Is404() (bool, error) {
+func (sdo *SukiDesuOST) Is404(ctx context.Context) (bool, error) {
 	valInterface, _ := sdo.page.Evaluate(
 		"document.querySelector('.jeg_404_content') ? true : false",
 	)
 	val, ok := valInterface.(bool)
 
 	if !ok {
-		return false, fmt.Errorf("Could not convert value: %v", val)
+		return false, dsdlerr.New(dsdlerr.SelectorBroken, "Could not convert value: %v", val)
 	}
 
 	return val, nil
 }
 
-func (sdo *SukiDesuOST) EvaluateFileName() (string, error) {
+func (sdo *SukiDesuOST) EvaluateFileName(ctx context.Context) (string, error) {
 	valInterface, err := sdo.page.Evaluate("document.querySelector('.jeg_post_title').innerText")
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	fn, ok := valInterface.(string)
 	if !ok {
-		return "", fmt.Errorf("%s %v", SDO_INVALID_TYPE_ERR, fn)
+		return "", dsdlerr.New(dsdlerr.SelectorBroken, "%s %v", SDO_INVALID_TYPE_ERR, fn)
 	}
 
 	filename := appUtils.SanitizePath(strings.ReplaceAll(fn, " – ", " — "))
@@ -99,11 +101,11 @@ func (sdo *SukiDesuOST) EvaluateFileName() (string, error) {
 	return filename, nil
 }
 
-func (sdo *SukiDesuOST) EvaluateFileExt() (string, error) {
+func (sdo *SukiDesuOST) EvaluateFileExt(ctx context.Context) (string, error) {
 	return "", fmt.Errorf(dsdl.AGGR_ERR_UNAVAILABLE_FT)
 }
 
-func (sdo *SukiDesuOST) EvaluateDownloadPage() (playwright.Page, error) {
+func (sdo *SukiDesuOST) EvaluateDownloadPage(ctx context.Context) (playwright.Page, error) {
 redoIfInvalid:
 	jsSelectors := []string{
 		"document.querySelector('.content-inner > ul > li > a').href",
@@ -139,10 +141,14 @@ redoIfInvalid:
 	}
 
 	if dlUrl == "" {
-		return nil, fmt.Errorf("Couldn't get a download URL")
+		return nil, dsdlerr.New(dsdlerr.SelectorBroken, "Couldn't get a download URL")
 	}
 
 	if strings.Contains(dlUrl, "cuty.io") {
+		if err := ctx.Err(); err != nil {
+			return nil, dsdlerr.FromContext(ctx)
+		}
+
 		_, _ = sdo.page.Reload()
 		goto redoIfInvalid
 	}
@@ -156,7 +162,13 @@ redoIfInvalid:
 		WaitUntil: playwright.WaitUntilStateDomcontentloaded,
 	})
 	if err != nil {
-		return nil, err
+		dlPage.Close()
+
+		if ctx.Err() != nil {
+			return nil, dsdlerr.FromContext(ctx)
+		}
+
+		return nil, dsdlerr.Wrap(dsdlerr.Network, err)
 	}
 
 	return dlPage, nil
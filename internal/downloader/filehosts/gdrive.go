@@ -1,6 +1,7 @@
 package filehosts
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 )
 
 type GDrive struct {
@@ -31,37 +34,42 @@ func (g *GDrive) Page() playwright.Page {
 	return g.page
 }
 
-func (g *GDrive) EvaluateFileName() (string, error) {
+func (g *GDrive) EvaluateFileName(ctx context.Context) (string, error) {
 	// TODO
 	return "", nil
 }
 
-func (g *GDrive) EvaluateFileExt() (string, error) {
+func (g *GDrive) EvaluateFileExt(ctx context.Context) (string, error) {
 	res, err := g.page.Evaluate(
 		"document.querySelector('a').innerText.split('.').toReversed()[0]",
 	)
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	return fmt.Sprintf("%v", res), nil
 }
 
-func (g *GDrive) Download(tempDir, finalDir, filename string, setProgress func(p int8)) error {
+func (g *GDrive) Download(
+	ctx context.Context,
+	tempDir, finalDir, filename string,
+	setProgress func(p progress.Progress),
+	checkpoint func(pd *appUtils.PartialDownload),
+) error {
 	pageUrl := g.page.URL()
 
 	_, err := g.page.Goto(
 		"https://drive.google.com/u/0/uc?id=" + strings.Split(pageUrl, "/")[5] + "&export=download",
 	)
 	if err != nil {
-		return err
+		return dsdlerr.Wrap(dsdlerr.Network, err)
 	}
 
 	err = g.page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
 		State: playwright.LoadStateDomcontentloaded,
 	})
 	if err != nil {
-		return err
+		return dsdlerr.Wrap(dsdlerr.Network, err)
 	}
 
 	finalFilepath := filepath.Join(finalDir, filename)
@@ -79,7 +87,7 @@ func (g *GDrive) Download(tempDir, finalDir, filename string, setProgress func(p
 		return err
 	}
 
-	err = appUtils.DownloadFile(dlUrl, tempDir, finalFilepath, setProgress)
+	err = appUtils.DownloadFile(ctx, dlUrl, tempDir, finalFilepath, setProgress, checkpoint)
 	if err != nil {
 		return err
 	}
@@ -97,7 +105,7 @@ func craftDirectDownloadLink(p playwright.Page) (string, error) {
 	querySelectorVal := func(eval string) (string, error) {
 		valInterface, err := p.Evaluate(eval)
 		if err != nil {
-			return "", err
+			return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 		}
 
 		val, _ := valInterface.(string)
@@ -0,0 +1,113 @@
+package filehosts
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Returns the path segments of a URL and its parsed form
+func splitURL(rawURL string) (*url.URL, []string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' }), nil
+}
+
+// Returns the segment following name in the path, or an empty string
+func segmentAfter(segments []string, name string) string {
+	for i, s := range segments[:max(len(segments)-1, 0)] {
+		if s == name {
+			return segments[i+1]
+		}
+	}
+
+	return ""
+}
+
+// Returns "file/<handle>" or "folder/<handle>" for both the current and the legacy (#!handle!key) mega links
+func MegaFileID(rawURL string) (string, error) {
+	u, segments, err := splitURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	for _, kind := range []string{"file", "folder"} {
+		if handle := segmentAfter(segments, kind); handle != "" {
+			return kind + "/" + handle, nil
+		}
+	}
+
+	if parts := strings.Split(u.Fragment, "!"); len(parts) >= 2 && parts[1] != "" {
+		switch parts[0] {
+		case "":
+			return "file/" + parts[1], nil
+		case "F":
+			return "folder/" + parts[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("Mega: No file in this URL: %q", rawURL)
+}
+
+// Returns "file/<key>" or "folder/<key>", also for the www.mediafire.com/?key links
+func MediafireFileID(rawURL string) (string, error) {
+	u, segments, err := splitURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	for _, kind := range []string{"file", "download", "folder"} {
+		if key := segmentAfter(segments, kind); key != "" {
+			if kind == "download" {
+				kind = "file"
+			}
+
+			return kind + "/" + key, nil
+		}
+	}
+
+	if len(segments) == 0 && u.RawQuery != "" && !strings.Contains(u.RawQuery, "=") {
+		return "file/" + u.RawQuery, nil
+	}
+
+	return "", fmt.Errorf("Mediafire: No file in this URL: %q", rawURL)
+}
+
+// Returns "file/<id>" or "folder/<id>" for the /file/d/<id>, /drive/folders/<id> and ?id=<id> links
+func GDriveFileID(rawURL string) (string, error) {
+	u, segments, err := splitURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if id := segmentAfter(segments, "d"); id != "" {
+		return "file/" + id, nil
+	}
+
+	if id := segmentAfter(segments, "folders"); id != "" {
+		return "folder/" + id, nil
+	}
+
+	if id := u.Query().Get("id"); id != "" {
+		return "file/" + id, nil
+	}
+
+	return "", fmt.Errorf("Google Drive: No file in this URL: %q", rawURL)
+}
+
+// Returns the share ID of the jottacloud.com/s/<id> links
+func JottacloudFileID(rawURL string) (string, error) {
+	_, segments, err := splitURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if id := segmentAfter(segments, "s"); id != "" {
+		return id, nil
+	}
+
+	return "", fmt.Errorf("Jottacloud: No share in this URL: %q", rawURL)
+}
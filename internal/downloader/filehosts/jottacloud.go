@@ -1,6 +1,7 @@
 package filehosts
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"time"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 )
 
 type Jottacloud struct {
@@ -31,7 +34,7 @@ func (j *Jottacloud) Page() playwright.Page {
 	return j.page
 }
 
-func (j *Jottacloud) EvaluateFileName() (string, error) {
+func (j *Jottacloud) EvaluateFileName(ctx context.Context) (string, error) {
 	selector := "[data-testid=FileViewerHeaderFileName]"
 
 	for {
@@ -46,20 +49,22 @@ func (j *Jottacloud) EvaluateFileName() (string, error) {
 			break
 		}
 
-		time.Sleep(time.Second * 1)
+		if err := appUtils.Sleep(ctx, time.Second); err != nil {
+			return "", err
+		}
 	}
 
 	res, err := j.page.Evaluate(
 		"document.querySelector('" + selector + "').childNodes[0].textContent.split('.').slice(0, -1).join('.')",
 	)
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	return fmt.Sprintf("%v", res), nil
 }
 
-func (j *Jottacloud) EvaluateFileExt() (string, error) {
+func (j *Jottacloud) EvaluateFileExt(ctx context.Context) (string, error) {
 	selector := "[data-testid=FileViewerHeaderFileName]"
 
 	for {
@@ -74,20 +79,27 @@ func (j *Jottacloud) EvaluateFileExt() (string, error) {
 			break
 		}
 
-		time.Sleep(time.Second * 1)
+		if err := appUtils.Sleep(ctx, time.Second); err != nil {
+			return "", err
+		}
 	}
 
 	res, err := j.page.Evaluate(
 		"document.querySelector('" + selector + "').childNodes[0].textContent.split('.').at(-1)",
 	)
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	return fmt.Sprintf(".%v", res), nil
 }
 
-func (j *Jottacloud) Download(tempDir, finalDir, filename string, setProgress func(p int8)) error {
+func (j *Jottacloud) Download(
+	ctx context.Context,
+	tempDir, finalDir, filename string,
+	setProgress func(p progress.Progress),
+	checkpoint func(pd *appUtils.PartialDownload),
+) error {
 	for {
 		res, err := j.page.Evaluate(
 			"() => document.querySelector('[data-testid=FileViewerHeaderFileName]')",
@@ -100,7 +112,9 @@ func (j *Jottacloud) Download(tempDir, finalDir, filename string, setProgress fu
 			break
 		}
 
-		time.Sleep(time.Second * 1)
+		if err := appUtils.Sleep(ctx, time.Second); err != nil {
+			return err
+		}
 	}
 
 	fp := filepath.Join(finalDir, filename)
@@ -114,14 +128,14 @@ func (j *Jottacloud) Download(tempDir, finalDir, filename string, setProgress fu
 
 	href, err := j.page.Evaluate("document.querySelector(\"a[download]\").href")
 	if err != nil {
-		return err
+		return dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 	downloadUrl, ok := href.(string)
 	if !ok {
-		return fmt.Errorf("Jottacloud: Couldn't get download url")
+		return dsdlerr.New(dsdlerr.SelectorBroken, "Jottacloud: Couldn't get download url")
 	}
 
-	err = appUtils.DownloadFile(downloadUrl, tempDir, fp, setProgress)
+	err = appUtils.DownloadFile(ctx, downloadUrl, tempDir, fp, setProgress, checkpoint)
 	if err != nil {
 		return err
 	}
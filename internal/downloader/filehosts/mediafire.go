@@ -1,6 +1,7 @@
 package filehosts
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 )
 
 type Mediafire struct {
@@ -39,21 +42,21 @@ func (m *Mediafire) Page() playwright.Page {
 	return m.page
 }
 
-func (m *Mediafire) EvaluateFileName() (string, error) {
+func (m *Mediafire) EvaluateFileName(ctx context.Context) (string, error) {
 	fn_intf, err := m.page.Evaluate("document.querySelector('.dl-btn-label').innerText")
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	fn, ok := fn_intf.(string)
 	if !ok {
-		return "", fmt.Errorf("Cannot convert data into string")
+		return "", dsdlerr.New(dsdlerr.SelectorBroken, "Cannot convert data into string")
 	}
 
 	return appUtils.CleanString(fn), nil
 }
 
-func (m *Mediafire) EvaluateFileExt() (string, error) {
+func (m *Mediafire) EvaluateFileExt(ctx context.Context) (string, error) {
 	// return m.page.Evaluate(`(() => {
 	//        let title = document.querySelector('.dl-btn-label').title
 	//        let innerText = document.querySelector('.dl-btn-label').innerText
@@ -68,19 +71,19 @@ func (m *Mediafire) EvaluateFileExt() (string, error) {
 		return "", nil
 	}
 
-	innerText, err := m.EvaluateFileName()
+	innerText, err := m.EvaluateFileName(ctx)
 	if err != nil {
 		return "", err
 	}
 
 	title_iface, err := m.page.Evaluate("document.querySelector('.dl-btn-label').title")
 	if err != nil {
-		return "", err
+		return "", dsdlerr.Wrap(dsdlerr.SelectorBroken, err)
 	}
 
 	title, ok := title_iface.(string)
 	if !ok {
-		return "", fmt.Errorf("Cannot convert data into string")
+		return "", dsdlerr.New(dsdlerr.SelectorBroken, "Cannot convert data into string")
 	}
 
 	ext := title[len(innerText)+1:]
@@ -88,10 +91,14 @@ func (m *Mediafire) EvaluateFileExt() (string, error) {
 	return ext, nil
 }
 
-func (m *Mediafire) Download(tempDir, finalDir, filename string, setProgress func(p int8),
+func (m *Mediafire) Download(
+	ctx context.Context,
+	tempDir, finalDir, filename string,
+	setProgress func(p progress.Progress),
+	checkpoint func(pd *appUtils.PartialDownload),
 ) error {
 	if !m.isFolder() {
-		err := m.downloadSingleFile(tempDir, finalDir, filename, setProgress)
+		err := m.downloadSingleFile(ctx, tempDir, finalDir, filename, setProgress, checkpoint)
 		return err
 	}
 
@@ -105,7 +112,19 @@ func (m *Mediafire) Download(tempDir, finalDir, filename string, setProgress fun
 	totalFiles := len(files)
 	downloadedFiles := 0
 
-	setProgress(0)
+	// the progress of the folder is the share of files already downloaded,
+	// plus the progress of the file being downloaded
+	folderProgress := func(p progress.Progress) {
+		p.FilesDone = downloadedFiles
+		p.FilesTotal = totalFiles
+
+		filePct := max(p.Percent(), 0)
+		p.Pct = int8((float64(downloadedFiles) + float64(filePct)/100) / float64(totalFiles) * 100)
+
+		setProgress(p)
+	}
+
+	folderProgress(progress.New(progress.Downloading))
 
 	for _, f := range files {
 		_, err = m.page.Goto(f.Url, playwright.PageGotoOptions{
@@ -113,7 +132,7 @@ func (m *Mediafire) Download(tempDir, finalDir, filename string, setProgress fun
 			WaitUntil: playwright.WaitUntilStateCommit,
 		})
 		if err != nil && strings.Contains(err.Error(), "Timeout") {
-			return err
+			return dsdlerr.Wrap(dsdlerr.Network, err)
 		}
 
 		folderExists := appUtils.DirectoryExists(f.Directory)
@@ -125,14 +144,21 @@ func (m *Mediafire) Download(tempDir, finalDir, filename string, setProgress fun
 
 		ok, _ := appUtils.FileExists(abs_filename)
 		if !ok {
-			err := m.downloadSingleFile(tempDir, f.Directory, f.Filename, func(p int8) {})
+			err := m.downloadSingleFile(
+				ctx,
+				tempDir,
+				f.Directory,
+				f.Filename,
+				folderProgress,
+				checkpoint,
+			)
 			if err != nil {
 				return err
 			}
 		}
 
 		downloadedFiles++
-		setProgress(int8((float64(downloadedFiles) / float64(totalFiles)) * 100))
+		folderProgress(progress.New(progress.Downloading))
 	}
 
 	return err
@@ -182,11 +208,14 @@ func (m *Mediafire) fetchFolderContent(
 		return nil, err
 	}
 	if apiData.Response.Result != "Success" {
-		return nil, fmt.Errorf("Mediafire API: Couldn't get folder content")
+		return nil, dsdlerr.New(dsdlerr.NotFound, "Mediafire API: Couldn't get folder content")
 	}
 
+	protected := 0
+
 	for _, f := range apiData.Response.FolderContent.Files {
 		if f.PasswordProtected != "no" {
+			protected++
 			continue
 		}
 
@@ -202,6 +231,15 @@ func (m *Mediafire) fetchFolderContent(
 
 	}
 
+	// skipping protected files is fine, as long as there's something else to download
+	if protected != 0 && protected == len(apiData.Response.FolderContent.Files) &&
+		len(apiData.Response.FolderContent.Folders) == 0 {
+		return nil, dsdlerr.New(
+			dsdlerr.PasswordProtected,
+			"Mediafire: Every file in this folder is password protected",
+		)
+	}
+
 	for _, folder := range apiData.Response.FolderContent.Folders {
 		if folder.Permissions.Read != "1" || folder.FileCount == "0" {
 			continue
@@ -221,8 +259,10 @@ func (m *Mediafire) fetchFolderContent(
 }
 
 func (m *Mediafire) downloadSingleFile(
+	ctx context.Context,
 	tempDir, finalDir, filename string,
-	setProgress func(p int8),
+	setProgress func(p progress.Progress),
+	checkpoint func(pd *appUtils.PartialDownload),
 ) error {
 	// file is still in upload status?
 	for {
@@ -237,7 +277,9 @@ func (m *Mediafire) downloadSingleFile(
 			break
 		}
 
-		time.Sleep(time.Second * 5)
+		if err := appUtils.Sleep(ctx, time.Second*5); err != nil {
+			return err
+		}
 	}
 
 	finalFilepath := filepath.Join(finalDir, filename)
@@ -256,7 +298,8 @@ func (m *Mediafire) downloadSingleFile(
 
 	for {
 		if retryThreshold <= 0 {
-			return fmt.Errorf(
+			return dsdlerr.New(
+				dsdlerr.SelectorBroken,
 				"Mediafire.downloadSingleFile: Threshold exceeded: could not fetch the download url in time",
 			)
 		}
@@ -268,13 +311,15 @@ func (m *Mediafire) downloadSingleFile(
 		if err != nil {
 			retryThreshold--
 
-			time.Sleep(1 * time.Second)
+			if err := appUtils.Sleep(ctx, time.Second); err != nil {
+				return err
+			}
 
 			continue
 		}
 		url, ok := href.(string)
 		if !ok {
-			return fmt.Errorf("Mediafire: Couldn't get download url")
+			return dsdlerr.New(dsdlerr.SelectorBroken, "Mediafire: Couldn't get download url")
 		}
 
 		downloadUrl = url
@@ -283,10 +328,12 @@ func (m *Mediafire) downloadSingleFile(
 	}
 
 	err = appUtils.DownloadFile(
+		ctx,
 		downloadUrl,
 		tempDir,
 		finalFilepath,
 		setProgress,
+		checkpoint,
 	)
 	if err != nil {
 		return err
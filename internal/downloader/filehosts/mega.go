@@ -1,15 +1,20 @@
 package filehosts
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/playwright-community/playwright-go"
 
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 )
 
 type Mega struct {
@@ -32,7 +37,24 @@ func (m *Mega) Page() playwright.Page {
 	return m.page
 }
 
-func (m *Mega) waitForPageLoad() error {
+// Guesses the category of an error shown by the mega web client
+func megaErrCategory(msg string) dsdlerr.Category {
+	msg = strings.ToLower(msg)
+
+	switch {
+	case strings.Contains(msg, "quota") || strings.Contains(msg, "bandwidth"):
+		return dsdlerr.QuotaExceeded
+	case strings.Contains(msg, "unavailable") || strings.Contains(msg, "no longer available") ||
+		strings.Contains(msg, "not found"):
+		return dsdlerr.NotFound
+	case strings.Contains(msg, "decryption key") || strings.Contains(msg, "password"):
+		return dsdlerr.PasswordProtected
+	default:
+		return dsdlerr.Unknown
+	}
+}
+
+func (m *Mega) waitForPageLoad(ctx context.Context) error {
 	for {
 		val, _ := m.page.Evaluate(
 			"() => document.querySelector('#loading').classList.contains('hidden')",
@@ -52,18 +74,20 @@ func (m *Mega) waitForPageLoad() error {
 			"document.querySelectorAll('.fm-empty-cloud-txt')[2].innerText",
 		)
 		if err != nil {
-			return fmt.Errorf("mega: %v", val)
+			return dsdlerr.New(dsdlerr.NotFound, "mega: %v", val)
 		}
 
-		time.Sleep(time.Second * 5)
+		if err := appUtils.Sleep(ctx, time.Second*5); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 // TODO: implement this function
-func (m *Mega) EvaluateFileName() (string, error) {
-	err := m.waitForPageLoad()
+func (m *Mega) EvaluateFileName(ctx context.Context) (string, error) {
+	err := m.waitForPageLoad(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -71,8 +95,8 @@ func (m *Mega) EvaluateFileName() (string, error) {
 	return "", nil
 }
 
-func (m *Mega) EvaluateFileExt() (string, error) {
-	err := m.waitForPageLoad()
+func (m *Mega) EvaluateFileExt(ctx context.Context) (string, error) {
+	err := m.waitForPageLoad(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -87,8 +111,13 @@ func (m *Mega) EvaluateFileExt() (string, error) {
 	return fmt.Sprintf("%v", ext)[1:], nil
 }
 
-func (m *Mega) Download(tempDir, finalDir, filename string, setProgress func(p int8)) error {
-	err := m.waitForPageLoad()
+func (m *Mega) Download(
+	ctx context.Context,
+	tempDir, finalDir, filename string,
+	setProgress func(p progress.Progress),
+	checkpoint func(pd *appUtils.PartialDownload),
+) error {
+	err := m.waitForPageLoad(ctx)
 	if err != nil {
 		return err
 	}
@@ -106,9 +135,16 @@ func (m *Mega) Download(tempDir, finalDir, filename string, setProgress func(p i
 		isHidden2, _ := val.(bool)
 
 		if isHidden1 || isHidden2 {
-			time.Sleep(500 * time.Millisecond)
 			break
 		}
+
+		if err := appUtils.Sleep(ctx, 100*time.Millisecond); err != nil {
+			return err
+		}
+	}
+
+	if err := appUtils.Sleep(ctx, 500*time.Millisecond); err != nil {
+		return err
 	}
 
 	// limited quota
@@ -144,15 +180,15 @@ func (m *Mega) Download(tempDir, finalDir, filename string, setProgress func(p i
 			return false
 		}`)
 		if err != nil {
-			return fmt.Errorf("Mega: Couldn't start download: %v", err)
+			return dsdlerr.New(dsdlerr.SelectorBroken, "Mega: Couldn't start download: %v", err)
 		}
 
 		downloadStarted, ok := val.(bool)
 		if !ok {
-			return fmt.Errorf("Mega: couldn't cast selector parsing result")
+			return dsdlerr.New(dsdlerr.SelectorBroken, "Mega: couldn't cast selector parsing result")
 		}
 		if !downloadStarted {
-			return fmt.Errorf("Mega: couldn't evaluate download selectors")
+			return dsdlerr.New(dsdlerr.SelectorBroken, "Mega: couldn't evaluate download selectors")
 		}
 
 		errorDiv := m.page.Locator(".default-warning > .txt")
@@ -160,7 +196,10 @@ func (m *Mega) Download(tempDir, finalDir, filename string, setProgress func(p i
 		re := regexp.MustCompile(`\d+`)
 
 		for {
-			time.Sleep(1 * time.Second)
+			if err := appUtils.Sleep(ctx, time.Second); err != nil {
+				return err
+			}
+
 			val, _ := m.page.Evaluate(
 				"() => document.querySelector('.transfer-task-status').innerText",
 			)
@@ -174,7 +213,7 @@ func (m *Mega) Download(tempDir, finalDir, filename string, setProgress func(p i
 			visible, _ := errorDiv.IsVisible()
 			if visible {
 				errVal, _ := errorDiv.InnerText()
-				return fmt.Errorf("%v", errVal)
+				return dsdlerr.New(megaErrCategory(errVal), "%v", errVal)
 			}
 
 			// Empty folder
@@ -183,7 +222,7 @@ func (m *Mega) Download(tempDir, finalDir, filename string, setProgress func(p i
 			)
 			msgVal, _ := msg.(string)
 			if msgVal != "" {
-				return fmt.Errorf("Mega: %s", msgVal)
+				return dsdlerr.New(dsdlerr.NotFound, "Mega: %s", msgVal)
 			}
 
 			// Folder too big to download within the browser
@@ -208,7 +247,9 @@ func (m *Mega) Download(tempDir, finalDir, filename string, setProgress func(p i
 				continue
 			}
 
-			setProgress(int8(conv))
+			p := progress.New(progress.Downloading)
+			p.Pct = int8(conv)
+			setProgress(p)
 		}
 
 		return err
@@ -0,0 +1,77 @@
+package dsdl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+var (
+	ErrInvalidAggregator = errors.New("Not a valid service")
+	ErrMissingSlug       = errors.New("An Album Slug is required")
+	ErrInvalidSlug       = errors.New("Not a valid Album Slug")
+	// Wrapped by DuplicateError, returned when the album is already stored
+	ErrDuplicateTask = db.ErrDuplicate
+)
+
+// Returns whether an aggregator with this name is in the list
+func (a Aggregators) Has(name string) bool {
+	return a.Get(name) != nil
+}
+
+/*
+Validates an album and queues it as a new task of the aggregator.
+
+The slug can also be the full URL of the album page: both are turned into the same
+album ID, so an album can't be queued twice. Returns ErrInvalidAggregator,
+ErrMissingSlug, ErrInvalidSlug or a *DuplicateError when the album can't be added.
+*/
+func AddTask(store TaskStore, aggregators Aggregators, aggregator, slug string) (*task.Task, error) {
+	aggregator = strings.TrimSpace(aggregator)
+	slug = strings.TrimSpace(slug)
+
+	aggr := aggregators.Get(aggregator)
+	if aggregator == "" || aggr == nil {
+		return nil, ErrInvalidAggregator
+	}
+
+	if slug == "" {
+		return nil, ErrMissingSlug
+	}
+
+	albumID, err := aggr.AlbumID(slug)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlug, err)
+	}
+
+	if _, err := store.GetByAlbum(aggregator, albumID); err == nil {
+		return nil, duplicateOf(store, aggregator, albumID)
+	}
+
+	t := task.NewTask(slug)
+	t.Aggregator = aggregator
+	t.AlbumID = albumID
+
+	if strings.HasPrefix(slug, "http") {
+		t.AggregatorPageURL = slug
+	}
+
+	if _, err := store.Insert(t); err != nil {
+		// added by someone else in the meantime
+		if errors.Is(err, ErrDuplicateTask) {
+			return nil, duplicateOf(store, aggregator, albumID)
+		}
+
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Validates an album and queues it as a new task, see AddTask
+func (dsdl *DSDL) AddTask(aggregator, slug string) (*task.Task, error) {
+	return AddTask(dsdl.db, dsdl.aggregators, aggregator, slug)
+}
@@ -0,0 +1,83 @@
+package dsdl
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+)
+
+func TestAddTaskDuplicates(t *testing.T) {
+	store := db.NewJSONFile(filepath.Join(t.TempDir(), "tasks.json"))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	aggregators := Aggregators{{
+		Name: "doujinstyle",
+		Canonicalize: func(slug string) (string, error) {
+			_, id, _ := strings.Cut(slug, "id=")
+			if id == "" {
+				id = slug
+			}
+
+			if strings.Trim(id, "0123456789") != "" {
+				return "", errors.New("not a number")
+			}
+
+			return id, nil
+		},
+	}}
+
+	added, err := AddTask(store, aggregators, "doujinstyle", "22816")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added.AlbumID != "22816" {
+		t.Errorf("Expected the album ID 22816, got %q", added.AlbumID)
+	}
+
+	if _, err := AddTask(store, aggregators, "doujinstyle", "album"); !errors.Is(err, ErrInvalidSlug) {
+		t.Errorf("Expected ErrInvalidSlug, got %v", err)
+	}
+
+	_, err = AddTask(store, aggregators, "doujinstyle", "https://doujinstyle.com/?p=page&type=1&id=22816")
+
+	var dup *DuplicateError
+	if !errors.As(err, &dup) || !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("Expected a DuplicateError, got %v", err)
+	}
+
+	if dup.Existing == nil || dup.Existing.Id != added.Id {
+		t.Fatalf("Expected task %s to be the existing one, got %+v", added.Id, dup.Existing)
+	}
+
+	for state, reason := range map[int]string{
+		states.TASK_STATE_QUEUED:    "already queued",
+		states.TASK_STATE_RUNNING:   "already queued",
+		states.TASK_STATE_COMPLETED: "already downloaded",
+		states.TASK_STATE_SKIPPED:   "already downloaded",
+		states.TASK_STATE_FAILED:    "failed earlier, retry?",
+		states.TASK_STATE_CANCELED:  "failed earlier, retry?",
+	} {
+		dup.Existing.DownloadState = state
+
+		if got := dup.Reason(); got != reason {
+			t.Errorf("%s: expected %q, got %q", states.GetStateStr(state), reason, got)
+		}
+	}
+
+	tasks, err := store.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tasks) != 1 {
+		t.Errorf("Expected a single stored task, got %d", len(tasks))
+	}
+}
@@ -1,16 +1,21 @@
 package dsdl
 
-import "github.com/playwright-community/playwright-go"
+import (
+	"context"
+
+	"github.com/playwright-community/playwright-go"
+)
 
 type AggregatorImpl interface {
 	PwPageNavigator
 
 	Url() string
 	Slug() string
-	Is404() (bool, error)
-	EvaluateFileName() (string, error)
-	EvaluateFileExt() (string, error)
-	EvaluateDownloadPage() (playwright.Page, error)
+	// Every method gives up as soon as ctx is cancelled
+	Is404(ctx context.Context) (bool, error)
+	EvaluateFileName(ctx context.Context) (string, error)
+	EvaluateFileExt(ctx context.Context) (string, error)
+	EvaluateDownloadPage(ctx context.Context) (playwright.Page, error)
 }
 
 // alias for
@@ -26,4 +31,7 @@ type Aggregator struct {
 	Constructor AggregatorConstrFn
 	// regexes tested against url
 	AllowedUrlWildcards []string
+	// returns the ID of the album referred to by a slug or an URL, the same for every way of
+	// referring to it. The slug is used as it is if nil
+	Canonicalize func(slug string) (string, error)
 }
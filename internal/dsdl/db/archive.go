@@ -0,0 +1,273 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Ended tasks moved out of the queue by the retention, kept as history
+const ARCHIVE_TABLE_NAME string = "dsdl_archive"
+
+// Columns copied as they are between the tasks table and the archive
+const storedColumns = `
+	ID,
+	Aggregator,
+	Slug,
+	AggregatorPageURL,
+	FilehostUrl,
+	DisplayName,
+	Filename,
+	DownloadState,
+	Err,
+	ErrCategory,
+	Attempts,
+	NextRetryAt,
+	Priority,
+	Position,
+	CreatedAt,
+	StartedAt,
+	FinishedAt,
+	Tags,
+	AlbumID,
+	Filehost,
+	FileID,
+	AcknowledgedAt`
+
+// Returned when acknowledging a task that hasn't failed
+var ErrNotAcknowledgeable = errors.New("DB: Only failed and canceled tasks can be acknowledged")
+
+/*
+Returns whether the retention lets an ended task go: successes ended before
+successBefore, unless it is zero, and failures acknowledged after they ended.
+
+Tasks added before their end time was recorded are aged by their creation time
+*/
+func isExpired(state int, finishedAt, createdAt, acknowledgedAt int64, successBefore time.Time) bool {
+	switch state {
+	case states.TASK_STATE_COMPLETED, states.TASK_STATE_SKIPPED:
+		if successBefore.IsZero() {
+			return false
+		}
+
+		if finishedAt == 0 {
+			finishedAt = createdAt
+		}
+
+		return finishedAt < successBefore.UnixMilli()
+
+	case states.TASK_STATE_FAILED, states.TASK_STATE_CANCELED:
+		return acknowledgedAt != 0 && acknowledgedAt >= finishedAt
+
+	default:
+		return false
+	}
+}
+
+// Marks a failed or canceled task as seen by the user, letting the retention archive it
+func (sdb *SQLiteDB) Acknowledge(id string) error {
+	res, err := sdb.db.Exec(
+		`UPDATE `+TABLE_NAME+` SET AcknowledgedAt = ? WHERE ID = ? AND DownloadState IN (?, ?)`,
+		time.Now().UnixMilli(),
+		id,
+		states.TASK_STATE_FAILED,
+		states.TASK_STATE_CANCELED,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		if _, err := sdb.Get(id); err != nil {
+			return err
+		}
+
+		return ErrNotAcknowledgeable
+	}
+
+	return nil
+}
+
+/*
+Moves the expired ended tasks to the archive: the successes ended before successBefore,
+none if it is zero, and the failures acknowledged after they ended.
+
+Returns the number of archived tasks
+*/
+func (sdb *SQLiteDB) Archive(successBefore time.Time) (int, error) {
+	expired := fmt.Sprintf(
+		`(DownloadState IN (%d, %d) AND AcknowledgedAt <> 0 AND AcknowledgedAt >= FinishedAt)`,
+		states.TASK_STATE_FAILED,
+		states.TASK_STATE_CANCELED,
+	)
+
+	if !successBefore.IsZero() {
+		expired += fmt.Sprintf(
+			` OR (DownloadState IN (%d, %d) AND (CASE FinishedAt WHEN 0 THEN CreatedAt ELSE FinishedAt END) < %d)`,
+			states.TASK_STATE_COMPLETED,
+			states.TASK_STATE_SKIPPED,
+			successBefore.UnixMilli(),
+		)
+	}
+
+	tx, err := sdb.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO `+ARCHIVE_TABLE_NAME+` (`+storedColumns+`, ArchivedAt)
+		SELECT `+storedColumns+`, ? FROM `+TABLE_NAME+` WHERE `+expired,
+		time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM ` + TABLE_NAME + ` WHERE ` + expired); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(count), sdb.pruneIndex()
+}
+
+// Returns an archived task, or sql.ErrNoRows if it isn't in the archive
+func (sdb *SQLiteDB) GetArchived(id string) (*task.Task, error) {
+	var archivedAt int64
+
+	t, err := scanTask(
+		sdb.db.QueryRowx(
+			`SELECT `+taskColumns+`, ArchivedAt FROM `+ARCHIVE_TABLE_NAME+` WHERE ID = ?`,
+			id,
+		),
+		&archivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	t.ArchivedAt = fromUnixMilli(archivedAt)
+
+	return t, nil
+}
+
+/*
+Returns up to limit archived tasks matching the search, every match if limit is 0,
+the most recently archived first. An empty search matches every archived task.
+
+The archive is not part of the full-text index: searches scan it
+*/
+func (sdb *SQLiteDB) SearchArchive(text string, limit int) ([]*task.Task, error) {
+	var terms []searchTerm
+
+	if strings.TrimSpace(text) != "" {
+		var err error
+
+		terms, err = parseSearch(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := sdb.db.Queryx(
+		`SELECT ` + taskColumns + `, ArchivedAt
+		FROM ` + ARCHIVE_TABLE_NAME + `
+		ORDER BY ArchivedAt DESC, ID DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dest := make([]*task.Task, 0)
+
+	for rows.Next() && (limit <= 0 || len(dest) < limit) {
+		var archivedAt int64
+
+		t, err := scanTask(rows, &archivedAt)
+		if err != nil {
+			return dest, err
+		}
+
+		t.ArchivedAt = fromUnixMilli(archivedAt)
+
+		if terms == nil || matchesSearch(terms, t) {
+			dest = append(dest, t)
+		}
+	}
+
+	return dest, rows.Err()
+}
+
+/*
+Moves an archived task back to the end of the queue, to be downloaded again.
+Its attempts and timeline are kept.
+
+Returns sql.ErrNoRows if the task isn't in the archive, and ErrDuplicate if its
+album has been queued again in the meantime
+*/
+func (sdb *SQLiteDB) Unarchive(id string) (*task.Task, error) {
+	tx, err := sdb.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO `+TABLE_NAME+` (`+storedColumns+`)
+		SELECT
+			ID, Aggregator, Slug, AggregatorPageURL, FilehostUrl, DisplayName, Filename,
+			?, '', '', 0, 0, Priority,
+			(SELECT COALESCE(MAX(Position), 0) + 1 FROM `+TABLE_NAME+`),
+			CreatedAt, 0, 0, Tags, AlbumID, '', '', 0
+		FROM `+ARCHIVE_TABLE_NAME+`
+		WHERE ID = ?`,
+		states.TASK_STATE_QUEUED,
+		id,
+	)
+	if err != nil {
+		return nil, duplicateErr(err)
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM `+ARCHIVE_TABLE_NAME+` WHERE ID = ?`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := sdb.indexTask(id); err != nil {
+		return nil, err
+	}
+
+	sdb.notifyQueue()
+
+	return sdb.Get(id)
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+func TestArchive(t *testing.T) {
+	forEachStore(t, testArchive)
+}
+
+func testArchive(t *testing.T, db taskStore) {
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	ids := make(map[string]string)
+
+	for _, spec := range []struct {
+		name     string
+		state    int
+		finished time.Time
+	}{
+		{"old success", states.TASK_STATE_COMPLETED, now.Add(-10 * 24 * time.Hour)},
+		{"recent success", states.TASK_STATE_COMPLETED, now.Add(-time.Hour)},
+		{"old skipped", states.TASK_STATE_SKIPPED, now.Add(-10 * 24 * time.Hour)},
+		{"old failure", states.TASK_STATE_FAILED, now.Add(-10 * 24 * time.Hour)},
+		{"acknowledged failure", states.TASK_STATE_FAILED, now.Add(-time.Hour)},
+		{"queued", states.TASK_STATE_QUEUED, time.Time{}},
+	} {
+		tsk := task.NewTask(spec.name)
+		tsk.DisplayName = spec.name
+		tsk.AlbumID = spec.name
+		tsk.DownloadState = spec.state
+		tsk.FinishedAt = spec.finished
+
+		if _, err := db.Insert(tsk); err != nil {
+			t.Fatal(err)
+		}
+
+		ids[spec.name] = tsk.Id
+	}
+
+	if err := db.AddEvent(ids["old success"], &task.Event{Kind: task.EventFinished}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Acknowledge(ids["acknowledged failure"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Acknowledge(ids["queued"]); !errors.Is(err, ErrNotAcknowledgeable) {
+		t.Errorf("Acknowledge: Expected ErrNotAcknowledgeable for a queued task, got %v", err)
+	}
+
+	if err := db.Acknowledge("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Acknowledge: Expected sql.ErrNoRows for a missing task, got %v", err)
+	}
+
+	// a zero time only archives the acknowledged failures
+	if count, err := db.Archive(time.Time{}); err != nil || count != 1 {
+		t.Fatalf("Archive: Expected 1 archived task, got %d (%v)", count, err)
+	}
+
+	if count, err := db.Archive(now.Add(-7 * 24 * time.Hour)); err != nil || count != 2 {
+		t.Fatalf("Archive: Expected 2 archived tasks, got %d (%v)", count, err)
+	}
+
+	for name, archived := range map[string]bool{
+		"old success":          true,
+		"recent success":       false,
+		"old skipped":          true,
+		"old failure":          false,
+		"acknowledged failure": true,
+		"queued":               false,
+	} {
+		_, getErr := db.Get(ids[name])
+		found, archivedErr := db.GetArchived(ids[name])
+
+		if archived != (archivedErr == nil) || archived != errors.Is(getErr, sql.ErrNoRows) {
+			t.Errorf("%s: expected archived to be %v (Get: %v, GetArchived: %v)", name, archived, getErr, archivedErr)
+		}
+
+		if archived && found.ArchivedAt.IsZero() {
+			t.Errorf("%s: expected the archiving time to be set", name)
+		}
+	}
+
+	// the history of archived tasks is kept
+	if removed, err := db.RemoveOrphanEvents(); err != nil || removed != 0 {
+		t.Errorf("RemoveOrphanEvents: Expected no removed event, got %d (%v)", removed, err)
+	}
+
+	found, err := db.SearchArchive("", 0)
+	if err != nil || len(found) != 3 {
+		t.Fatalf("SearchArchive: Expected 3 archived tasks, got %d (%v)", len(found), err)
+	}
+
+	found, err = db.SearchArchive("old", 0)
+	if err != nil || len(found) != 2 {
+		t.Fatalf("SearchArchive: Expected 2 matching tasks, got %d (%v)", len(found), err)
+	}
+
+	// the archived album is free to be queued again, so unarchiving it is a duplicate
+	again := task.NewTask("old success")
+	again.AlbumID = "old success"
+
+	if _, err := db.Insert(again); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Unarchive(ids["old success"]); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Unarchive: Expected ErrDuplicate, got %v", err)
+	}
+
+	restored, err := db.Unarchive(ids["acknowledged failure"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.DownloadState != states.TASK_STATE_QUEUED || restored.Err != nil ||
+		!restored.AcknowledgedAt.IsZero() || !restored.FinishedAt.IsZero() {
+		t.Errorf("Unarchive: Expected a fresh queued task, got %+v", restored)
+	}
+
+	if _, err := db.GetArchived(ids["acknowledged failure"]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Unarchive: Expected the task to leave the archive, got %v", err)
+	}
+
+	if _, err := db.Unarchive("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Unarchive: Expected sql.ErrNoRows, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Records the start of a new attempt of a task
+//
+// Returns the ID of the stored attempt, to be passed to EndAttempt
+func (sdb *SQLiteDB) StartAttempt(taskID string, number int) (int64, error) {
+	res, err := sdb.db.Exec(
+		`INSERT INTO `+ATTEMPTS_TABLE_NAME+` (TaskID, Attempt, StartedAt) VALUES (?, ?, ?)`,
+		taskID,
+		number,
+		time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// Records the end of an attempt, along with the error that ended it (if any)
+func (sdb *SQLiteDB) EndAttempt(attemptID int64, attemptErr error) error {
+	dbErr := ""
+	if attemptErr != nil {
+		dbErr = attemptErr.Error()
+	}
+
+	_, err := sdb.db.Exec(
+		`UPDATE `+ATTEMPTS_TABLE_NAME+` SET EndedAt = ?, Err = ? WHERE ID = ?`,
+		time.Now().UnixMilli(),
+		dbErr,
+		attemptID,
+	)
+
+	return err
+}
+
+// Returns every recorded attempt of a task, oldest first
+func (sdb *SQLiteDB) GetAttempts(taskID string) ([]*task.Attempt, error) {
+	dest := make([]*task.Attempt, 0)
+
+	rows, err := sdb.db.Query(
+		`SELECT Attempt, StartedAt, EndedAt, Err
+		FROM `+ATTEMPTS_TABLE_NAME+`
+		WHERE TaskID = ?
+		ORDER BY ID`,
+		taskID,
+	)
+	if err != nil {
+		return dest, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := new(task.Attempt)
+		var startedAt, endedAt int64
+
+		err := rows.Scan(&a.Number, &startedAt, &endedAt, &a.Err)
+		if err != nil {
+			return dest, err
+		}
+
+		a.StartedAt = fromUnixMilli(startedAt)
+		a.EndedAt = fromUnixMilli(endedAt)
+
+		dest = append(dest, a)
+	}
+
+	return dest, rows.Err()
+}
+
+// Removes the attempts whose task has been removed from the database, keeping those of archived tasks
+//
+// Returns the number of removed attempts
+func (sdb *SQLiteDB) RemoveOrphanAttempts() (int, error) {
+	res, err := sdb.db.Exec(
+		`DELETE FROM ` + ATTEMPTS_TABLE_NAME + `
+		WHERE TaskID NOT IN (SELECT ID FROM ` + TABLE_NAME + ` UNION ALL SELECT ID FROM ` + ARCHIVE_TABLE_NAME + `
+			UNION ALL SELECT ID FROM ` + TRASH_TABLE_NAME + `)`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+
+	return int(count), err
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Appends an event to the timeline of a task. A zero e.At is set to the current time
+func (sdb *SQLiteDB) AddEvent(taskID string, e *task.Event) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	_, err := sdb.db.Exec(
+		`INSERT INTO `+EVENTS_TABLE_NAME+` (TaskID, At, Kind, Message, Bytes) VALUES (?, ?, ?, ?, ?)`,
+		taskID,
+		e.At.UnixMilli(),
+		string(e.Kind),
+		e.Message,
+		e.Bytes,
+	)
+
+	return err
+}
+
+// Returns the timeline of a task, oldest event first
+func (sdb *SQLiteDB) GetEvents(taskID string) ([]*task.Event, error) {
+	dest := make([]*task.Event, 0)
+
+	rows, err := sdb.db.Query(
+		`SELECT At, Kind, Message, Bytes
+		FROM `+EVENTS_TABLE_NAME+`
+		WHERE TaskID = ?
+		ORDER BY ID`,
+		taskID,
+	)
+	if err != nil {
+		return dest, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := new(task.Event)
+		var at int64
+
+		err := rows.Scan(&at, &e.Kind, &e.Message, &e.Bytes)
+		if err != nil {
+			return dest, err
+		}
+
+		e.At = fromUnixMilli(at)
+
+		dest = append(dest, e)
+	}
+
+	return dest, rows.Err()
+}
+
+// Removes the events whose task has been removed from the database, keeping those of archived tasks
+//
+// Returns the number of removed events
+func (sdb *SQLiteDB) RemoveOrphanEvents() (int, error) {
+	res, err := sdb.db.Exec(
+		`DELETE FROM ` + EVENTS_TABLE_NAME + `
+		WHERE TaskID NOT IN (SELECT ID FROM ` + TABLE_NAME + ` UNION ALL SELECT ID FROM ` + ARCHIVE_TABLE_NAME + `
+			UNION ALL SELECT ID FROM ` + TRASH_TABLE_NAME + `)`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+
+	return int(count), err
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+func TestIdentity(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db taskStore) {
+		if err := db.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		first := task.NewTask("22816")
+		first.Aggregator = "doujinstyle"
+		first.AlbumID = "22816"
+
+		if _, err := db.Insert(first); err != nil {
+			t.Fatal(err)
+		}
+
+		byURL := task.NewTask("https://doujinstyle.com/?p=page&type=1&id=22816")
+		byURL.Aggregator = "doujinstyle"
+		byURL.AlbumID = "22816"
+
+		if _, err := db.Insert(byURL); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("Insert: Expected ErrDuplicate for the same album, got %v", err)
+		}
+
+		// the same ID on another aggregator is another album
+		other := task.NewTask("22816")
+		other.Aggregator = "sukidesuost"
+		other.AlbumID = "22816"
+
+		if _, err := db.Insert(other); err != nil {
+			t.Fatalf("Insert: Expected the album of another aggregator to be stored, got %v", err)
+		}
+
+		// tasks without an identity never conflict
+		for range 2 {
+			if _, err := db.Insert(task.NewTask("legacy")); err != nil {
+				t.Fatalf("Insert: Expected tasks without an album ID to be stored, got %v", err)
+			}
+		}
+
+		found, err := db.GetByAlbum("doujinstyle", "22816")
+		if err != nil || found.Id != first.Id {
+			t.Fatalf("GetByAlbum: Expected %s, got %v (%v)", first.Id, found, err)
+		}
+
+		if _, err := db.GetByAlbum("doujinstyle", "1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetByAlbum: Expected sql.ErrNoRows, got %v", err)
+		}
+
+		first.Filehost = "Mega"
+		first.FileID = "file/abc"
+
+		if err := db.Update(first); err != nil {
+			t.Fatal(err)
+		}
+
+		other.Filehost = "Mega"
+		other.FileID = "file/abc"
+
+		if err := db.Update(other); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("Update: Expected ErrDuplicate for the same file, got %v", err)
+		}
+
+		found, err = db.GetByFile("Mega", "file/abc")
+		if err != nil || found.Id != first.Id {
+			t.Fatalf("GetByFile: Expected %s, got %v (%v)", first.Id, found, err)
+		}
+
+		if found, count, err := db.Find("legacy"); err != nil || !found || count != 2 {
+			t.Fatalf("Find: Expected 2 tasks with the slug, got %v %d (%v)", found, count, err)
+		}
+
+		if found, _, err := db.Find("missing"); err != nil || found {
+			t.Fatalf("Find: Expected no task, got %v (%v)", found, err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Creates or replaces the download journal of a task
+func (sdb *SQLiteDB) SaveJournal(j *task.Journal) error {
+	_, err := sdb.db.NamedExec(`
+		INSERT OR REPLACE INTO `+JOURNAL_TABLE_NAME+` (
+			TaskID,
+			TempFilepath,
+			BytesWritten,
+			ETag,
+			LastModified,
+			FilehostUrl
+		)
+		VALUES (:TaskID, :TempFilepath, :BytesWritten, :ETag, :LastModified, :FilehostUrl)
+	`, j)
+
+	return err
+}
+
+// Returns the download journal of a task.
+//
+// Returns a nil journal without errors if the task has nothing to resume
+func (sdb *SQLiteDB) GetJournal(taskID string) (*task.Journal, error) {
+	j := new(task.Journal)
+
+	err := sdb.db.Get(j, `SELECT * FROM `+JOURNAL_TABLE_NAME+` WHERE TaskID = ?`, taskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Journals whose task is neither in the queue nor in the trash, where an undo could
+// bring it back and resume its download
+const orphanJournals = `TaskID NOT IN (SELECT ID FROM ` + TABLE_NAME + ` UNION ALL SELECT ID FROM ` + TRASH_TABLE_NAME + `)`
+
+// Returns the download journals of every task still stored in the database or in the trash
+func (sdb *SQLiteDB) GetAllJournals() ([]*task.Journal, error) {
+	dest := make([]*task.Journal, 0)
+
+	err := sdb.db.Select(&dest, `SELECT * FROM `+JOURNAL_TABLE_NAME+` WHERE NOT (`+orphanJournals+`)`)
+
+	return dest, err
+}
+
+// Removes the download journal of a task
+func (sdb *SQLiteDB) RemoveJournal(taskID string) error {
+	_, err := sdb.db.Exec(`DELETE FROM `+JOURNAL_TABLE_NAME+` WHERE TaskID = ?`, taskID)
+
+	return err
+}
+
+// Removes the journals whose task has been deleted for good, neither stored nor in the trash
+//
+// Returns the removed journals, so that their partial files can be deleted as well
+func (sdb *SQLiteDB) RemoveOrphanJournals() ([]*task.Journal, error) {
+	tx, err := sdb.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	orphans := make([]*task.Journal, 0)
+
+	err = tx.Select(&orphans, `SELECT * FROM `+JOURNAL_TABLE_NAME+` WHERE `+orphanJournals)
+	if err != nil || len(orphans) == 0 {
+		return orphans, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM ` + JOURNAL_TABLE_NAME + ` WHERE ` + orphanJournals); err != nil {
+		return nil, err
+	}
+
+	return orphans, tx.Commit()
+}
@@ -0,0 +1,1645 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Default location of the JSON file database
+var DEFAULT_JSON_PATH = filepath.Join(".", "Database", "tasks.json")
+
+// Version of the layout of the JSON file
+const jsonFileVersion = 2
+
+// Delay of the save of the changes that are cheap to lose, like checkpoints and events,
+// so that a running download doesn't rewrite the whole file every few seconds
+const jsonSaveDelay = 5 * time.Second
+
+// Stored representation of a task, mirroring the columns of the SQLite table
+type taskRecord struct {
+	ID                string
+	Aggregator        string
+	Slug              string
+	AggregatorPageURL string
+	FilehostUrl       string
+	DisplayName       string
+	Filename          string
+	DownloadState     int
+	Err               string
+	ErrCategory       string
+	Attempts          int
+	NextRetryAt       int64
+	Priority          int
+	Position          int64
+	CreatedAt         int64
+	StartedAt         int64
+	FinishedAt        int64
+	Tags              []string `json:",omitempty"`
+	AlbumID           string   `json:",omitempty"`
+	Filehost          string   `json:",omitempty"`
+	FileID            string   `json:",omitempty"`
+	AcknowledgedAt    int64    `json:",omitempty"`
+	ArchivedAt        int64    `json:",omitempty"`
+	DeletedAt         int64    `json:",omitempty"`
+	Batch             string   `json:",omitempty"`
+}
+
+type attemptRecord struct {
+	ID        int64
+	TaskID    string
+	Attempt   int
+	StartedAt int64
+	EndedAt   int64
+	Err       string
+}
+
+type eventRecord struct {
+	TaskID  string
+	At      int64
+	Kind    task.EventKind
+	Message string
+	Bytes   int64
+}
+
+type jsonFile struct {
+	Version       int
+	Tasks         []*taskRecord
+	Journals      []*task.Journal
+	Attempts      []*attemptRecord
+	NextAttemptID int64
+	Events        []*eventRecord
+	Archive       []*taskRecord `json:",omitempty"`
+	Trash         []*taskRecord `json:",omitempty"`
+}
+
+/*
+Task store kept in memory and saved to a single JSON file after every change.
+
+It doesn't need CGO, so it works in builds without SQLite support. It is meant for
+small queues: every change rewrites the whole file. Checkpoints and events are saved
+together at most every jsonSaveDelay, or with the next change of a task.
+*/
+type JSONFileDB struct {
+	path string
+
+	mu            sync.Mutex
+	tasks         map[string]*taskRecord
+	journals      map[string]*task.Journal
+	attempts      []*attemptRecord
+	nextAttemptID int64
+	events        []*eventRecord
+	archive       map[string]*taskRecord
+	trash         map[string]*taskRecord
+
+	// pending save of the delayed changes, nil when everything is on file
+	saveTimer *time.Timer
+
+	// called whenever a task may have become startable
+	onQueueChange func()
+}
+
+func NewJSONFile(path string) *JSONFileDB {
+	if path == "" {
+		path = DEFAULT_JSON_PATH
+	}
+
+	appUtils.MkdirAll(filepath.Dir(path))
+
+	return &JSONFileDB{path: path}
+}
+
+func (jdb *JSONFileDB) Open() error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	jdb.tasks = make(map[string]*taskRecord)
+	jdb.journals = make(map[string]*task.Journal)
+	jdb.attempts = nil
+	jdb.nextAttemptID = 1
+	jdb.events = nil
+	jdb.archive = make(map[string]*taskRecord)
+	jdb.trash = make(map[string]*taskRecord)
+
+	data, err := os.ReadFile(jdb.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return jdb.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	var f jsonFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("DB: Invalid JSON database %s: %v", jdb.path, err)
+	}
+
+	if f.Version > jsonFileVersion {
+		return fmt.Errorf(
+			"DB: JSON database version %d is newer than the supported one (%d): update the application",
+			f.Version,
+			jsonFileVersion,
+		)
+	}
+
+	for _, r := range f.Tasks {
+		// version 1 had no timestamps, but task IDs start with their creation time
+		if r.CreatedAt == 0 {
+			ms, _, _ := strings.Cut(r.ID, "-")
+			r.CreatedAt, _ = strconv.ParseInt(ms, 10, 64)
+		}
+
+		jdb.tasks[r.ID] = r
+	}
+
+	for _, j := range f.Journals {
+		jdb.journals[j.TaskID] = j
+	}
+
+	jdb.attempts = f.Attempts
+	jdb.nextAttemptID = max(f.NextAttemptID, 1)
+	jdb.events = f.Events
+
+	for _, r := range f.Archive {
+		jdb.archive[r.ID] = r
+	}
+
+	for _, r := range f.Trash {
+		jdb.trash[r.ID] = r
+	}
+
+	return nil
+}
+
+func (jdb *JSONFileDB) Close() error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	return jdb.save()
+}
+
+// Saves the database within jsonSaveDelay, together with the changes made in the meantime
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) saveLater() {
+	if jdb.saveTimer != nil {
+		return
+	}
+
+	jdb.saveTimer = time.AfterFunc(jsonSaveDelay, func() {
+		jdb.mu.Lock()
+		defer jdb.mu.Unlock()
+
+		// saved in the meantime
+		if jdb.saveTimer == nil {
+			return
+		}
+
+		if err := jdb.save(); err != nil {
+			log.Println("DB: Cannot save the JSON database:", err)
+		}
+	})
+}
+
+func (jdb *JSONFileDB) Name() string {
+	return "JSON File DB"
+}
+
+func (jdb *JSONFileDB) Persistent() bool {
+	return true
+}
+
+// Sets the function called every time a task is queued, so that the queue runner
+// doesn't need to poll the database
+func (jdb *JSONFileDB) OnQueueChange(fn func()) {
+	jdb.onQueueChange = fn
+}
+
+func (jdb *JSONFileDB) notifyQueue() {
+	if jdb.onQueueChange != nil {
+		jdb.onQueueChange()
+	}
+}
+
+// Writes the whole database to file. The file is replaced atomically, so that
+// a crash while saving never leaves a truncated database behind
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) save() error {
+	if jdb.saveTimer != nil {
+		jdb.saveTimer.Stop()
+		jdb.saveTimer = nil
+	}
+
+	f := jsonFile{
+		Version:       jsonFileVersion,
+		Tasks:         jdb.sortedTasks(func(r *taskRecord) bool { return true }),
+		Journals:      make([]*task.Journal, 0, len(jdb.journals)),
+		Attempts:      jdb.attempts,
+		NextAttemptID: jdb.nextAttemptID,
+		Events:        jdb.events,
+		Archive:       jdb.sortedArchive(),
+		Trash:         jdb.sortedTrash(),
+	}
+
+	for _, j := range jdb.journals {
+		f.Journals = append(f.Journals, j)
+	}
+
+	slices.SortFunc(f.Journals, func(a, b *task.Journal) int {
+		if a.TaskID < b.TaskID {
+			return -1
+		}
+
+		return 1
+	})
+
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := jdb.path + ".tmp"
+
+	if err := writeSynced(tmp, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, jdb.path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(jdb.path))
+}
+
+// Writes data to file and flushes it to disk, so that it can't be renamed over the
+// database before its content has been stored
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Flushes the entries of a directory to disk, making a rename in it durable
+func syncDir(path string) error {
+	// directories can't be synced on Windows, where the rename is flushed by the filesystem
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func toRecord(t *task.Task) *taskRecord {
+	dbErr, dbErrCategory := errToColumns(t.Err)
+
+	return &taskRecord{
+		ID:                t.Id,
+		Aggregator:        t.Aggregator,
+		Slug:              t.Slug,
+		AggregatorPageURL: t.AggregatorPageURL,
+		FilehostUrl:       t.FilehostUrl,
+		DisplayName:       t.DisplayName,
+		Filename:          t.Filename,
+		DownloadState:     t.DownloadState,
+		Err:               dbErr,
+		ErrCategory:       dbErrCategory,
+		Attempts:          t.Attempts,
+		NextRetryAt:       toUnixMilli(t.NextRetryAt),
+		Priority:          t.Priority,
+		Position:          t.Position,
+		CreatedAt:         toUnixMilli(t.CreatedAt),
+		StartedAt:         toUnixMilli(t.StartedAt),
+		FinishedAt:        toUnixMilli(t.FinishedAt),
+		Tags:              task.CleanTags(t.Tags),
+		AlbumID:           t.AlbumID,
+		Filehost:          t.Filehost,
+		FileID:            t.FileID,
+		AcknowledgedAt:    toUnixMilli(t.AcknowledgedAt),
+		ArchivedAt:        toUnixMilli(t.ArchivedAt),
+		DeletedAt:         toUnixMilli(t.DeletedAt),
+	}
+}
+
+func (r *taskRecord) toTask() *task.Task {
+	t := task.NewTask("")
+
+	t.Id = r.ID
+	t.Aggregator = r.Aggregator
+	t.Slug = r.Slug
+	t.AggregatorPageURL = r.AggregatorPageURL
+	t.FilehostUrl = r.FilehostUrl
+	t.DisplayName = r.DisplayName
+	t.Filename = r.Filename
+	t.DownloadState = r.DownloadState
+	t.Err = dsdlerr.FromString(dsdlerr.Category(r.ErrCategory), r.Err)
+	t.Attempts = r.Attempts
+	t.NextRetryAt = fromUnixMilli(r.NextRetryAt)
+	t.Priority = r.Priority
+	t.Position = r.Position
+	t.CreatedAt = fromUnixMilli(r.CreatedAt)
+	t.StartedAt = fromUnixMilli(r.StartedAt)
+	t.FinishedAt = fromUnixMilli(r.FinishedAt)
+	t.Tags = slices.Clone(r.Tags)
+	t.AlbumID = r.AlbumID
+	t.Filehost = r.Filehost
+	t.FileID = r.FileID
+	t.AcknowledgedAt = fromUnixMilli(r.AcknowledgedAt)
+	t.ArchivedAt = fromUnixMilli(r.ArchivedAt)
+	t.DeletedAt = fromUnixMilli(r.DeletedAt)
+
+	return t
+}
+
+// Returns the records matching keep, in queue order
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) sortedTasks(keep func(r *taskRecord) bool) []*taskRecord {
+	records := make([]*taskRecord, 0, len(jdb.tasks))
+
+	for _, r := range jdb.tasks {
+		if keep(r) {
+			records = append(records, r)
+		}
+	}
+
+	slices.SortFunc(records, func(a, b *taskRecord) int {
+		if a.Priority != b.Priority {
+			return b.Priority - a.Priority
+		}
+
+		switch {
+		case a.Position < b.Position:
+			return -1
+		case a.Position > b.Position:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return records
+}
+
+// Returns copies of the tasks matching keep, in queue order
+func (jdb *JSONFileDB) selectTasks(keep func(r *taskRecord) bool) []*task.Task {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	dest := make([]*task.Task, 0)
+
+	for _, r := range jdb.sortedTasks(keep) {
+		dest = append(dest, r.toTask())
+	}
+
+	return dest
+}
+
+// Moves the records matching remove to the trash as a single batch, returning how many have been removed
+func (jdb *JSONFileDB) removeTasks(remove func(r *taskRecord) bool) (int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	batch := newTrashBatch()
+	count := 0
+
+	for id, r := range jdb.tasks {
+		if remove(r) {
+			r.DeletedAt, r.Batch = now, batch
+			jdb.trash[id] = r
+			delete(jdb.tasks, id)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, jdb.save()
+}
+
+func (jdb *JSONFileDB) Count() (int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	return len(jdb.tasks), nil
+}
+
+// Returns whether another task has the same album or file as r, mirroring the unique indexes of the SQLite table
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) conflicts(r *taskRecord) bool {
+	for _, other := range jdb.tasks {
+		if other.ID == r.ID {
+			continue
+		}
+
+		if r.AlbumID != "" && other.Aggregator == r.Aggregator && other.AlbumID == r.AlbumID {
+			return true
+		}
+
+		if r.FileID != "" && other.Filehost == r.Filehost && other.FileID == r.FileID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (jdb *JSONFileDB) Insert(nv *task.Task) (string, error) {
+	jdb.mu.Lock()
+
+	r := toRecord(nv)
+
+	if _, ok := jdb.tasks[nv.Id]; ok || jdb.conflicts(r) {
+		jdb.mu.Unlock()
+		return nv.Id, ErrDuplicate
+	}
+
+	r.Position = 1
+
+	for _, other := range jdb.tasks {
+		r.Position = max(r.Position, other.Position+1)
+	}
+
+	jdb.tasks[r.ID] = r
+
+	err := jdb.save()
+	jdb.mu.Unlock()
+
+	if err != nil {
+		return nv.Id, err
+	}
+
+	if nv.DownloadState == states.TASK_STATE_QUEUED {
+		jdb.notifyQueue()
+	}
+
+	return nv.Id, nil
+}
+
+func (jdb *JSONFileDB) Find(slugOrID string) (bool, int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	count := 0
+
+	for _, r := range jdb.tasks {
+		if r.ID == slugOrID || r.Slug == slugOrID {
+			count++
+		}
+	}
+
+	return count != 0, count, nil
+}
+
+// Returns sql.ErrNoRows if the task doesn't exist, just like the SQLite store
+func (jdb *JSONFileDB) Get(id string) (*task.Task, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, ok := jdb.tasks[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.toTask(), nil
+}
+
+// Returns the first task matching keep, or sql.ErrNoRows
+func (jdb *JSONFileDB) getWhere(keep func(r *taskRecord) bool) (*task.Task, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	for _, r := range jdb.tasks {
+		if keep(r) {
+			return r.toTask(), nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (jdb *JSONFileDB) GetByAlbum(aggregator, albumID string) (*task.Task, error) {
+	return jdb.getWhere(func(r *taskRecord) bool {
+		return albumID != "" && r.Aggregator == aggregator && r.AlbumID == albumID
+	})
+}
+
+func (jdb *JSONFileDB) GetByFile(filehost, fileID string) (*task.Task, error) {
+	return jdb.getWhere(func(r *taskRecord) bool {
+		return fileID != "" && r.Filehost == filehost && r.FileID == fileID
+	})
+}
+
+// Returns sql.ErrNoRows if no task can be started right now
+func (jdb *JSONFileDB) GetNextQueued(now time.Time) (*task.Task, error) {
+	tasks := jdb.selectTasks(func(r *taskRecord) bool {
+		return r.DownloadState == states.TASK_STATE_QUEUED && r.NextRetryAt <= now.UnixMilli()
+	})
+
+	if len(tasks) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return tasks[0], nil
+}
+
+func (jdb *JSONFileDB) GetNextRetryAt(now time.Time) (time.Time, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	var next int64
+
+	for _, r := range jdb.tasks {
+		if r.DownloadState != states.TASK_STATE_QUEUED || r.NextRetryAt <= now.UnixMilli() {
+			continue
+		}
+
+		if next == 0 || r.NextRetryAt < next {
+			next = r.NextRetryAt
+		}
+	}
+
+	return fromUnixMilli(next), nil
+}
+
+func (jdb *JSONFileDB) GetAll() ([]*task.Task, error) {
+	return jdb.selectTasks(func(r *taskRecord) bool { return true }), nil
+}
+
+func (jdb *JSONFileDB) GetAllWithState(state int) ([]*task.Task, error) {
+	if !states.IsValid(state) {
+		return make([]*task.Task, 0), fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
+	}
+
+	return jdb.selectTasks(func(r *taskRecord) bool { return r.DownloadState == state }), nil
+}
+
+func (jdb *JSONFileDB) GetEnded() ([]*task.Task, error) {
+	return jdb.selectTasks(func(r *taskRecord) bool { return states.IsEnded(r.DownloadState) }), nil
+}
+
+func (jdb *JSONFileDB) Update(t *task.Task) error {
+	jdb.mu.Lock()
+
+	r, ok := jdb.tasks[t.Id]
+	if !ok {
+		jdb.mu.Unlock()
+		return nil
+	}
+
+	if !states.CanTransition(r.DownloadState, t.DownloadState) {
+		jdb.mu.Unlock()
+		return transitionErr(r.DownloadState, t.DownloadState)
+	}
+
+	// the queue order is only changed through the dedicated methods
+	updated := toRecord(t)
+	updated.Aggregator = r.Aggregator
+	updated.CreatedAt = r.CreatedAt
+	updated.Priority = r.Priority
+	updated.Position = r.Position
+	// just like the priority, tags are only changed through SetTags, and acknowledgements through Acknowledge
+	updated.Tags = r.Tags
+	updated.AcknowledgedAt = r.AcknowledgedAt
+
+	if jdb.conflicts(updated) {
+		jdb.mu.Unlock()
+		return ErrDuplicate
+	}
+
+	jdb.tasks[t.Id] = updated
+
+	err := jdb.save()
+	jdb.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if t.DownloadState == states.TASK_STATE_QUEUED {
+		jdb.notifyQueue()
+	}
+
+	return nil
+}
+
+func (jdb *JSONFileDB) RemoveFromID(id string) error {
+	_, err := jdb.removeTasks(func(r *taskRecord) bool { return r.ID == id })
+
+	return err
+}
+
+func (jdb *JSONFileDB) RemoveFromState(state int) (int, error) {
+	if !states.IsValid(state) {
+		return 0, fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
+	}
+
+	return jdb.removeTasks(func(r *taskRecord) bool { return r.DownloadState == state })
+}
+
+func (jdb *JSONFileDB) RemoveFailedWithErrCategory(c dsdlerr.Category) (int, error) {
+	return jdb.removeTasks(func(r *taskRecord) bool {
+		return r.DownloadState == states.TASK_STATE_FAILED && r.ErrCategory == string(c)
+	})
+}
+
+func (jdb *JSONFileDB) RemoveEnded() (int, error) {
+	return jdb.removeTasks(func(r *taskRecord) bool { return states.IsEnded(r.DownloadState) })
+}
+
+// Moves a stored task to the state picked by next, then lets apply update the other fields.
+//
+// Returns the previous and the new state of the task
+func (jdb *JSONFileDB) moveState(
+	t *task.Task,
+	next func(r *taskRecord) (int, error),
+	apply func(r *taskRecord),
+) (int, int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, ok := jdb.tasks[t.Id]
+	if !ok {
+		return 0, 0, fmt.Errorf("DB: Task not found: %s: %w", t.Id, sql.ErrNoRows)
+	}
+
+	from := r.DownloadState
+
+	to, err := next(r)
+	if err != nil {
+		return from, from, err
+	}
+
+	if !states.CanTransition(from, to) {
+		return from, from, transitionErr(from, to)
+	}
+
+	r.DownloadState = to
+	apply(r)
+
+	return from, to, jdb.save()
+}
+
+func (jdb *JSONFileDB) SetState(t *task.Task, newState int) error {
+	if !states.IsValid(newState) {
+		return fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
+	}
+
+	_, _, err := jdb.moveState(
+		t,
+		func(r *taskRecord) (int, error) { return newState, nil },
+		func(r *taskRecord) { r.Err, r.ErrCategory = errToColumns(t.Err) },
+	)
+	if err != nil {
+		return err
+	}
+
+	t.DownloadState = newState
+
+	if newState == states.TASK_STATE_QUEUED {
+		jdb.notifyQueue()
+	}
+
+	return nil
+}
+
+func (jdb *JSONFileDB) AdvanceState(t *task.Task) (int, error) {
+	from, to, err := jdb.moveState(
+		t,
+		func(r *taskRecord) (int, error) {
+			switch r.DownloadState {
+			case states.TASK_STATE_QUEUED:
+				return states.TASK_STATE_RUNNING, nil
+			case states.TASK_STATE_RUNNING:
+				if t.Err != nil {
+					return states.TASK_STATE_FAILED, nil
+				}
+
+				return states.TASK_STATE_COMPLETED, nil
+			default:
+				return 0, fmt.Errorf("Cannot advance the status of this task anymore")
+			}
+		},
+		func(r *taskRecord) { r.Err, r.ErrCategory = errToColumns(t.Err) },
+	)
+	if err != nil {
+		return from, err
+	}
+
+	t.DownloadState = to
+
+	return to, nil
+}
+
+func (jdb *JSONFileDB) RegressState(t *task.Task) (int, error) {
+	from, _, err := jdb.moveState(
+		t,
+		func(r *taskRecord) (int, error) {
+			if r.DownloadState == states.TASK_STATE_QUEUED {
+				return 0, fmt.Errorf("Cannot regress the status of this task anymore")
+			}
+
+			return states.TASK_STATE_QUEUED, nil
+		},
+		func(r *taskRecord) {
+			r.Err, r.ErrCategory = "", ""
+			r.AcknowledgedAt = 0
+		},
+	)
+	if err != nil {
+		return from, err
+	}
+
+	t.DownloadState = states.TASK_STATE_QUEUED
+	t.Err = nil
+	t.AcknowledgedAt = time.Time{}
+
+	jdb.notifyQueue()
+
+	return states.TASK_STATE_QUEUED, nil
+}
+
+func (jdb *JSONFileDB) ResetState(t *task.Task) (int, error) {
+	if t.DownloadState == states.TASK_STATE_RUNNING {
+		return -1, ErrResetRunning
+	}
+
+	_, _, err := jdb.moveState(
+		t,
+		func(r *taskRecord) (int, error) {
+			// t may be a stale copy of a task started in the meantime
+			if r.DownloadState == states.TASK_STATE_RUNNING {
+				return -1, ErrResetRunning
+			}
+
+			return states.TASK_STATE_QUEUED, nil
+		},
+		func(r *taskRecord) {
+			r.Err, r.ErrCategory = "", ""
+			r.Attempts = 0
+			r.NextRetryAt = 0
+			r.StartedAt = 0
+			r.FinishedAt = 0
+			r.AcknowledgedAt = 0
+		},
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	t.DownloadState = states.TASK_STATE_QUEUED
+	t.Err = nil
+	t.Attempts = 0
+	t.NextRetryAt = time.Time{}
+	t.StartedAt = time.Time{}
+	t.FinishedAt = time.Time{}
+	t.AcknowledgedAt = time.Time{}
+
+	jdb.notifyQueue()
+
+	return states.TASK_STATE_QUEUED, nil
+}
+
+// Returns the record of a task that can be reordered
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) movable(id string) (*taskRecord, error) {
+	r, ok := jdb.tasks[id]
+	if !ok {
+		return nil, fmt.Errorf("DB: Task not found: %s", id)
+	}
+
+	if r.DownloadState != states.TASK_STATE_QUEUED && r.DownloadState != states.TASK_STATE_PAUSED {
+		return nil, fmt.Errorf("DB: Only queued or paused tasks can be moved")
+	}
+
+	return r, nil
+}
+
+// Returns the lowest and highest priority of the tasks waiting in the queue
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) waitingPriorities() (int, int) {
+	var lowest, highest int
+	first := true
+
+	for _, r := range jdb.tasks {
+		if r.DownloadState != states.TASK_STATE_QUEUED && r.DownloadState != states.TASK_STATE_PAUSED {
+			continue
+		}
+
+		if first {
+			lowest, highest = r.Priority, r.Priority
+			first = false
+
+			continue
+		}
+
+		lowest = min(lowest, r.Priority)
+		highest = max(highest, r.Priority)
+	}
+
+	return lowest, highest
+}
+
+func (jdb *JSONFileDB) SetPriority(id string, priority int) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, ok := jdb.tasks[id]
+	if !ok {
+		return fmt.Errorf("DB: Task not found: %s", id)
+	}
+
+	r.Priority = priority
+
+	return jdb.save()
+}
+
+func (jdb *JSONFileDB) MoveToTop(id string) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, err := jdb.movable(id)
+	if err != nil {
+		return err
+	}
+
+	_, highest := jdb.waitingPriorities()
+
+	var first int64
+	for _, other := range jdb.tasks {
+		first = min(first, other.Position)
+	}
+
+	r.Priority = highest
+	r.Position = first - 1
+
+	return jdb.save()
+}
+
+func (jdb *JSONFileDB) MoveToBottom(id string) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, err := jdb.movable(id)
+	if err != nil {
+		return err
+	}
+
+	lowest, _ := jdb.waitingPriorities()
+
+	var last int64
+	for _, other := range jdb.tasks {
+		last = max(last, other.Position)
+	}
+
+	r.Priority = lowest
+	r.Position = last + 1
+
+	return jdb.save()
+}
+
+func (jdb *JSONFileDB) MoveBefore(id, beforeID string) error {
+	if id == beforeID {
+		return nil
+	}
+
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, err := jdb.movable(id)
+	if err != nil {
+		return err
+	}
+
+	target, ok := jdb.tasks[beforeID]
+	if !ok {
+		return fmt.Errorf("DB: Task not found: %s", beforeID)
+	}
+
+	// make room for the moved task
+	position := target.Position
+
+	for _, other := range jdb.tasks {
+		if other.Position >= position {
+			other.Position++
+		}
+	}
+
+	r.Priority = target.Priority
+	r.Position = position
+
+	return jdb.save()
+}
+
+func (jdb *JSONFileDB) StartAttempt(taskID string, number int) (int64, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	a := &attemptRecord{
+		ID:        jdb.nextAttemptID,
+		TaskID:    taskID,
+		Attempt:   number,
+		StartedAt: time.Now().UnixMilli(),
+	}
+
+	jdb.nextAttemptID++
+	jdb.attempts = append(jdb.attempts, a)
+
+	return a.ID, jdb.save()
+}
+
+func (jdb *JSONFileDB) EndAttempt(attemptID int64, attemptErr error) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	for _, a := range jdb.attempts {
+		if a.ID != attemptID {
+			continue
+		}
+
+		a.EndedAt = time.Now().UnixMilli()
+		a.Err = ""
+		if attemptErr != nil {
+			a.Err = attemptErr.Error()
+		}
+
+		return jdb.save()
+	}
+
+	return nil
+}
+
+func (jdb *JSONFileDB) GetAttempts(taskID string) ([]*task.Attempt, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	dest := make([]*task.Attempt, 0)
+
+	for _, a := range jdb.attempts {
+		if a.TaskID != taskID {
+			continue
+		}
+
+		dest = append(dest, &task.Attempt{
+			Number:    a.Attempt,
+			StartedAt: fromUnixMilli(a.StartedAt),
+			EndedAt:   fromUnixMilli(a.EndedAt),
+			Err:       a.Err,
+		})
+	}
+
+	return dest, nil
+}
+
+func (jdb *JSONFileDB) RemoveOrphanAttempts() (int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	kept := jdb.attempts[:0]
+
+	for _, a := range jdb.attempts {
+		if jdb.known(a.TaskID) {
+			kept = append(kept, a)
+		}
+	}
+
+	count := len(jdb.attempts) - len(kept)
+	jdb.attempts = kept
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, jdb.save()
+}
+
+func (jdb *JSONFileDB) SaveJournal(j *task.Journal) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	saved := *j
+	jdb.journals[j.TaskID] = &saved
+	jdb.saveLater()
+
+	return nil
+}
+
+func (jdb *JSONFileDB) GetJournal(taskID string) (*task.Journal, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	j, ok := jdb.journals[taskID]
+	if !ok {
+		return nil, nil
+	}
+
+	found := *j
+
+	return &found, nil
+}
+
+func (jdb *JSONFileDB) GetAllJournals() ([]*task.Journal, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	dest := make([]*task.Journal, 0)
+
+	for _, j := range jdb.journals {
+		if jdb.orphanJournal(j) {
+			continue
+		}
+
+		found := *j
+		dest = append(dest, &found)
+	}
+
+	return dest, nil
+}
+
+func (jdb *JSONFileDB) RemoveJournal(taskID string) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	if _, ok := jdb.journals[taskID]; !ok {
+		return nil
+	}
+
+	delete(jdb.journals, taskID)
+
+	return jdb.save()
+}
+
+// Reports whether the task of the journal is neither stored nor in the trash
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) orphanJournal(j *task.Journal) bool {
+	if _, ok := jdb.tasks[j.TaskID]; ok {
+		return false
+	}
+
+	_, ok := jdb.trash[j.TaskID]
+
+	return !ok
+}
+
+func (jdb *JSONFileDB) RemoveOrphanJournals() ([]*task.Journal, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	orphans := make([]*task.Journal, 0)
+
+	for id, j := range jdb.journals {
+		if jdb.orphanJournal(j) {
+			delete(jdb.journals, id)
+			orphans = append(orphans, j)
+		}
+	}
+
+	if len(orphans) == 0 {
+		return orphans, nil
+	}
+
+	return orphans, jdb.save()
+}
+
+func (jdb *JSONFileDB) AddEvent(taskID string, e *task.Event) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	jdb.events = append(jdb.events, &eventRecord{
+		TaskID:  taskID,
+		At:      e.At.UnixMilli(),
+		Kind:    e.Kind,
+		Message: e.Message,
+		Bytes:   e.Bytes,
+	})
+	jdb.saveLater()
+
+	return nil
+}
+
+func (jdb *JSONFileDB) GetEvents(taskID string) ([]*task.Event, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	dest := make([]*task.Event, 0)
+
+	for _, e := range jdb.events {
+		if e.TaskID != taskID {
+			continue
+		}
+
+		dest = append(dest, &task.Event{
+			At:      fromUnixMilli(e.At),
+			Kind:    e.Kind,
+			Message: e.Message,
+			Bytes:   e.Bytes,
+		})
+	}
+
+	return dest, nil
+}
+
+func (jdb *JSONFileDB) RemoveOrphanEvents() (int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	kept := jdb.events[:0]
+
+	for _, e := range jdb.events {
+		if jdb.known(e.TaskID) {
+			kept = append(kept, e)
+		}
+	}
+
+	count := len(jdb.events) - len(kept)
+	jdb.events = kept
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, jdb.save()
+}
+
+func (jdb *JSONFileDB) Query(q TaskQuery) (*TaskPage, error) {
+	cols, err := q.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var after []any
+
+	if q.Cursor != "" {
+		after, err = decodeCursor(cols, q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := &TaskPage{}
+	tasks := make([]*task.Task, 0)
+
+	jdb.mu.Lock()
+
+	for _, r := range jdb.tasks {
+		t := r.toTask()
+
+		if !q.matches(t) {
+			continue
+		}
+
+		page.Total++
+
+		if after != nil && compareRows(cols, rowValues(cols, t), after) <= 0 {
+			continue
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	jdb.mu.Unlock()
+
+	slices.SortFunc(tasks, func(a, b *task.Task) int {
+		return compareRows(cols, rowValues(cols, a), rowValues(cols, b))
+	})
+
+	return paginate(page, cols, tasks, q.Limit), nil
+}
+
+func (jdb *JSONFileDB) Search(text string, limit int) ([]*task.Task, error) {
+	terms, err := parseSearch(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterSearch(terms, jdb.selectTasks(func(r *taskRecord) bool { return true }), limit), nil
+}
+
+func (jdb *JSONFileDB) SetTags(id string, tags []string) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, ok := jdb.tasks[id]
+	if !ok {
+		return fmt.Errorf("DB: Task not found: %s", id)
+	}
+
+	r.Tags = task.CleanTags(tags)
+
+	return jdb.save()
+}
+
+// Returns whether the task is in the queue, in the archive or in the trash
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) known(id string) bool {
+	_, queued := jdb.tasks[id]
+	_, archived := jdb.archive[id]
+	_, trashed := jdb.trash[id]
+
+	return queued || archived || trashed
+}
+
+// Returns the archived records, the most recently archived first
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) sortedArchive() []*taskRecord {
+	records := make([]*taskRecord, 0, len(jdb.archive))
+
+	for _, r := range jdb.archive {
+		records = append(records, r)
+	}
+
+	slices.SortFunc(records, func(a, b *taskRecord) int {
+		if a.ArchivedAt != b.ArchivedAt {
+			return int(b.ArchivedAt - a.ArchivedAt)
+		}
+
+		return strings.Compare(b.ID, a.ID)
+	})
+
+	return records
+}
+
+func (jdb *JSONFileDB) Acknowledge(id string) error {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, ok := jdb.tasks[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if r.DownloadState != states.TASK_STATE_FAILED && r.DownloadState != states.TASK_STATE_CANCELED {
+		return ErrNotAcknowledgeable
+	}
+
+	r.AcknowledgedAt = time.Now().UnixMilli()
+
+	return jdb.save()
+}
+
+func (jdb *JSONFileDB) Archive(successBefore time.Time) (int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	count := 0
+
+	for id, r := range jdb.tasks {
+		if !isExpired(r.DownloadState, r.FinishedAt, r.CreatedAt, r.AcknowledgedAt, successBefore) {
+			continue
+		}
+
+		r.ArchivedAt = now
+		jdb.archive[id] = r
+		delete(jdb.tasks, id)
+
+		count++
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, jdb.save()
+}
+
+func (jdb *JSONFileDB) GetArchived(id string) (*task.Task, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, ok := jdb.archive[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.toTask(), nil
+}
+
+func (jdb *JSONFileDB) SearchArchive(text string, limit int) ([]*task.Task, error) {
+	var terms []searchTerm
+
+	if strings.TrimSpace(text) != "" {
+		var err error
+
+		terms, err = parseSearch(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	dest := make([]*task.Task, 0)
+
+	for _, r := range jdb.sortedArchive() {
+		if limit > 0 && len(dest) == limit {
+			break
+		}
+
+		if t := r.toTask(); terms == nil || matchesSearch(terms, t) {
+			dest = append(dest, t)
+		}
+	}
+
+	return dest, nil
+}
+
+func (jdb *JSONFileDB) Unarchive(id string) (*task.Task, error) {
+	jdb.mu.Lock()
+
+	archived, ok := jdb.archive[id]
+	if !ok {
+		jdb.mu.Unlock()
+		return nil, sql.ErrNoRows
+	}
+
+	r := *archived
+	r.DownloadState = states.TASK_STATE_QUEUED
+	r.Err, r.ErrCategory = "", ""
+	r.Attempts = 0
+	r.NextRetryAt = 0
+	r.StartedAt = 0
+	r.FinishedAt = 0
+	r.Filehost, r.FileID = "", ""
+	r.AcknowledgedAt = 0
+	r.ArchivedAt = 0
+
+	if _, ok := jdb.tasks[id]; ok || jdb.conflicts(&r) {
+		jdb.mu.Unlock()
+		return nil, ErrDuplicate
+	}
+
+	r.Position = 1
+	for _, other := range jdb.tasks {
+		r.Position = max(r.Position, other.Position+1)
+	}
+
+	jdb.tasks[id] = &r
+	delete(jdb.archive, id)
+
+	err := jdb.save()
+	jdb.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	jdb.notifyQueue()
+
+	return r.toTask(), nil
+}
+
+// Returns the trashed records, the most recently removed first
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) sortedTrash() []*taskRecord {
+	records := make([]*taskRecord, 0, len(jdb.trash))
+
+	for _, r := range jdb.trash {
+		records = append(records, r)
+	}
+
+	slices.SortFunc(records, func(a, b *taskRecord) int {
+		if a.DeletedAt != b.DeletedAt {
+			return int(b.DeletedAt - a.DeletedAt)
+		}
+
+		return strings.Compare(b.ID, a.ID)
+	})
+
+	return records
+}
+
+func (jdb *JSONFileDB) RemoveFromIDs(ids []string) (int, error) {
+	return jdb.removeTasks(func(r *taskRecord) bool { return slices.Contains(ids, r.ID) })
+}
+
+func (jdb *JSONFileDB) LastTrashBatch() (*TrashBatch, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	var last *TrashBatch
+
+	for _, r := range jdb.trash {
+		if last == nil || r.Batch > last.ID {
+			last = &TrashBatch{ID: r.Batch, DeletedAt: fromUnixMilli(r.DeletedAt)}
+		}
+
+		if r.Batch == last.ID {
+			last.Count++
+		}
+	}
+
+	if last == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return last, nil
+}
+
+// Moves the trashed records matching restore back to the queue, skipping the duplicates
+//
+// Must be called with jdb.mu held
+func (jdb *JSONFileDB) restore(restore func(r *taskRecord) bool) (int, bool, error) {
+	count := 0
+	queued := false
+
+	for _, trashed := range jdb.sortedTrash() {
+		if !restore(trashed) {
+			continue
+		}
+
+		r := *trashed
+		r.DeletedAt, r.Batch = 0, ""
+
+		if r.DownloadState == states.TASK_STATE_RUNNING {
+			r.DownloadState = states.TASK_STATE_QUEUED
+		}
+
+		if _, ok := jdb.tasks[r.ID]; ok || jdb.conflicts(&r) {
+			continue
+		}
+
+		jdb.tasks[r.ID] = &r
+		delete(jdb.trash, r.ID)
+
+		count++
+		queued = queued || r.DownloadState == states.TASK_STATE_QUEUED
+	}
+
+	if count == 0 {
+		return 0, false, nil
+	}
+
+	return count, queued, jdb.save()
+}
+
+func (jdb *JSONFileDB) RestoreBatch(batch string) (int, error) {
+	jdb.mu.Lock()
+	count, queued, err := jdb.restore(func(r *taskRecord) bool { return r.Batch == batch })
+	jdb.mu.Unlock()
+
+	if queued {
+		jdb.notifyQueue()
+	}
+
+	return count, err
+}
+
+func (jdb *JSONFileDB) RestoreTrashed(id string) (*task.Task, error) {
+	jdb.mu.Lock()
+
+	if _, ok := jdb.trash[id]; !ok {
+		jdb.mu.Unlock()
+		return nil, sql.ErrNoRows
+	}
+
+	count, queued, err := jdb.restore(func(r *taskRecord) bool { return r.ID == id })
+	if err != nil || count == 0 {
+		jdb.mu.Unlock()
+
+		if err == nil {
+			err = ErrDuplicate
+		}
+
+		return nil, err
+	}
+
+	t := jdb.tasks[id].toTask()
+	jdb.mu.Unlock()
+
+	if queued {
+		jdb.notifyQueue()
+	}
+
+	return t, nil
+}
+
+func (jdb *JSONFileDB) GetTrashed(id string) (*task.Task, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	r, ok := jdb.trash[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.toTask(), nil
+}
+
+func (jdb *JSONFileDB) GetTrashBatch(batch string) ([]*task.Task, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	dest := make([]*task.Task, 0)
+
+	for _, r := range jdb.trash {
+		if r.Batch == batch {
+			dest = append(dest, r.toTask())
+		}
+	}
+
+	slices.SortFunc(dest, func(a, b *task.Task) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	return dest, nil
+}
+
+func (jdb *JSONFileDB) SearchTrash(text string, limit int) ([]*task.Task, error) {
+	var terms []searchTerm
+
+	if strings.TrimSpace(text) != "" {
+		var err error
+
+		terms, err = parseSearch(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	dest := make([]*task.Task, 0)
+
+	for _, r := range jdb.sortedTrash() {
+		if limit > 0 && len(dest) == limit {
+			break
+		}
+
+		if t := r.toTask(); terms == nil || matchesSearch(terms, t) {
+			dest = append(dest, t)
+		}
+	}
+
+	return dest, nil
+}
+
+func (jdb *JSONFileDB) PurgeTrash(before time.Time) (int, error) {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+
+	count := 0
+
+	for id, r := range jdb.trash {
+		if r.DeletedAt < before.UnixMilli() {
+			delete(jdb.trash, id)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, jdb.save()
+}
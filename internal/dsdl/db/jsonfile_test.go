@@ -0,0 +1,302 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Methods shared by every task store, so that they can be checked against the same scenario
+type taskStore interface {
+	Open() error
+	Close() error
+	Insert(t *task.Task) (string, error)
+	Get(id string) (*task.Task, error)
+	GetByAlbum(aggregator, albumID string) (*task.Task, error)
+	GetByFile(filehost, fileID string) (*task.Task, error)
+	Find(v string) (bool, int, error)
+	GetNextQueued(now time.Time) (*task.Task, error)
+	GetAllWithState(state int) ([]*task.Task, error)
+	Query(q TaskQuery) (*TaskPage, error)
+	Search(text string, limit int) ([]*task.Task, error)
+	SetTags(id string, tags []string) error
+	Update(t *task.Task) error
+	AdvanceState(t *task.Task) (int, error)
+	ResetState(t *task.Task) (int, error)
+	RemoveEnded() (int, error)
+	Acknowledge(id string) error
+	Archive(successBefore time.Time) (int, error)
+	GetArchived(id string) (*task.Task, error)
+	SearchArchive(text string, limit int) ([]*task.Task, error)
+	Unarchive(id string) (*task.Task, error)
+	RemoveFromState(state int) (int, error)
+	RemoveFromIDs(ids []string) (int, error)
+	LastTrashBatch() (*TrashBatch, error)
+	RestoreBatch(batch string) (int, error)
+	RestoreTrashed(id string) (*task.Task, error)
+	GetTrashed(id string) (*task.Task, error)
+	GetTrashBatch(batch string) ([]*task.Task, error)
+	SearchTrash(text string, limit int) ([]*task.Task, error)
+	PurgeTrash(before time.Time) (int, error)
+	RemoveOrphanAttempts() (int, error)
+	MoveToTop(id string) error
+	MoveBefore(id, beforeID string) error
+	StartAttempt(taskID string, number int) (int64, error)
+	EndAttempt(attemptID int64, attemptErr error) error
+	GetAttempts(taskID string) ([]*task.Attempt, error)
+	AddEvent(taskID string, e *task.Event) error
+	GetEvents(taskID string) ([]*task.Event, error)
+	RemoveOrphanEvents() (int, error)
+	SaveJournal(j *task.Journal) error
+	GetAllJournals() ([]*task.Journal, error)
+	RemoveOrphanJournals() ([]*task.Journal, error)
+}
+
+// Runs a test against a new instance of every store
+func forEachStore(t *testing.T, test func(t *testing.T, db taskStore)) {
+	stores := map[string]taskStore{
+		"sqlite": NewSQLite(true),
+		"json":   NewJSONFile(filepath.Join(t.TempDir(), "tasks.json")),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			test(t, store)
+		})
+	}
+}
+
+func TestStoreConformance(t *testing.T) {
+	forEachStore(t, testStoreScenario)
+}
+
+func testStoreScenario(t *testing.T, db taskStore) {
+	err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ids := make([]string, 0)
+
+	for _, slug := range []string{"first", "second", "third"} {
+		id, err := db.Insert(task.NewTask(slug))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	_, err = db.Get("missing")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Get: Expected sql.ErrNoRows for a missing task, got %v", err)
+	}
+
+	// queue order
+	if err := db.MoveToTop(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MoveBefore(ids[1], ids[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := db.GetAllWithState(states.TASK_STATE_QUEUED)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slugs := make([]string, 0, len(queued))
+	for _, tsk := range queued {
+		slugs = append(slugs, tsk.Slug)
+	}
+
+	if got := strings.Join(slugs, " "); got != "second third first" {
+		t.Fatalf("Unexpected queue order: %q", got)
+	}
+
+	// states
+	next, err := db.GetNextQueued(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := db.AdvanceState(next); err != nil || state != states.TASK_STATE_RUNNING {
+		t.Fatalf("AdvanceState: Expected the running state, got %d (%v)", state, err)
+	}
+
+	next.Err = dsdlerr.New(dsdlerr.NotFound, "gone")
+	if state, err := db.AdvanceState(next); err != nil || state != states.TASK_STATE_FAILED {
+		t.Fatalf("AdvanceState: Expected the failed state, got %d (%v)", state, err)
+	}
+
+	next.DownloadState = states.TASK_STATE_RUNNING
+	if err := db.Update(next); err == nil {
+		t.Fatal("Update: Expected an error when moving a failed task back to running")
+	}
+
+	stored, err := db.Get(next.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsdlerr.CategoryOf(stored.Err) != dsdlerr.NotFound {
+		t.Fatalf("Get: Expected the error category to be stored, got %q", dsdlerr.CategoryOf(stored.Err))
+	}
+
+	// attempts and journals
+	attemptID, err := db.StartAttempt(ids[0], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EndAttempt(attemptID, errors.New("timeout")); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := db.GetAttempts(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || attempts[0].Err != "timeout" || attempts[0].EndedAt.IsZero() {
+		t.Fatalf("GetAttempts: Unexpected attempts: %+v", attempts)
+	}
+
+	// timestamps and timeline
+	next.StartedAt = time.Now().Add(-time.Minute)
+	next.FinishedAt = time.Now()
+	next.DownloadState = states.TASK_STATE_FAILED
+
+	if err := db.Update(next); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = db.Get(next.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CreatedAt.UnixMilli() != next.CreatedAt.UnixMilli() ||
+		stored.StartedAt.UnixMilli() != next.StartedAt.UnixMilli() ||
+		stored.FinishedAt.UnixMilli() != next.FinishedAt.UnixMilli() {
+		t.Fatalf("Update: Timestamps not stored: %+v", stored)
+	}
+
+	for _, kind := range []task.EventKind{task.EventStarted, task.EventBytes, task.EventFinished} {
+		err := db.AddEvent(next.Id, &task.Event{Kind: kind, Bytes: 1024})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := db.GetEvents(next.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0].Kind != task.EventStarted || events[2].Kind != task.EventFinished ||
+		events[1].Bytes != 1024 || events[0].At.IsZero() {
+		t.Fatalf("GetEvents: Unexpected timeline: %+v", events)
+	}
+
+	for _, id := range []string{ids[0], next.Id} {
+		err := db.SaveJournal(&task.Journal{TaskID: id, TempFilepath: id + ".part"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := db.RemoveEnded()
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveEnded: Expected 1 removed task, got %d (%v)", removed, err)
+	}
+
+	// the partial download of a trashed task can be resumed once it is restored
+	journals, err := db.GetAllJournals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journals) != 2 {
+		t.Fatalf("GetAllJournals: Expected the journals of the stored and the trashed task, got %+v", journals)
+	}
+
+	if orphans, err := db.RemoveOrphanJournals(); err != nil || len(orphans) != 0 {
+		t.Fatalf("RemoveOrphanJournals: Expected no removed journal, got %+v (%v)", orphans, err)
+	}
+
+	// the timeline of a trashed task is kept until the trash is purged
+	if orphans, err := db.RemoveOrphanEvents(); err != nil || orphans != 0 {
+		t.Fatalf("RemoveOrphanEvents: Expected no removed event, got %d (%v)", orphans, err)
+	}
+
+	if _, err := db.PurgeTrash(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if orphans, err := db.RemoveOrphanEvents(); err != nil || orphans != 3 {
+		t.Fatalf("RemoveOrphanEvents: Expected 3 removed events, got %d (%v)", orphans, err)
+	}
+
+	orphans, err := db.RemoveOrphanJournals()
+	if err != nil || len(orphans) != 1 || orphans[0].TaskID != next.Id {
+		t.Fatalf("RemoveOrphanJournals: Expected the journal of the purged task, got %+v (%v)", orphans, err)
+	}
+}
+
+func TestJSONFilePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+
+	db := NewJSONFile(path)
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	tsk := task.NewTask("12345")
+	tsk.DisplayName = "Album"
+	tsk.AlbumID = "12345"
+
+	if _, err := db.Insert(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	dup := task.NewTask("https://doujinstyle.com/?p=page&type=1&id=12345")
+	dup.AlbumID = "12345"
+
+	if _, err := db.Insert(dup); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Insert: Expected ErrDuplicate when inserting the same album, got %v", err)
+	}
+
+	// the save of the events is delayed, closing the database must not lose them
+	if err := db.AddEvent(tsk.Id, &task.Event{Kind: task.EventStarted}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := NewJSONFile(path)
+	if err := reopened.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	found, err := reopened.Get(tsk.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Slug != "12345" || found.DisplayName != "Album" {
+		t.Fatalf("Get: Unexpected task after reopening: %+v", found)
+	}
+
+	events, err := reopened.GetEvents(tsk.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Kind != task.EventStarted {
+		t.Fatalf("GetEvents: Expected the event added before closing, got %+v", events)
+	}
+}
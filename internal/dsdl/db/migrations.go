@@ -0,0 +1,452 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+)
+
+const SCHEMA_VERSION_TABLE_NAME string = "schema_version"
+
+// A single change of the database schema
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sqlx.Tx) error
+}
+
+/*
+Every change made to the schema since 0.4, oldest first.
+
+Databases created before the schema was versioned may already contain part of these
+changes, so migrations must be idempotent. Once released, a migration must never be
+edited: append a new one instead.
+*/
+var migrations = []migration{
+	{1, "tasks table", func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS ` + TABLE_NAME + ` (
+				ID STRING PRIMARY KEY,
+				Aggregator STRING,
+				Slug STRING,
+				AggregatorPageURL STRING,
+				FilehostUrl STRING,
+				DisplayName STRING,
+				Filename STRING,
+				DownloadState INTEGER,
+				Err STRING
+			);
+		`)
+
+		return err
+	}},
+
+	{2, "partial downloads journal", func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS ` + JOURNAL_TABLE_NAME + ` (
+				TaskID TEXT PRIMARY KEY,
+				TempFilepath TEXT NOT NULL,
+				BytesWritten INTEGER NOT NULL DEFAULT 0,
+				ETag TEXT NOT NULL DEFAULT '',
+				LastModified TEXT NOT NULL DEFAULT '',
+				FilehostUrl TEXT NOT NULL DEFAULT ''
+			);
+		`)
+
+		return err
+	}},
+
+	{3, "error categories", func(tx *sqlx.Tx) error {
+		_, err := ensureColumn(tx, TABLE_NAME, "ErrCategory", "TEXT NOT NULL DEFAULT ''")
+		return err
+	}},
+
+	{4, "automatic retries", func(tx *sqlx.Tx) error {
+		for _, c := range [][2]string{
+			{"Attempts", "INTEGER NOT NULL DEFAULT 0"},
+			{"NextRetryAt", "INTEGER NOT NULL DEFAULT 0"},
+		} {
+			if _, err := ensureColumn(tx, TABLE_NAME, c[0], c[1]); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS ` + ATTEMPTS_TABLE_NAME + ` (
+				ID INTEGER PRIMARY KEY AUTOINCREMENT,
+				TaskID TEXT NOT NULL,
+				Attempt INTEGER NOT NULL,
+				StartedAt INTEGER NOT NULL,
+				EndedAt INTEGER NOT NULL DEFAULT 0,
+				Err TEXT NOT NULL DEFAULT ''
+			);
+
+			CREATE INDEX IF NOT EXISTS ` + ATTEMPTS_TABLE_NAME + `_task ON ` + ATTEMPTS_TABLE_NAME + ` (TaskID);
+		`)
+
+		return err
+	}},
+
+	{5, "failed, canceled and skipped states", func(tx *sqlx.Tx) error {
+		// older versions marked every ended task as completed, telling failures apart only by their error
+		_, err := tx.Exec(
+			`UPDATE `+TABLE_NAME+`
+			SET DownloadState = CASE ErrCategory
+				WHEN ? THEN ?
+				WHEN ? THEN ?
+				ELSE ?
+			END
+			WHERE DownloadState = ? AND COALESCE(Err, '') != ''`,
+			dsdlerr.Aborted, states.TASK_STATE_CANCELED,
+			dsdlerr.Duplicate, states.TASK_STATE_SKIPPED,
+			states.TASK_STATE_FAILED,
+			states.TASK_STATE_COMPLETED,
+		)
+
+		return err
+	}},
+
+	{6, "queue priorities", func(tx *sqlx.Tx) error {
+		_, err := ensureColumn(tx, TABLE_NAME, "Priority", "INTEGER NOT NULL DEFAULT 0")
+		if err != nil {
+			return err
+		}
+
+		added, err := ensureColumn(tx, TABLE_NAME, "Position", "INTEGER NOT NULL DEFAULT 0")
+		if err != nil || !added {
+			return err
+		}
+
+		// keep the insertion order of the already stored tasks
+		_, err = tx.Exec(`UPDATE ` + TABLE_NAME + ` SET Position = rowid`)
+
+		return err
+	}},
+
+	{7, "text columns", func(tx *sqlx.Tx) error {
+		// STRING is not a SQLite type: those columns had numeric affinity,
+		// turning numeric slugs and names into numbers
+		_, err := tx.Exec(`
+			CREATE TABLE ` + TABLE_NAME + `_new (
+				ID TEXT PRIMARY KEY,
+				Aggregator TEXT NOT NULL DEFAULT '',
+				Slug TEXT NOT NULL DEFAULT '',
+				AggregatorPageURL TEXT NOT NULL DEFAULT '',
+				FilehostUrl TEXT NOT NULL DEFAULT '',
+				DisplayName TEXT NOT NULL DEFAULT '',
+				Filename TEXT NOT NULL DEFAULT '',
+				DownloadState INTEGER NOT NULL DEFAULT 0,
+				Err TEXT NOT NULL DEFAULT '',
+				ErrCategory TEXT NOT NULL DEFAULT '',
+				Attempts INTEGER NOT NULL DEFAULT 0,
+				NextRetryAt INTEGER NOT NULL DEFAULT 0,
+				Priority INTEGER NOT NULL DEFAULT 0,
+				Position INTEGER NOT NULL DEFAULT 0
+			);
+
+			INSERT INTO ` + TABLE_NAME + `_new
+			SELECT
+				CAST(ID AS TEXT),
+				COALESCE(CAST(Aggregator AS TEXT), ''),
+				COALESCE(CAST(Slug AS TEXT), ''),
+				COALESCE(CAST(AggregatorPageURL AS TEXT), ''),
+				COALESCE(CAST(FilehostUrl AS TEXT), ''),
+				COALESCE(CAST(DisplayName AS TEXT), ''),
+				COALESCE(CAST(Filename AS TEXT), ''),
+				COALESCE(DownloadState, 0),
+				COALESCE(CAST(Err AS TEXT), ''),
+				ErrCategory,
+				Attempts,
+				NextRetryAt,
+				Priority,
+				Position
+			FROM ` + TABLE_NAME + `;
+
+			DROP TABLE ` + TABLE_NAME + `;
+
+			ALTER TABLE ` + TABLE_NAME + `_new RENAME TO ` + TABLE_NAME + `;
+		`)
+
+		return err
+	}},
+
+	{8, "task timestamps and events", func(tx *sqlx.Tx) error {
+		for _, c := range []string{"CreatedAt", "StartedAt", "FinishedAt"} {
+			if _, err := ensureColumn(tx, TABLE_NAME, c, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+		}
+
+		// task IDs start with their creation time in milliseconds
+		_, err := tx.Exec(`
+			UPDATE ` + TABLE_NAME + `
+			SET CreatedAt = CAST(substr(ID, 1, instr(ID, '-') - 1) AS INTEGER)
+			WHERE CreatedAt = 0 AND instr(ID, '-') > 1;
+
+			CREATE TABLE IF NOT EXISTS ` + EVENTS_TABLE_NAME + ` (
+				ID INTEGER PRIMARY KEY AUTOINCREMENT,
+				TaskID TEXT NOT NULL,
+				At INTEGER NOT NULL,
+				Kind TEXT NOT NULL,
+				Message TEXT NOT NULL DEFAULT '',
+				Bytes INTEGER NOT NULL DEFAULT 0
+			);
+
+			CREATE INDEX IF NOT EXISTS ` + EVENTS_TABLE_NAME + `_task ON ` + EVENTS_TABLE_NAME + ` (TaskID);
+		`)
+
+		return err
+	}},
+
+	{9, "task tags", func(tx *sqlx.Tx) error {
+		_, err := ensureColumn(tx, TABLE_NAME, "Tags", "TEXT NOT NULL DEFAULT ''")
+		return err
+	}},
+
+	// The IDs of the existing tasks are filled in by the engine, which knows how to derive them
+	{10, "canonical task identities", func(tx *sqlx.Tx) error {
+		for _, c := range []string{"AlbumID", "Filehost", "FileID"} {
+			if _, err := ensureColumn(tx, TABLE_NAME, c, "TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`
+			CREATE UNIQUE INDEX IF NOT EXISTS ` + TABLE_NAME + `_album
+			ON ` + TABLE_NAME + ` (Aggregator, AlbumID) WHERE AlbumID <> '';
+
+			CREATE UNIQUE INDEX IF NOT EXISTS ` + TABLE_NAME + `_file
+			ON ` + TABLE_NAME + ` (Filehost, FileID) WHERE FileID <> '';
+		`)
+
+		return err
+	}},
+
+	{11, "task archive", func(tx *sqlx.Tx) error {
+		if _, err := ensureColumn(tx, TABLE_NAME, "AcknowledgedAt", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		// same columns as the tasks table, without its unique indexes: an album can be archived many times
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS ` + ARCHIVE_TABLE_NAME + ` (
+				ID TEXT PRIMARY KEY,
+				Aggregator TEXT NOT NULL DEFAULT '',
+				Slug TEXT NOT NULL DEFAULT '',
+				AggregatorPageURL TEXT NOT NULL DEFAULT '',
+				FilehostUrl TEXT NOT NULL DEFAULT '',
+				DisplayName TEXT NOT NULL DEFAULT '',
+				Filename TEXT NOT NULL DEFAULT '',
+				DownloadState INTEGER NOT NULL DEFAULT 0,
+				Err TEXT NOT NULL DEFAULT '',
+				ErrCategory TEXT NOT NULL DEFAULT '',
+				Attempts INTEGER NOT NULL DEFAULT 0,
+				NextRetryAt INTEGER NOT NULL DEFAULT 0,
+				Priority INTEGER NOT NULL DEFAULT 0,
+				Position INTEGER NOT NULL DEFAULT 0,
+				CreatedAt INTEGER NOT NULL DEFAULT 0,
+				StartedAt INTEGER NOT NULL DEFAULT 0,
+				FinishedAt INTEGER NOT NULL DEFAULT 0,
+				Tags TEXT NOT NULL DEFAULT '',
+				AlbumID TEXT NOT NULL DEFAULT '',
+				Filehost TEXT NOT NULL DEFAULT '',
+				FileID TEXT NOT NULL DEFAULT '',
+				AcknowledgedAt INTEGER NOT NULL DEFAULT 0,
+				ArchivedAt INTEGER NOT NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS ` + ARCHIVE_TABLE_NAME + `_archived ON ` + ARCHIVE_TABLE_NAME + ` (ArchivedAt);
+		`)
+
+		return err
+	}},
+	{12, "task trash", func(tx *sqlx.Tx) error {
+		// removed tasks wait here to be restored or purged: like the archive, it has no unique indexes
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS ` + TRASH_TABLE_NAME + ` (
+				ID TEXT PRIMARY KEY,
+				Aggregator TEXT NOT NULL DEFAULT '',
+				Slug TEXT NOT NULL DEFAULT '',
+				AggregatorPageURL TEXT NOT NULL DEFAULT '',
+				FilehostUrl TEXT NOT NULL DEFAULT '',
+				DisplayName TEXT NOT NULL DEFAULT '',
+				Filename TEXT NOT NULL DEFAULT '',
+				DownloadState INTEGER NOT NULL DEFAULT 0,
+				Err TEXT NOT NULL DEFAULT '',
+				ErrCategory TEXT NOT NULL DEFAULT '',
+				Attempts INTEGER NOT NULL DEFAULT 0,
+				NextRetryAt INTEGER NOT NULL DEFAULT 0,
+				Priority INTEGER NOT NULL DEFAULT 0,
+				Position INTEGER NOT NULL DEFAULT 0,
+				CreatedAt INTEGER NOT NULL DEFAULT 0,
+				StartedAt INTEGER NOT NULL DEFAULT 0,
+				FinishedAt INTEGER NOT NULL DEFAULT 0,
+				Tags TEXT NOT NULL DEFAULT '',
+				AlbumID TEXT NOT NULL DEFAULT '',
+				Filehost TEXT NOT NULL DEFAULT '',
+				FileID TEXT NOT NULL DEFAULT '',
+				AcknowledgedAt INTEGER NOT NULL DEFAULT 0,
+				DeletedAt INTEGER NOT NULL,
+				Batch TEXT NOT NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS ` + TRASH_TABLE_NAME + `_batch ON ` + TRASH_TABLE_NAME + ` (Batch);
+			CREATE INDEX IF NOT EXISTS ` + TRASH_TABLE_NAME + `_deleted ON ` + TRASH_TABLE_NAME + ` (DeletedAt);
+		`)
+
+		return err
+	}},
+}
+
+// Returns the schema version the code expects
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Returns the version of the schema stored in the database, 0 if it has never been migrated
+func schemaVersion(db sqlx.Queryer) (int, error) {
+	var version int
+
+	err := sqlx.Get(db, &version, `SELECT COALESCE(MAX(Version), 0) FROM `+SCHEMA_VERSION_TABLE_NAME)
+
+	return version, err
+}
+
+// Returns the version of the database schema
+func (sdb *SQLiteDB) SchemaVersion() (int, error) {
+	return schemaVersion(sdb.db)
+}
+
+// Returns whether the database already holds tasks, so that a migration could lose data
+func hasTasksTable(db sqlx.Queryer) (bool, error) {
+	var count int
+
+	err := sqlx.Get(
+		db,
+		&count,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		TABLE_NAME,
+	)
+
+	return count != 0, err
+}
+
+/*
+Brings the schema up to date, running every missing migration in its own transaction.
+
+File databases are backed up next to the original file before being changed, so
+that a failed upgrade can be rolled back by hand.
+*/
+func (sdb *SQLiteDB) migrate(db *sqlx.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + SCHEMA_VERSION_TABLE_NAME + ` (
+			Version INTEGER PRIMARY KEY,
+			Description TEXT NOT NULL,
+			AppliedAt INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := latestSchemaVersion()
+
+	if current > latest {
+		return fmt.Errorf(
+			"DB: Schema version %d is newer than the supported one (%d): update the application",
+			current,
+			latest,
+		)
+	}
+
+	if current == latest {
+		return nil
+	}
+
+	if !sdb.inMemory() {
+		existing, err := hasTasksTable(db)
+		if err != nil {
+			return err
+		}
+
+		if existing {
+			if err := sdb.backup(db, current); err != nil {
+				return fmt.Errorf("DB: Couldn't back up the database before migrating it: %v", err)
+			}
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		err := runMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("DB: Migration %d (%s) failed: %v", m.version, m.description, err)
+		}
+
+		log.Printf("DB: Migrated schema to version %d (%s)\n", m.version, m.description)
+	}
+
+	return nil
+}
+
+func runMigration(db *sqlx.DB, m migration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := m.up(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO `+SCHEMA_VERSION_TABLE_NAME+` (Version, Description, AppliedAt) VALUES (?, ?, ?)`,
+		m.version,
+		m.description,
+		time.Now().UnixMilli(),
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Copies the database into a new file next to it, named after its schema version
+func (sdb *SQLiteDB) backup(db *sqlx.DB, version int) error {
+	// DSNs carry the path of the file between the scheme and the options
+	path := strings.TrimPrefix(sdb.path, "file:")
+	path, _, _ = strings.Cut(path, "?")
+
+	dest := fmt.Sprintf(
+		"%s.v%d-%s.bak",
+		path,
+		version,
+		time.Now().Format("20060102-150405"),
+	)
+
+	_, err := db.Exec(`VACUUM INTO ?`, dest)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("DB: Backed up the database to %s\n", dest)
+
+	return nil
+}
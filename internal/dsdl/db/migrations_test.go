@@ -0,0 +1,141 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+)
+
+func TestMigrateFrom04(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "default.db")
+
+	// database as created by 0.4
+	old, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = old.Exec(`
+		CREATE TABLE dsdl (
+			ID STRING PRIMARY KEY,
+			Aggregator STRING,
+			Slug STRING,
+			AggregatorPageURL STRING,
+			FilehostUrl STRING,
+			DisplayName STRING,
+			Filename STRING,
+			DownloadState INTEGER,
+			Err STRING
+		);
+
+		INSERT INTO dsdl VALUES ('1-a', 'doujinstyle', '022816', '', '', '022816', '', 0, NULL);
+		INSERT INTO dsdl VALUES ('2-b', 'doujinstyle', 'b', '', '', 'b', '', 2, 'The requested page has been taken down');
+		INSERT INTO dsdl VALUES ('3-c', 'doujinstyle', 'c', '', '', 'c', '', 2, NULL);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db := &SQLiteDB{name: "test", path: path}
+
+	err = db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Fatalf("Expected schema version %d, got %d", latestSchemaVersion(), version)
+	}
+
+	backups, _ := filepath.Glob(path + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected a backup of the 0.4 database, found %v", backups)
+	}
+
+	queued, err := db.Get("1-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.Position == 0 {
+		t.Fatal("Expected stored tasks to get a queue position")
+	}
+	if queued.CreatedAt.UnixMilli() != 1 {
+		t.Fatalf("Expected the creation time to be taken from the ID, got %v", queued.CreatedAt)
+	}
+
+	failed, err := db.Get("2-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.DownloadState != states.TASK_STATE_FAILED {
+		t.Fatalf("Expected a completed task with an error to be failed, got %s", states.GetStateStr(failed.DownloadState))
+	}
+
+	completed, err := db.Get("3-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.DownloadState != states.TASK_STATE_COMPLETED {
+		t.Fatalf("Expected a completed task to stay completed, got %s", states.GetStateStr(completed.DownloadState))
+	}
+
+	// numeric affinity used to turn it into 22816
+	_, err = db.db.Exec(`UPDATE dsdl SET Slug = '022816' WHERE ID = '1-a'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err = db.Get("1-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.Slug != "022816" {
+		t.Fatalf("Expected the slug to be stored as text, got %q", queued.Slug)
+	}
+
+	// opening an up to date database changes nothing
+	db.Close()
+
+	err = db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, _ = filepath.Glob(path + ".*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected no backup of an up to date database, found %v", backups)
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default.db")
+
+	db := &SQLiteDB{name: "test", path: path}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.db.Exec(
+		`INSERT INTO `+SCHEMA_VERSION_TABLE_NAME+` (Version, Description, AppliedAt) VALUES (?, 'future', 0)`,
+		latestSchemaVersion()+1,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if err := db.Open(); err == nil {
+		db.Close()
+		t.Fatal("Expected a database from a newer version to be refused")
+	}
+}
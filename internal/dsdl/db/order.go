@@ -0,0 +1,147 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+)
+
+// Only tasks waiting in the queue can be reordered
+func (sdb *SQLiteDB) checkMovable(tx *sqlx.Tx, id string) error {
+	var state int
+
+	err := tx.Get(&state, `SELECT DownloadState FROM `+TABLE_NAME+` WHERE ID = ?`, id)
+	if err != nil {
+		return fmt.Errorf("DB: Task not found: %s", id)
+	}
+
+	if state != states.TASK_STATE_QUEUED && state != states.TASK_STATE_PAUSED {
+		return fmt.Errorf("DB: Only queued or paused tasks can be moved")
+	}
+
+	return nil
+}
+
+// Runs fn inside a transaction, committing it only if fn succeeds
+func (sdb *SQLiteDB) inTx(fn func(tx *sqlx.Tx) error) error {
+	tx, err := sdb.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Sets the priority of a task. Queued tasks with a higher priority are started first
+func (sdb *SQLiteDB) SetPriority(id string, priority int) error {
+	res, err := sdb.db.Exec(`UPDATE `+TABLE_NAME+` SET Priority = ? WHERE ID = ?`, priority, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("DB: Task not found: %s", id)
+	}
+
+	return nil
+}
+
+// Moves a task at the top of the queue, raising its priority to the highest queued one
+func (sdb *SQLiteDB) MoveToTop(id string) error {
+	return sdb.inTx(func(tx *sqlx.Tx) error {
+		if err := sdb.checkMovable(tx, id); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(
+			`UPDATE `+TABLE_NAME+`
+			SET
+				Priority = (SELECT COALESCE(MAX(Priority), 0) FROM `+TABLE_NAME+` WHERE DownloadState IN (?, ?)),
+				Position = (SELECT COALESCE(MIN(Position), 0) - 1 FROM `+TABLE_NAME+`)
+			WHERE ID = ?`,
+			states.TASK_STATE_QUEUED,
+			states.TASK_STATE_PAUSED,
+			id,
+		)
+
+		return err
+	})
+}
+
+// Moves a task at the bottom of the queue, lowering its priority to the lowest queued one
+func (sdb *SQLiteDB) MoveToBottom(id string) error {
+	return sdb.inTx(func(tx *sqlx.Tx) error {
+		if err := sdb.checkMovable(tx, id); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(
+			`UPDATE `+TABLE_NAME+`
+			SET
+				Priority = (SELECT COALESCE(MIN(Priority), 0) FROM `+TABLE_NAME+` WHERE DownloadState IN (?, ?)),
+				Position = (SELECT COALESCE(MAX(Position), 0) + 1 FROM `+TABLE_NAME+`)
+			WHERE ID = ?`,
+			states.TASK_STATE_QUEUED,
+			states.TASK_STATE_PAUSED,
+			id,
+		)
+
+		return err
+	})
+}
+
+// Moves a task right before another one, taking its priority
+func (sdb *SQLiteDB) MoveBefore(id, beforeID string) error {
+	if id == beforeID {
+		return nil
+	}
+
+	return sdb.inTx(func(tx *sqlx.Tx) error {
+		if err := sdb.checkMovable(tx, id); err != nil {
+			return err
+		}
+
+		var target struct {
+			Priority int   `db:"Priority"`
+			Position int64 `db:"Position"`
+		}
+
+		err := tx.Get(
+			&target,
+			`SELECT Priority, Position FROM `+TABLE_NAME+` WHERE ID = ?`,
+			beforeID,
+		)
+		if err != nil {
+			return fmt.Errorf("DB: Task not found: %s", beforeID)
+		}
+
+		// make room for the moved task
+		_, err = tx.Exec(
+			`UPDATE `+TABLE_NAME+` SET Position = Position + 1 WHERE Position >= ?`,
+			target.Position,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			`UPDATE `+TABLE_NAME+` SET Priority = ?, Position = ? WHERE ID = ?`,
+			target.Priority,
+			target.Position,
+			id,
+		)
+
+		return err
+	})
+}
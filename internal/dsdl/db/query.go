@@ -0,0 +1,408 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Order of the tasks returned by Query
+type SortKey string
+
+const (
+	// Order in which queued tasks are started
+	SortQueue    SortKey = "queue"
+	SortCreated  SortKey = "created"
+	SortFinished SortKey = "finished"
+	SortName     SortKey = "name"
+)
+
+// Filters, order and page of a task listing. The zero value lists every task in queue order
+type TaskQuery struct {
+	// Only tasks in one of these states, any state if empty
+	States []int
+	// Only tasks of this aggregator
+	Aggregator string
+	// Only tasks whose filehost URL contains this value, e.g. "mega.nz"
+	Filehost string
+	// Only tasks that failed with this error category
+	ErrCategory dsdlerr.Category
+	// Case-insensitive text searched in the name, the slug and the filename
+	Text string
+
+	Sort SortKey
+	// Reverses the order given by Sort
+	Desc bool
+
+	// Maximum number of returned tasks, every matching task if 0
+	Limit int
+	// Where the page starts, as returned in TaskPage.NextCursor. Empty for the first page
+	Cursor string
+}
+
+// A page of the tasks matching a TaskQuery
+type TaskPage struct {
+	Tasks []*task.Task
+	// Cursor of the following page, empty if this is the last one
+	NextCursor string
+	// Number of tasks matching the filters, across every page
+	Total int
+}
+
+// A column tasks are sorted by
+type sortColumn struct {
+	// SQL expression of the column
+	expr string
+	desc bool
+	text bool
+	// value of the column for a task, either an int64 or a string
+	value func(t *task.Task) any
+}
+
+// Columns of every sort key. The ID is always the last one, so that the order is total
+var sortColumns = map[SortKey][]sortColumn{
+	SortQueue: {
+		{"Priority", true, false, func(t *task.Task) any { return int64(t.Priority) }},
+		{"Position", false, false, func(t *task.Task) any { return t.Position }},
+	},
+	SortCreated: {
+		{"CreatedAt", false, false, func(t *task.Task) any { return toUnixMilli(t.CreatedAt) }},
+	},
+	SortFinished: {
+		{"FinishedAt", false, false, func(t *task.Task) any { return toUnixMilli(t.FinishedAt) }},
+	},
+	SortName: {
+		// SQLite's lower() only folds ASCII letters, just like foldASCII
+		{"lower(DisplayName)", false, true, func(t *task.Task) any { return foldASCII(t.DisplayName) }},
+	},
+}
+
+var idColumn = sortColumn{"ID", false, true, func(t *task.Task) any { return t.Id }}
+
+// Returns whether s is a known sort key
+func IsValidSortKey(s string) bool {
+	_, ok := sortColumns[SortKey(s)]
+	return ok
+}
+
+// Returns the columns the query is sorted by, with their final direction
+func (q *TaskQuery) columns() ([]sortColumn, error) {
+	key := q.Sort
+	if key == "" {
+		key = SortQueue
+	}
+
+	base, ok := sortColumns[key]
+	if !ok {
+		return nil, fmt.Errorf("DB: Not a valid sort key: %q", key)
+	}
+
+	cols := append(append([]sortColumn{}, base...), idColumn)
+
+	if q.Desc {
+		for i := range cols {
+			cols[i].desc = !cols[i].desc
+		}
+	}
+
+	return cols, nil
+}
+
+// Lowers the ASCII letters of s, leaving every other character untouched
+func foldASCII(s string) string {
+	b := []byte(s)
+
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+
+	return string(b)
+}
+
+// Returns the sort values of a task, which make up the cursor of the following page
+func rowValues(cols []sortColumn, t *task.Task) []any {
+	values := make([]any, len(cols))
+
+	for i, c := range cols {
+		values[i] = c.value(t)
+	}
+
+	return values
+}
+
+// Compares the sort values of two tasks, honoring the direction of every column
+func compareRows(cols []sortColumn, a, b []any) int {
+	for i, c := range cols {
+		var cmp int
+
+		if c.text {
+			cmp = strings.Compare(a[i].(string), b[i].(string))
+		} else {
+			x, y := a[i].(int64), b[i].(int64)
+
+			switch {
+			case x < y:
+				cmp = -1
+			case x > y:
+				cmp = 1
+			}
+		}
+
+		if c.desc {
+			cmp = -cmp
+		}
+
+		if cmp != 0 {
+			return cmp
+		}
+	}
+
+	return 0
+}
+
+func encodeCursor(values []any) string {
+	data, _ := json.Marshal(values)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cols []sortColumn, cursor string) ([]any, error) {
+	invalid := fmt.Errorf("DB: Not a valid cursor")
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalid
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var raw []any
+	if err := dec.Decode(&raw); err != nil || len(raw) != len(cols) {
+		return nil, invalid
+	}
+
+	values := make([]any, len(cols))
+
+	for i, c := range cols {
+		switch v := raw[i].(type) {
+		case string:
+			if !c.text {
+				return nil, invalid
+			}
+
+			values[i] = v
+
+		case json.Number:
+			n, err := v.Int64()
+			if c.text || err != nil {
+				return nil, invalid
+			}
+
+			values[i] = n
+
+		default:
+			return nil, invalid
+		}
+	}
+
+	return values, nil
+}
+
+// Returns the filters of the query as a SQL condition and its arguments
+func (q *TaskQuery) where() (string, []any) {
+	conds := []string{"1 = 1"}
+	args := []any{}
+
+	if len(q.States) != 0 {
+		conds = append(conds, "DownloadState IN (?"+strings.Repeat(", ?", len(q.States)-1)+")")
+		for _, s := range q.States {
+			args = append(args, s)
+		}
+	}
+
+	if q.Aggregator != "" {
+		conds = append(conds, "Aggregator = ?")
+		args = append(args, q.Aggregator)
+	}
+
+	if q.Filehost != "" {
+		conds = append(conds, `FilehostUrl LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(q.Filehost))
+	}
+
+	if q.ErrCategory != "" {
+		conds = append(conds, "ErrCategory = ?")
+		args = append(args, string(q.ErrCategory))
+	}
+
+	if q.Text != "" {
+		pattern := likePattern(q.Text)
+
+		conds = append(
+			conds,
+			`(DisplayName LIKE ? ESCAPE '\' OR Slug LIKE ? ESCAPE '\' OR Filename LIKE ? ESCAPE '\')`,
+		)
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// Returns a LIKE pattern matching any value containing s
+func likePattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	return "%" + r.Replace(s) + "%"
+}
+
+// Returns whether a task matches the filters of the query, just like the where() condition
+func (q *TaskQuery) matches(t *task.Task) bool {
+	if len(q.States) != 0 && !containsInt(q.States, t.DownloadState) {
+		return false
+	}
+
+	if q.Aggregator != "" && t.Aggregator != q.Aggregator {
+		return false
+	}
+
+	if q.Filehost != "" && !strings.Contains(foldASCII(t.FilehostUrl), foldASCII(q.Filehost)) {
+		return false
+	}
+
+	if q.ErrCategory != "" && t.ErrCategory() != q.ErrCategory {
+		return false
+	}
+
+	if q.Text != "" {
+		text := foldASCII(q.Text)
+
+		if !strings.Contains(foldASCII(t.DisplayName), text) &&
+			!strings.Contains(foldASCII(t.Slug), text) &&
+			!strings.Contains(foldASCII(t.Filename), text) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the SQL condition selecting the rows that come after the cursor
+func keysetCondition(cols []sortColumn, values []any) (string, []any) {
+	var ors []string
+	var args []any
+
+	for i, c := range cols {
+		var ands []string
+
+		for j := range i {
+			ands = append(ands, cols[j].expr+" = ?")
+			args = append(args, values[j])
+		}
+
+		op := ">"
+		if c.desc {
+			op = "<"
+		}
+
+		ands = append(ands, c.expr+" "+op+" ?")
+		args = append(args, values[i])
+
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// Returns the ORDER BY clause of the columns
+func orderBy(cols []sortColumn) string {
+	parts := make([]string, len(cols))
+
+	for i, c := range cols {
+		dir := "ASC"
+		if c.desc {
+			dir = "DESC"
+		}
+
+		parts[i] = c.expr + " " + dir
+	}
+
+	return "ORDER BY " + strings.Join(parts, ", ")
+}
+
+/*
+Returns a page of the tasks matching the filters of q, in the requested order.
+
+Pages are delimited by the sort values of their last task rather than by an offset,
+so tasks added or removed in the meantime never make a page skip or repeat tasks.
+*/
+func (sdb *SQLiteDB) Query(q TaskQuery) (*TaskPage, error) {
+	cols, err := q.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := q.where()
+
+	page := &TaskPage{Tasks: make([]*task.Task, 0)}
+
+	err = sdb.db.Get(&page.Total, `SELECT COUNT(*) FROM `+TABLE_NAME+` WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Cursor != "" {
+		values, err := decodeCursor(cols, q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		cond, condArgs := keysetCondition(cols, values)
+
+		where += " AND " + cond
+		args = append(args, condArgs...)
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM ` + TABLE_NAME + ` WHERE ` + where + ` ` + orderBy(cols)
+
+	// one more task tells whether there's a following page
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	}
+
+	tasks, err := sdb.selectTasks(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return paginate(page, cols, tasks, q.Limit), nil
+}
+
+// Fills the page with the first limit tasks, setting the cursor if there are more
+func paginate(page *TaskPage, cols []sortColumn, tasks []*task.Task, limit int) *TaskPage {
+	if limit > 0 && len(tasks) > limit {
+		tasks = tasks[:limit]
+		page.NextCursor = encodeCursor(rowValues(cols, tasks[len(tasks)-1]))
+	}
+
+	page.Tasks = tasks
+
+	return page
+}
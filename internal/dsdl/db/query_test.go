@@ -0,0 +1,124 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+func TestQuery(t *testing.T) {
+	forEachStore(t, testQuery)
+}
+
+func testQuery(t *testing.T, db taskStore) {
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	created := time.Now().Add(-time.Hour)
+
+	for i, spec := range []struct {
+		name, aggregator, filehost string
+		state                      int
+		category                   dsdlerr.Category
+	}{
+		{"Delta", "doujinstyle", "https://mega.nz/file/1", states.TASK_STATE_COMPLETED, ""},
+		{"alpha", "doujinstyle", "https://www.mediafire.com/1", states.TASK_STATE_FAILED, dsdlerr.Network},
+		{"Charlie 100%", "sukidesuost", "https://mega.nz/file/2", states.TASK_STATE_FAILED, dsdlerr.NotFound},
+		{"bravo", "doujinstyle", "", states.TASK_STATE_QUEUED, ""},
+		{"echo", "sukidesuost", "https://mega.nz/file/3", states.TASK_STATE_SKIPPED, dsdlerr.Duplicate},
+	} {
+		tsk := task.NewTask(spec.name)
+		tsk.Aggregator = spec.aggregator
+		tsk.FilehostUrl = spec.filehost
+		tsk.DownloadState = spec.state
+		tsk.CreatedAt = created.Add(time.Duration(i) * time.Minute)
+
+		if spec.category != "" {
+			tsk.Err = dsdlerr.New(spec.category, "error")
+		}
+
+		if _, err := db.Insert(tsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(p *TaskPage) string {
+		n := make([]string, 0, len(p.Tasks))
+		for _, tsk := range p.Tasks {
+			n = append(n, tsk.DisplayName)
+		}
+
+		return strings.Join(n, ",")
+	}
+
+	query := func(q TaskQuery) *TaskPage {
+		t.Helper()
+
+		p, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	cases := []struct {
+		q    TaskQuery
+		want string
+	}{
+		{TaskQuery{}, "Delta,alpha,Charlie 100%,bravo,echo"},
+		{TaskQuery{Sort: SortName}, "alpha,bravo,Charlie 100%,Delta,echo"},
+		{TaskQuery{Sort: SortCreated, Desc: true}, "echo,bravo,Charlie 100%,alpha,Delta"},
+		{TaskQuery{States: []int{states.TASK_STATE_FAILED, states.TASK_STATE_SKIPPED}}, "alpha,Charlie 100%,echo"},
+		{TaskQuery{Aggregator: "sukidesuost"}, "Charlie 100%,echo"},
+		{TaskQuery{Filehost: "MEGA.nz"}, "Delta,Charlie 100%,echo"},
+		{TaskQuery{ErrCategory: dsdlerr.NotFound}, "Charlie 100%"},
+		{TaskQuery{Text: "ALPHA"}, "alpha"},
+		// LIKE wildcards are matched literally
+		{TaskQuery{Text: "0%"}, "Charlie 100%"},
+		{TaskQuery{Text: "_"}, ""},
+	}
+
+	for _, c := range cases {
+		if got := names(query(c.q)); got != c.want {
+			t.Fatalf("Query(%+v): expected %q, got %q", c.q, c.want, got)
+		}
+	}
+
+	// walk every page
+	q := TaskQuery{Sort: SortName, Desc: true, Limit: 2}
+	var all []string
+
+	for {
+		p := query(q)
+		if p.Total != 5 {
+			t.Fatalf("Expected a total of 5 tasks, got %d", p.Total)
+		}
+
+		all = append(all, names(p))
+
+		if p.NextCursor == "" {
+			break
+		}
+
+		q.Cursor = p.NextCursor
+	}
+
+	if got := strings.Join(all, "|"); got != "echo,Delta|Charlie 100%,bravo|alpha" {
+		t.Fatalf("Unexpected pages: %q", got)
+	}
+
+	if _, err := db.Query(TaskQuery{Cursor: "not a cursor"}); err == nil {
+		t.Fatal("Expected an error for an invalid cursor")
+	}
+
+	if _, err := db.Query(TaskQuery{Sort: "size"}); err == nil {
+		t.Fatal("Expected an error for an invalid sort key")
+	}
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Full-text index of the tasks, only available in builds with the sqlite_fts5 tag
+const SEARCH_TABLE_NAME string = "task_search"
+
+// Columns of the tasks covered by the full-text search
+const searchColumns = `DisplayName, Filename, Slug, Aggregator, AggregatorPageURL, Tags`
+
+// A word or a quoted phrase of a search, already split into tokens
+type searchTerm struct {
+	tokens []string
+	// whether the last token only needs to be the beginning of a word
+	prefix bool
+}
+
+/*
+Parses a search made of words and "quoted phrases", all of which must be found.
+
+A trailing * turns a word or a phrase into a prefix search: "touhou arr*" also finds
+"Touhou Arrange". Words are split into tokens just like the indexed text, so
+"c104-album" is looked for as the phrase "c104 album".
+*/
+func parseSearch(s string) ([]searchTerm, error) {
+	var terms []searchTerm
+
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var text string
+
+		if s[0] == '"' {
+			// a phrase missing its closing quote runs until the end
+			end := strings.IndexByte(s[1:], '"')
+			if end == -1 {
+				text, s = s[1:], ""
+			} else {
+				text, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end == -1 {
+				end = len(s)
+			}
+
+			text, s = s[:end], s[end:]
+		}
+
+		prefix := strings.HasSuffix(text, "*") || strings.HasPrefix(s, "*")
+		s = strings.TrimPrefix(s, "*")
+
+		if tokens := tokenize(text); len(tokens) != 0 {
+			terms = append(terms, searchTerm{tokens: tokens, prefix: prefix})
+		}
+	}
+
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("DB: Search: Nothing to search")
+	}
+
+	return terms, nil
+}
+
+// Splits text into lowercase words, like the unicode61 tokenizer of the index does
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Returns the FTS5 query matching the terms
+func ftsQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+
+	// tokens hold letters and digits only, so they never need to be escaped
+	for i, t := range terms {
+		parts[i] = `"` + strings.Join(t.tokens, " ") + `"`
+		if t.prefix {
+			parts[i] += "*"
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Returns whether every term is found in one of the searchable fields of a task
+func matchesSearch(terms []searchTerm, t *task.Task) bool {
+	fields := [][]string{
+		tokenize(t.DisplayName),
+		tokenize(t.Filename),
+		tokenize(t.Slug),
+		tokenize(t.Aggregator),
+		tokenize(t.AggregatorPageURL),
+		tokenize(strings.Join(t.Tags, ", ")),
+	}
+
+	for _, term := range terms {
+		if !slices.ContainsFunc(fields, term.foundIn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns whether the tokens of the term appear one after the other in words
+func (term searchTerm) foundIn(words []string) bool {
+	n := len(term.tokens)
+
+	for i := 0; i+n <= len(words); i++ {
+		found := true
+
+		for j, token := range term.tokens {
+			last := j == n-1
+
+			if words[i+j] != token && !(last && term.prefix && strings.HasPrefix(words[i+j], token)) {
+				found = false
+				break
+			}
+		}
+
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Copies the searchable columns of the tasks into the index
+const indexSelect = `
+	INSERT INTO ` + SEARCH_TABLE_NAME + ` (TaskID, ` + searchColumns + `)
+	SELECT
+		ID,
+		COALESCE(DisplayName, ''),
+		COALESCE(Filename, ''),
+		COALESCE(Slug, ''),
+		COALESCE(Aggregator, ''),
+		COALESCE(AggregatorPageURL, ''),
+		Tags
+	FROM ` + TABLE_NAME
+
+/*
+Creates the full-text index if the SQLite build supports FTS5, and rebuilds it from the
+stored tasks, since a build without FTS5 may have changed them in the meantime.
+
+Returns false if FTS5 isn't available, in which case searches scan every task.
+*/
+func ensureSearchIndex(db *sqlx.DB) (bool, error) {
+	_, err := db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS ` + SEARCH_TABLE_NAME + ` USING fts5(
+			TaskID UNINDEXED,
+			` + searchColumns + `,
+			tokenize = 'unicode61 remove_diacritics 0'
+		)
+	`)
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM ` + SEARCH_TABLE_NAME); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(indexSelect); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Refreshes the index entry of a task after it has been added or changed
+func (sdb *SQLiteDB) indexTask(id string) error {
+	if !sdb.fts {
+		return nil
+	}
+
+	_, err := sdb.db.Exec(`DELETE FROM `+SEARCH_TABLE_NAME+` WHERE TaskID = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.db.Exec(indexSelect+` WHERE ID = ?`, id)
+
+	return err
+}
+
+// Drops the index entries of the removed tasks
+func (sdb *SQLiteDB) pruneIndex() error {
+	if !sdb.fts {
+		return nil
+	}
+
+	_, err := sdb.db.Exec(
+		`DELETE FROM ` + SEARCH_TABLE_NAME + ` WHERE TaskID NOT IN (SELECT ID FROM ` + TABLE_NAME + `)`,
+	)
+
+	return err
+}
+
+// Returns whether searches use the full-text index rather than scanning every task
+func (sdb *SQLiteDB) FullTextSearch() bool {
+	return sdb.fts
+}
+
+/*
+Returns up to limit tasks whose name, filename, slug, service, page URL or tags match
+the search, every match if limit is 0. See parseSearch for the syntax.
+
+The best matches come first when the full-text index is available, otherwise the most
+recently added ones do.
+*/
+func (sdb *SQLiteDB) Search(text string, limit int) ([]*task.Task, error) {
+	terms, err := parseSearch(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sdb.fts {
+		tasks, err := sdb.selectTasks(`SELECT ` + taskColumns + ` FROM ` + TABLE_NAME)
+		if err != nil {
+			return nil, err
+		}
+
+		return filterSearch(terms, tasks, limit), nil
+	}
+
+	query := `
+		WITH hits AS (
+			SELECT TaskID, bm25(` + SEARCH_TABLE_NAME + `) AS Rank
+			FROM ` + SEARCH_TABLE_NAME + `
+			WHERE ` + SEARCH_TABLE_NAME + ` MATCH ?
+		)
+		SELECT ` + taskColumns + `
+		FROM ` + TABLE_NAME + ` JOIN hits ON hits.TaskID = ` + TABLE_NAME + `.ID
+		ORDER BY hits.Rank, CreatedAt DESC, ID DESC
+	`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	return sdb.selectTasks(query, ftsQuery(terms))
+}
+
+// Keeps the tasks matching the terms, most recently added first, up to limit if it isn't 0
+func filterSearch(terms []searchTerm, tasks []*task.Task, limit int) []*task.Task {
+	found := make([]*task.Task, 0)
+
+	for _, t := range tasks {
+		if matchesSearch(terms, t) {
+			found = append(found, t)
+		}
+	}
+
+	slices.SortFunc(found, func(a, b *task.Task) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+
+		return strings.Compare(b.Id, a.Id)
+	})
+
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+
+	return found
+}
+
+// Replaces the tags of a task
+func (sdb *SQLiteDB) SetTags(id string, tags []string) error {
+	res, err := sdb.db.Exec(`UPDATE `+TABLE_NAME+` SET Tags = ? WHERE ID = ?`, joinTags(tags), id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("DB: Task not found: %s", id)
+	}
+
+	return sdb.indexTask(id)
+}
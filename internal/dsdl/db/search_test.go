@@ -0,0 +1,176 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+func TestParseSearch(t *testing.T) {
+	for input, expected := range map[string]string{
+		`touhou`:                 `"touhou"`,
+		`Touhou ARR*`:            `"touhou" "arr"*`,
+		`"c104 album" circle`:    `"c104 album" "circle"`,
+		`"c104 al"*`:             `"c104 al"*`,
+		`c104-album`:             `"c104 album"`,
+		`"unterminated phrase`:   `"unterminated phrase"`,
+		`  spaced   out  `:       `"spaced" "out"`,
+		`東方 "Bad Apple!!" feat.`: `"東方" "bad apple" "feat"`,
+	} {
+		terms, err := parseSearch(input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+
+		if got := ftsQuery(terms); got != expected {
+			t.Errorf("%q: expected %s, got %s", input, expected, got)
+		}
+	}
+
+	for _, input := range []string{"", "   ", `""`, "* - !"} {
+		if _, err := parseSearch(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, testSearch)
+}
+
+func testSearch(t *testing.T, db taskStore) {
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ids := make(map[string]string)
+
+	for i, spec := range []struct {
+		name, filename, aggregator string
+		state                      int
+	}{
+		{"[C104] Circle Alpha - Touhou Arrange Vol.3", "Circle Alpha - Touhou Arrange Vol.3.zip", "doujinstyle", states.TASK_STATE_COMPLETED},
+		{"[C103] Circle Beta - Album", "beta.rar", "doujinstyle", states.TASK_STATE_COMPLETED},
+		{"Arrangement Collection", "", "sukidesuost", states.TASK_STATE_QUEUED},
+		{"Circle Alpha Best", "", "sukidesuost", states.TASK_STATE_FAILED},
+	} {
+		tsk := task.NewTask(fmt.Sprintf("page-%d", i))
+		tsk.DisplayName = spec.name
+		tsk.Filename = spec.filename
+		tsk.Aggregator = spec.aggregator
+		tsk.DownloadState = spec.state
+
+		id, err := db.Insert(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids[spec.name] = id
+	}
+
+	search := func(text string) string {
+		t.Helper()
+
+		found, err := db.Search(text, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := make([]string, 0, len(found))
+		for _, tsk := range found {
+			names = append(names, tsk.DisplayName)
+		}
+
+		slices.Sort(names)
+
+		return strings.Join(names, " | ")
+	}
+
+	for text, expected := range map[string]string{
+		"c104":              "[C104] Circle Alpha - Touhou Arrange Vol.3",
+		"arrange":           "[C104] Circle Alpha - Touhou Arrange Vol.3",
+		"arrange*":          "Arrangement Collection | [C104] Circle Alpha - Touhou Arrange Vol.3",
+		`"circle alpha"`:    "Circle Alpha Best | [C104] Circle Alpha - Touhou Arrange Vol.3",
+		`"alpha circle"`:    "",
+		"circle c10*":       "[C103] Circle Beta - Album | [C104] Circle Alpha - Touhou Arrange Vol.3",
+		"zip":               "[C104] Circle Alpha - Touhou Arrange Vol.3",
+		"sukidesuost":       "Arrangement Collection | Circle Alpha Best",
+		"circle-alpha-best": "Circle Alpha Best",
+		"circle missing":    "",
+	} {
+		if got := search(text); got != expected {
+			t.Errorf("%q: expected %q, got %q", text, expected, got)
+		}
+	}
+
+	found, err := db.Search("circle", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Errorf("Expected the limit to be honored, got %d tasks", len(found))
+	}
+
+	// the search follows the changes of the tasks
+	tsk, err := db.Get(ids["Arrangement Collection"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tsk.DisplayName = "Renamed Collection"
+	if err := db.Update(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := search("arrangement"); got != "" {
+		t.Errorf("Expected the old name not to be found anymore, got %q", got)
+	}
+	if got := search("renamed"); got != "Renamed Collection" {
+		t.Errorf("Expected the new name to be found, got %q", got)
+	}
+
+	if err := db.SetTags(ids["Circle Alpha Best"], []string{"Reitaisai 21, best of", "best of"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := search("reitaisai"); got != "Circle Alpha Best" {
+		t.Errorf("Expected the tags to be searched, got %q", got)
+	}
+
+	tagged, err := db.Get(ids["Circle Alpha Best"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tagged.Tags, "|"); got != "Reitaisai 21|best of" {
+		t.Errorf("Expected the tags to be cleaned up, got %q", got)
+	}
+
+	// updates made by the engine don't drop the tags
+	tagged.DownloadState = states.TASK_STATE_QUEUED
+	tagged.Tags = nil
+	if err := db.Update(tagged); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := search("reitaisai"); got != "Circle Alpha Best" {
+		t.Errorf("Expected the tags to survive an update, got %q", got)
+	}
+
+	if err := db.SetTags("missing", []string{"tag"}); err == nil {
+		t.Error("Expected an error when tagging a missing task")
+	}
+
+	if _, err := db.RemoveEnded(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := search("circle"); got != "Circle Alpha Best" {
+		t.Errorf("Expected the removed tasks not to be found, got %q", got)
+	}
+}
@@ -1,51 +1,91 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
 	"github.com/relepega/doujinstyle-downloader/internal/task"
 )
 
 const (
-	TABLE_NAME string = "dsdl"
+	TABLE_NAME          string = "dsdl"
+	JOURNAL_TABLE_NAME  string = "journal"
+	ATTEMPTS_TABLE_NAME string = "attempts"
+	EVENTS_TABLE_NAME   string = "task_events"
 
 	ERR_STATE_OUTSIDE_CONSTRAINTS = "CompletionState is not a value within constraints"
 )
 
+// Returned when a task with the same ID, album or file is already stored
+var ErrDuplicate = errors.New("DB: Insert: Task already present in the database")
+
+// Returned when the stored state of a task cannot move to the requested one, e.g. a
+// runner saving a task that has been aborted in the meantime
+var ErrIllegalTransition = errors.New("DB: Illegal state transition")
+
+// Returned when resetting a task whose stored state is running, its runner being still alive
+var ErrResetRunning = errors.New("Cannot reset a running task")
+
+// States a task can be reset from: every one moving to queued, but running
+func resettableStates() []int {
+	return slices.DeleteFunc(states.AllowedFrom(states.TASK_STATE_QUEUED), func(s int) bool {
+		return s == states.TASK_STATE_RUNNING
+	})
+}
+
 type SQLiteDB struct {
 	name string
 
 	path string
 
 	db *sqlx.DB
+
+	// whether the full-text index is available
+	fts bool
+
+	// called whenever a task may have become startable
+	onQueueChange func()
 }
 
-func NewSQLite(inMemory bool) *SQLiteDB {
-	path := ":memory:"
-	name := "In-Memory SQLite DB"
+// Default location of the file-based database
+var DEFAULT_SQLITE_PATH = filepath.Join(".", "Database", "default.db")
 
+func NewSQLite(inMemory bool) *SQLiteDB {
 	if inMemory {
-		goto ret
+		return NewSQLiteFromPath(":memory:")
 	}
 
-	name = "File-Based SQLite DB"
-
-	path = filepath.Join(".", "Database")
-	appUtils.MkdirAll(path)
-
-	path = filepath.Join(path, "default.db")
+	return NewSQLiteFromPath(DEFAULT_SQLITE_PATH)
+}
 
-ret:
-	return &SQLiteDB{
-		name: name,
+// Returns a database stored at path, which can also be a SQLite DSN (e.g. "file:tasks.db?cache=shared").
+//
+// The directory of a plain file path is created if missing
+func NewSQLiteFromPath(path string) *SQLiteDB {
+	sdb := &SQLiteDB{
+		name: "File-Based SQLite DB",
 		path: path,
 	}
+
+	if sdb.inMemory() {
+		sdb.name = "In-Memory SQLite DB"
+	} else if !strings.HasPrefix(path, "file:") {
+		appUtils.MkdirAll(filepath.Dir(path))
+	}
+
+	return sdb
 }
 
 func (db *SQLiteDB) GetDB() *sqlx.DB {
@@ -59,23 +99,28 @@ func (sdb *SQLiteDB) Open() error {
 		return err
 	}
 
-	db.SetMaxOpenConns(2)
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS ` + TABLE_NAME + ` (
-			ID STRING PRIMARY KEY,
-			Aggregator STRING,
-			Slug STRING,
-			AggregatorPageURL STRING,
-			FilehostUrl STRING,
-			DisplayName STRING,
-			Filename STRING,
-			DownloadState INTEGER,
-			Err STRING
-		);
-	`); err != nil {
+	// every connection to an in-memory database sees a different, empty, database
+	if sdb.inMemory() {
+		db.SetMaxOpenConns(1)
+	} else {
+		db.SetMaxOpenConns(2)
+	}
+
+	if err := sdb.migrate(db); err != nil {
+		db.Close()
 		return err
 	}
 
+	sdb.fts, err = ensureSearchIndex(db)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("DB: Could not build the search index: %v", err)
+	}
+
+	if !sdb.fts {
+		log.Println("DB: SQLite has been built without FTS5, searches will scan every task")
+	}
+
 	sdb.db = db
 
 	return nil
@@ -89,6 +134,206 @@ func (sdb *SQLiteDB) Name() string {
 	return sdb.name
 }
 
+func (sdb *SQLiteDB) inMemory() bool {
+	return sdb.path == ":memory:" ||
+		strings.HasPrefix(sdb.path, "file::memory:") ||
+		strings.Contains(sdb.path, "mode=memory")
+}
+
+// Returns whether the tasks survive a restart of the application
+func (sdb *SQLiteDB) Persistent() bool {
+	return !sdb.inMemory()
+}
+
+// Sets the function called every time a task is queued, so that the queue runner
+// doesn't need to poll the database
+func (sdb *SQLiteDB) OnQueueChange(fn func()) {
+	sdb.onQueueChange = fn
+}
+
+func (sdb *SQLiteDB) notifyQueue() {
+	if sdb.onQueueChange != nil {
+		sdb.onQueueChange()
+	}
+}
+
+// Adds a column to an already existing table, unless it is already there
+//
+// Returns whether the column has been added
+func ensureColumn(db sqlx.Ext, table, column, decl string) (bool, error) {
+	var count int
+
+	err := sqlx.Get(
+		db,
+		&count,
+		`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+		table,
+		column,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if count != 0 {
+		return false, nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+
+	return err == nil, err
+}
+
+// Columns selected by every query returning whole tasks, in the order expected by scanTask
+const taskColumns = `
+	ID,
+	COALESCE(Aggregator, ''),
+	COALESCE(Slug, ''),
+	COALESCE(AggregatorPageURL, ''),
+	COALESCE(FilehostUrl, ''),
+	COALESCE(DisplayName, ''),
+	COALESCE(Filename, ''),
+	DownloadState,
+	COALESCE(Err, ''),
+	COALESCE(ErrCategory, ''),
+	COALESCE(Attempts, 0),
+	COALESCE(NextRetryAt, 0),
+	Priority,
+	Position,
+	CreatedAt,
+	StartedAt,
+	FinishedAt,
+	Tags,
+	AlbumID,
+	Filehost,
+	FileID,
+	AcknowledgedAt`
+
+// Order in which queued tasks are started
+const queueOrder = `ORDER BY Priority DESC, Position ASC`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Builds a task from a row containing the taskColumns, followed by the columns scanned into extra
+func scanTask(row rowScanner, extra ...any) (*task.Task, error) {
+	t := task.NewTask("")
+
+	var dbErr, dbErrCategory, tags string
+	var nextRetryAt, createdAt, startedAt, finishedAt, acknowledgedAt int64
+
+	dest := []any{
+		&t.Id,
+		&t.Aggregator,
+		&t.Slug,
+		&t.AggregatorPageURL,
+		&t.FilehostUrl,
+		&t.DisplayName,
+		&t.Filename,
+		&t.DownloadState,
+		&dbErr,
+		&dbErrCategory,
+		&t.Attempts,
+		&nextRetryAt,
+		&t.Priority,
+		&t.Position,
+		&createdAt,
+		&startedAt,
+		&finishedAt,
+		&tags,
+		&t.AlbumID,
+		&t.Filehost,
+		&t.FileID,
+		&acknowledgedAt,
+	}
+
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return t, err
+	}
+
+	t.Err = dsdlerr.FromString(dsdlerr.Category(dbErrCategory), dbErr)
+
+	t.NextRetryAt = fromUnixMilli(nextRetryAt)
+	t.CreatedAt = fromUnixMilli(createdAt)
+	t.StartedAt = fromUnixMilli(startedAt)
+	t.FinishedAt = fromUnixMilli(finishedAt)
+	t.AcknowledgedAt = fromUnixMilli(acknowledgedAt)
+	t.Tags = splitTags(tags)
+
+	return t, nil
+}
+
+// Runs a query returning the taskColumns of many tasks
+func (sdb *SQLiteDB) selectTasks(query string, args ...any) ([]*task.Task, error) {
+	dest := make([]*task.Task, 0)
+
+	rows, err := sdb.db.Queryx(query, args...)
+	if err != nil {
+		return dest, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return dest, err
+		}
+
+		dest = append(dest, t)
+	}
+
+	return dest, rows.Err()
+}
+
+// Splits an error into the message and category stored in the Err and ErrCategory columns
+func errToColumns(err error) (string, string) {
+	if err == nil {
+		return "", ""
+	}
+
+	return err.Error(), string(dsdlerr.CategoryOf(err))
+}
+
+/*
+Turns the violations of the unique constraints into ErrDuplicate.
+
+The message is matched instead of the sqlite3.Error codes, which only exist in cgo
+builds: SQLite reports the primary keys too as "UNIQUE constraint failed"
+*/
+func duplicateErr(err error) error {
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+// Tags are stored comma separated, as a single column
+func joinTags(tags []string) string {
+	return strings.Join(task.CleanTags(tags), ", ")
+}
+
+func splitTags(s string) []string {
+	return task.CleanTags([]string{s})
+}
+
+// Timestamps are stored as unix milliseconds, 0 meaning "not set"
+func toUnixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
+
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(ms)
+}
+
 // Returns the total number of stored tasks
 func (sdb *SQLiteDB) Count() (int, error) {
 	var count int
@@ -107,7 +352,7 @@ func (sdb *SQLiteDB) Count() (int, error) {
 func (sdb *SQLiteDB) CountFromState(completionState int) (int, error) {
 	var count int = 0
 
-	if completionState < 0 || completionState > states.MaxCompletionState() {
+	if !states.IsValid(completionState) {
 		return count, fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
 	}
 
@@ -130,7 +375,7 @@ func (sdb *SQLiteDB) CountFromState(completionState int) (int, error) {
 func (sdb *SQLiteDB) CountFromStateNoErr(state int) int {
 	var count int = 0
 
-	if state < 0 || state > states.MaxCompletionState() {
+	if !states.IsValid(state) {
 		return -1
 	}
 
@@ -151,12 +396,10 @@ func (sdb *SQLiteDB) CountFromStateNoErr(state int) int {
 
 // Adds a task to the database
 //
-// Returns the Task ID and an eventual error
+// # Returns the Task ID and an eventual error
+//
+// Returns ErrDuplicate if the ID, the album or the file of the task is already stored
 func (sdb *SQLiteDB) Insert(nv *task.Task) (string, error) {
-	if found, _, _ := sdb.Find(nv.Slug); found {
-		return nv.Id, fmt.Errorf("DB: Insert: Task already present in the database")
-	}
-
 	s, err := sdb.db.Prepare(`
 		INSERT INTO ` + TABLE_NAME + ` (
 			ID,
@@ -167,19 +410,32 @@ func (sdb *SQLiteDB) Insert(nv *task.Task) (string, error) {
 			DisplayName,
 			Filename,
 			DownloadState,
-			Err
+			Err,
+			ErrCategory,
+			Attempts,
+			NextRetryAt,
+			Priority,
+			Position,
+			CreatedAt,
+			StartedAt,
+			FinishedAt,
+			Tags,
+			AlbumID,
+			Filehost,
+			FileID
+		)
+		VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+			(SELECT COALESCE(MAX(Position), 0) + 1 FROM ` + TABLE_NAME + `),
+			?, ?, ?, ?, ?, ?, ?
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return nv.Id, err
 	}
 	defer s.Close()
 
-	dbErr := ""
-	if nv.Err != nil {
-		dbErr = nv.Err.Error()
-	}
+	dbErr, dbErrCategory := errToColumns(nv.Err)
 
 	_, err = s.Exec(
 		nv.Id,
@@ -191,9 +447,31 @@ func (sdb *SQLiteDB) Insert(nv *task.Task) (string, error) {
 		nv.Filename,
 		nv.DownloadState,
 		dbErr,
+		dbErrCategory,
+		nv.Attempts,
+		toUnixMilli(nv.NextRetryAt),
+		nv.Priority,
+		toUnixMilli(nv.CreatedAt),
+		toUnixMilli(nv.StartedAt),
+		toUnixMilli(nv.FinishedAt),
+		joinTags(nv.Tags),
+		nv.AlbumID,
+		nv.Filehost,
+		nv.FileID,
 	)
+	if err != nil {
+		return nv.Id, duplicateErr(err)
+	}
+
+	if err := sdb.indexTask(nv.Id); err != nil {
+		return nv.Id, err
+	}
 
-	return nv.Id, err
+	if nv.DownloadState == states.TASK_STATE_QUEUED {
+		sdb.notifyQueue()
+	}
+
+	return nv.Id, nil
 }
 
 // Checks whether a task with an equal value is already present in the database
@@ -210,31 +488,20 @@ func (sdb *SQLiteDB) Find(slugOrID string) (bool, int, error) {
 
 	if err := sdb.db.Get(
 		&count,
-		`SELECT COUNT(*) FROM `+TABLE_NAME+` WHERE ID = ? OR Slug ?`,
+		`SELECT COUNT(*) FROM `+TABLE_NAME+` WHERE ID = ? OR Slug = ?`,
 		slugOrID,
 		slugOrID,
 	); err != nil {
 		return false, count, err
 	}
 
-	return true, count, nil
+	return count > 0, count, nil
 }
 
 // Checks whether a task with an equal value is already present in the database
 func (sdb *SQLiteDB) Get(id string) (*task.Task, error) {
-	var dest *task.Task = new(task.Task)
-
 	row := sdb.db.QueryRowx(
-		`SELECT 
-			ID, 
-            COALESCE(Aggregator, '') AS Aggregator, 
-            COALESCE(Slug, '') AS Slug, 
-            COALESCE(AggregatorPageURL, '') AS AggregatorPageURL, 
-            COALESCE(FilehostUrl, '') AS FilehostUrl, 
-            COALESCE(DisplayName, '') AS DisplayName, 
-            COALESCE(Filename, '') AS Filename, 
-            DownloadState, 
-            COALESCE(Err, '') AS Err
+		`SELECT `+taskColumns+`
 		FROM `+TABLE_NAME+`
 		WHERE ID = ?
 		LIMIT 1`,
@@ -245,49 +512,34 @@ func (sdb *SQLiteDB) Get(id string) (*task.Task, error) {
 		return nil, fmt.Errorf("SQLite: query error: %v", row.Err())
 	}
 
-	var dbErr string
-
-	err := row.Scan(
-		&dest.Id,
-		&dest.Aggregator,
-		&dest.Slug,
-		&dest.AggregatorPageURL,
-		&dest.FilehostUrl,
-		&dest.DisplayName,
-		&dest.Filename,
-		&dest.DownloadState,
-		&dbErr,
-	)
-	if err != nil {
-		return dest, err
-	}
+	return scanTask(row)
+}
 
-	if dbErr == "" {
-		dest.Err = nil
-	} else {
-		dest.Err = fmt.Errorf("%s", dbErr)
-	}
+// Returns the task of an album, or sql.ErrNoRows if the album isn't stored
+func (sdb *SQLiteDB) GetByAlbum(aggregator, albumID string) (*task.Task, error) {
+	return scanTask(sdb.db.QueryRowx(
+		`SELECT `+taskColumns+` FROM `+TABLE_NAME+` WHERE Aggregator = ? AND AlbumID = ? AND AlbumID <> ''`,
+		aggregator,
+		albumID,
+	))
+}
 
-	return dest, nil
+// Returns the task downloading a file, or sql.ErrNoRows if no task points to it
+func (sdb *SQLiteDB) GetByFile(filehost, fileID string) (*task.Task, error) {
+	return scanTask(sdb.db.QueryRowx(
+		`SELECT `+taskColumns+` FROM `+TABLE_NAME+` WHERE Filehost = ? AND FileID = ? AND FileID <> ''`,
+		filehost,
+		fileID,
+	))
 }
 
 // Checks whether a task with an equal value is already present in the database
 func (sdb *SQLiteDB) GetFromState(state int) (*task.Task, error) {
-	var dest *task.Task = new(task.Task)
-
 	row := sdb.db.QueryRowx(
-		`SELECT 
-			ID, 
-            COALESCE(Aggregator, '') AS Aggregator, 
-            COALESCE(Slug, '') AS Slug, 
-            COALESCE(AggregatorPageURL, '') AS AggregatorPageURL, 
-            COALESCE(FilehostUrl, '') AS FilehostUrl, 
-            COALESCE(DisplayName, '') AS DisplayName, 
-            COALESCE(Filename, '') AS Filename, 
-            DownloadState, 
-            COALESCE(Err, '') AS Err
+		`SELECT `+taskColumns+`
 		FROM `+TABLE_NAME+`
 		WHERE DownloadState = ?
+		`+queueOrder+`
 		LIMIT 1`,
 		state,
 	)
@@ -296,147 +548,89 @@ func (sdb *SQLiteDB) GetFromState(state int) (*task.Task, error) {
 		return nil, fmt.Errorf("SQLite: query error: %v", row.Err())
 	}
 
-	var dbErr string
+	return scanTask(row)
+}
 
-	err := row.Scan(
-		&dest.Id,
-		&dest.Aggregator,
-		&dest.Slug,
-		&dest.AggregatorPageURL,
-		&dest.FilehostUrl,
-		&dest.DisplayName,
-		&dest.Filename,
-		&dest.DownloadState,
-		&dbErr,
+// Returns the first queued task that is not waiting for a retry backoff to expire
+//
+// Returns sql.ErrNoRows if no task can be started right now
+func (sdb *SQLiteDB) GetNextQueued(now time.Time) (*task.Task, error) {
+	row := sdb.db.QueryRowx(
+		`SELECT `+taskColumns+`
+		FROM `+TABLE_NAME+`
+		WHERE DownloadState = ? AND COALESCE(NextRetryAt, 0) <= ?
+		`+queueOrder+`
+		LIMIT 1`,
+		states.TASK_STATE_QUEUED,
+		now.UnixMilli(),
 	)
-	if err != nil {
-		return dest, err
-	}
 
-	if dbErr == "" {
-		dest.Err = nil
-	} else {
-		dest.Err = fmt.Errorf("%s", dbErr)
+	if row.Err() != nil {
+		return nil, fmt.Errorf("SQLite: query error: %v", row.Err())
 	}
 
-	return dest, nil
+	return scanTask(row)
 }
 
-// Returns all the tasks in the database
-func (sdb *SQLiteDB) GetAll() ([]*task.Task, error) {
-	dest := make([]*task.Task, 0)
+// Returns when the first queued task waiting for a retry backoff can be started.
+//
+// The returned time is zero if no task is waiting for its backoff to expire
+func (sdb *SQLiteDB) GetNextRetryAt(now time.Time) (time.Time, error) {
+	var next int64
 
-	rows, err := sdb.db.Query(`
-        SELECT 
-            ID, 
-            COALESCE(Aggregator, ''), 
-            COALESCE(Slug, ''), 
-            COALESCE(AggregatorPageURL, ''), 
-            COALESCE(FilehostUrl, ''), 
-            COALESCE(DisplayName, ''), 
-            COALESCE(Filename, ''), 
-            DownloadState, 
-            COALESCE(Err, '')
-        FROM ` + TABLE_NAME,
+	err := sdb.db.Get(
+		&next,
+		`SELECT COALESCE(MIN(NextRetryAt), 0)
+		FROM `+TABLE_NAME+`
+		WHERE DownloadState = ? AND NextRetryAt > ?`,
+		states.TASK_STATE_QUEUED,
+		now.UnixMilli(),
 	)
-	if err != nil {
-		return dest, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		t := task.NewTask("")
-		var dbErr string
-
-		err := rows.Scan(
-			&t.Id,
-			&t.Aggregator,
-			&t.Slug,
-			&t.AggregatorPageURL,
-			&t.FilehostUrl,
-			&t.DisplayName,
-			&t.Filename,
-			&t.DownloadState,
-			&dbErr,
-		)
-		if err != nil {
-			return dest, err
-		}
-
-		if dbErr == "" {
-			t.Err = nil
-		} else {
-			t.Err = fmt.Errorf("%s", dbErr)
-		}
 
-		dest = append(dest, t)
-	}
+	return fromUnixMilli(next), err
+}
 
-	return dest, nil
+// Returns all the tasks in the database
+func (sdb *SQLiteDB) GetAll() ([]*task.Task, error) {
+	return sdb.selectTasks(`SELECT ` + taskColumns + ` FROM ` + TABLE_NAME + ` ` + queueOrder)
 }
 
 // Returns all the tasks in the database with that state
 func (sdb *SQLiteDB) GetAllWithState(state int) ([]*task.Task, error) {
-	dest := make([]*task.Task, 0)
+	if !states.IsValid(state) {
+		return make([]*task.Task, 0), fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
+	}
 
-	if state < 0 || state > states.MaxCompletionState() {
-		return dest, fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
-	}
-
-	rows, err := sdb.db.Queryx(`
-        SELECT 
-            ID, 
-            COALESCE(Aggregator, ''), 
-            COALESCE(Slug, ''), 
-            COALESCE(AggregatorPageURL, ''), 
-            COALESCE(FilehostUrl, ''), 
-            COALESCE(DisplayName, ''), 
-            COALESCE(Filename, ''), 
-            DownloadState, 
-            COALESCE(Err, '')
-        FROM `+TABLE_NAME+`
-		WHERE DownloadState = ?`,
+	return sdb.selectTasks(
+		`SELECT `+taskColumns+`
+		FROM `+TABLE_NAME+`
+		WHERE DownloadState = ?
+		`+queueOrder,
 		state,
 	)
-	if err != nil {
-		return dest, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		t := task.NewTask("")
-		var dbErr string
-
-		err := rows.Scan(
-			&t.Id,
-			&t.Aggregator,
-			&t.Slug,
-			&t.AggregatorPageURL,
-			&t.FilehostUrl,
-			&t.DisplayName,
-			&t.Filename,
-			&t.DownloadState,
-			&dbErr,
-		)
-		if err != nil {
-			return dest, err
-		}
-
-		if dbErr == "" {
-			t.Err = nil
-		} else {
-			t.Err = fmt.Errorf("%s", dbErr)
-		}
+}
 
-		dest = append(dest, t)
+// Returns all the tasks in the database that won't be processed anymore
+func (sdb *SQLiteDB) GetEnded() ([]*task.Task, error) {
+	query, args, err := sqlx.In(
+		`SELECT `+taskColumns+`
+		FROM `+TABLE_NAME+`
+		WHERE DownloadState IN (?)`,
+		states.EndedStates(),
+	)
+	if err != nil {
+		return make([]*task.Task, 0), err
 	}
 
-	return dest, nil
+	return sdb.selectTasks(query, args...)
 }
 
+// Saves a task, in the same statement checking that its stored state can move to the new one
 func (sdb *SQLiteDB) Update(t *task.Task) error {
-	s, err := sdb.db.Prepare(`
-		UPDATE ` + TABLE_NAME + `
+	dbErr, dbErrCategory := errToColumns(t.Err)
+
+	query, args, err := sqlx.In(`
+		UPDATE `+TABLE_NAME+`
 		SET
 			Slug = ?,
 			AggregatorPageURL = ?,
@@ -444,21 +638,18 @@ func (sdb *SQLiteDB) Update(t *task.Task) error {
 			DisplayName = ?,
 			Filename = ?,
 			DownloadState = ?,
-			Err = ?
+			Err = ?,
+			ErrCategory = ?,
+			Attempts = ?,
+			NextRetryAt = ?,
+			StartedAt = ?,
+			FinishedAt = ?,
+			AlbumID = ?,
+			Filehost = ?,
+			FileID = ?
 		WHERE
-			ID = ?
-	`)
-	if err != nil {
-		return err
-	}
-	defer s.Close()
-
-	dbErr := ""
-	if t.Err != nil {
-		dbErr = t.Err.Error()
-	}
-
-	_, err = s.Exec(
+			ID = ? AND DownloadState IN (?)
+	`,
 		t.Slug,
 		t.AggregatorPageURL,
 		t.FilehostUrl,
@@ -466,90 +657,104 @@ func (sdb *SQLiteDB) Update(t *task.Task) error {
 		t.Filename,
 		t.DownloadState,
 		dbErr,
+		dbErrCategory,
+		t.Attempts,
+		toUnixMilli(t.NextRetryAt),
+		toUnixMilli(t.StartedAt),
+		toUnixMilli(t.FinishedAt),
+		t.AlbumID,
+		t.Filehost,
+		t.FileID,
 		t.Id,
+		states.AllowedFrom(t.DownloadState),
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	res, err := sdb.db.Exec(query, args...)
+	if err != nil {
+		return duplicateErr(err)
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sdb.transitionConflict(t.Id, t.DownloadState)
+	}
+
+	if err := sdb.indexTask(t.Id); err != nil {
+		return err
+	}
+
+	if t.DownloadState == states.TASK_STATE_QUEUED {
+		sdb.notifyQueue()
+	}
+
+	return nil
 }
 
-// Removes a task from the database
-//
-// Returns an error if trying to remove a task in a running state
+// Moves a task to the trash
 func (sdb *SQLiteDB) Remove(t *task.Task) error {
-	_, err := sdb.db.Exec(`DELETE FROM `+TABLE_NAME+` WHERE id = ?`, t.Id)
+	_, err := sdb.trash(`ID = ?`, t.Id)
 
 	return err
 }
 
-// Removes a task from the database
-//
-// Returns an error if trying to remove a task in a running state
+// Moves a task to the trash
 func (sdb *SQLiteDB) RemoveFromID(id string) error {
-	_, err := sdb.db.Exec(`DELETE FROM `+TABLE_NAME+` WHERE ID = ?`, id)
+	_, err := sdb.trash(`ID = ?`, id)
 
 	return err
 }
 
-// Removes multiple tasks with the same state from the database
-//
-// Returns the number of affected tasks and. If -1, then the state is out of range
+// Moves multiple tasks with the same state to the trash, as a single batch.
+// Returns the number of affected tasks
 //
-// Also returns an error if something goes wrong while handling the database
+// Also returns an error if the state is out of range or something goes wrong while handling the database
 func (sdb *SQLiteDB) RemoveFromState(state int) (int, error) {
-	if state < 0 || state > states.MaxCompletionState() {
+	if !states.IsValid(state) {
 		return 0, fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
 	}
 
-	res, err := sdb.db.Exec(`DELETE FROM `+TABLE_NAME+` WHERE DownloadState = ?`, state)
-	if err != nil {
-		return 0, err
-	}
-
-	count, err := res.RowsAffected()
-
-	return int(count), err
+	return sdb.trash(`DownloadState = ?`, state)
 }
 
-func (sdb *SQLiteDB) RemoveCompletedNoErr() (int, error) {
-	res, err := sdb.db.Exec(
-		`DELETE FROM `+TABLE_NAME+` WHERE DownloadState = ? AND (Err = '' OR Err IS NULL)`,
-		states.TASK_STATE_COMPLETED,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	count, err := res.RowsAffected()
-
-	return int(count), err
+// Moves the failed tasks whose error falls in the given category to the trash
+func (sdb *SQLiteDB) RemoveFailedWithErrCategory(c dsdlerr.Category) (int, error) {
+	return sdb.trash(`DownloadState = ? AND ErrCategory = ?`, states.TASK_STATE_FAILED, c)
 }
 
-func (sdb *SQLiteDB) RemoveCompletedWithErr() (int, error) {
-	res, err := sdb.db.Exec(
-		`DELETE FROM `+TABLE_NAME+` WHERE DownloadState = ? AND Err IS NOT NULL`,
-		states.TASK_STATE_COMPLETED,
-	)
+// Moves every task that won't be processed anymore to the trash
+//
+// Returns the number of removed tasks
+func (sdb *SQLiteDB) RemoveEnded() (int, error) {
+	where, args, err := sqlx.In(`DownloadState IN (?)`, states.EndedStates())
 	if err != nil {
 		return 0, err
 	}
 
-	count, err := res.RowsAffected()
-
-	return int(count), err
+	return sdb.trash(where, args...)
 }
 
-// Empties the database
+// Empties the database, bypassing the trash
 func (sdb *SQLiteDB) RemoveAll() error {
 	_, err := sdb.db.Exec(`DELETE FROM ` + TABLE_NAME)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return sdb.pruneIndex()
 }
 
 // Resets the state of EVERY task in the specified completion state
 //
 // Returns the affected records count and an error either if the completion state is invalid or if trying to reset tunning tasks
 func (sdb *SQLiteDB) ResetFromCompletionState(state int) (int, error) {
-	if state < 0 || state > states.MaxCompletionState() {
+	if !states.IsValid(state) {
 		return 0, fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
 	}
 
@@ -558,7 +763,9 @@ func (sdb *SQLiteDB) ResetFromCompletionState(state int) (int, error) {
 	}
 
 	res, err := sdb.db.Exec(
-		`UPDATE `+TABLE_NAME+` SET DownloadState = ?, Err = "" WHERE DownloadState = ?`,
+		`UPDATE `+TABLE_NAME+`
+		SET DownloadState = ?, Err = '', ErrCategory = '', Attempts = 0, NextRetryAt = 0, AcknowledgedAt = 0
+		WHERE DownloadState = ?`,
 		states.TASK_STATE_QUEUED,
 		state,
 	)
@@ -573,6 +780,10 @@ func (sdb *SQLiteDB) ResetFromCompletionState(state int) (int, error) {
 
 	count := int(count64)
 
+	if count != 0 {
+		sdb.notifyQueue()
+	}
+
 	return count, nil
 }
 
@@ -580,22 +791,60 @@ func (sdb *SQLiteDB) ResetFromCompletionState(state int) (int, error) {
 func (sdb *SQLiteDB) getStateInt(t *task.Task) (int, error) {
 	var stateID int
 
-	rows, err := sdb.db.Query(`SELECT DownloadState FROM `+TABLE_NAME+` WHERE ID = ?`, t.Id)
+	err := sdb.db.Get(&stateID, `SELECT DownloadState FROM `+TABLE_NAME+` WHERE ID = ?`, t.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stateID, fmt.Errorf("DB: Task not found: %s", t.Id)
+	}
+
+	return stateID, err
+}
+
+/*
+Explains why an update guarded by the states allowed to move to the given one didn't
+change anything: its stored state forbids the transition.
+
+Tasks missing from the database are not an error, as there was nothing to update
+*/
+func (sdb *SQLiteDB) transitionConflict(id string, to int) error {
+	var from int
+
+	err := sdb.db.Get(&from, `SELECT DownloadState FROM `+TABLE_NAME+` WHERE ID = ?`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
 	if err != nil {
-		return stateID, err
+		return err
 	}
-	defer rows.Close()
 
-	if rows.Next() {
-		err = rows.Scan(&stateID)
-		if err != nil {
-			return stateID, err
-		}
-	} else {
-		return stateID, rows.Err()
+	return transitionErr(from, to)
+}
+
+// Returns an error if a state update guarded by the previous state didn't change anything,
+// meaning that the task moved to another state in the meantime
+func checkStateUpdate(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("DB: The task state changed in the meantime, try again")
 	}
 
-	return stateID, nil
+	return nil
+}
+
+func transitionErr(from, to int) error {
+	return fmt.Errorf(
+		"%w: %s -> %s",
+		ErrIllegalTransition,
+		states.GetStateStr(from),
+		states.GetStateStr(to),
+	)
 }
 
 // Returns the stringified state of a specific task. Returns an error if the task has not been found
@@ -610,111 +859,189 @@ func (sdb *SQLiteDB) GetState(t *task.Task) (string, error) {
 	return state, nil
 }
 
-// Sets the state of a specific task. Returns an error if the task has not been found
+// Sets the state of a specific task. Returns an error if the task has not been found or if the
+// task cannot move to the new state
 func (sdb *SQLiteDB) SetState(t *task.Task, newState int) error {
-	if newState < 0 || newState > states.MaxCompletionState() {
+	if !states.IsValid(newState) {
 		return fmt.Errorf(ERR_STATE_OUTSIDE_CONSTRAINTS)
 	}
 
-	dbErr := ""
-	if t.Err != nil {
-		dbErr = t.Err.Error()
+	state, err := sdb.getStateInt(t)
+	if err != nil {
+		return err
+	}
+
+	if !states.CanTransition(state, newState) {
+		return transitionErr(state, newState)
 	}
 
-	_, err := sdb.db.Exec(
-		`UPDATE `+TABLE_NAME+` SET DownloadState = ?, Err = ? WHERE ID = ?`,
+	dbErr, dbErrCategory := errToColumns(t.Err)
+
+	res, err := sdb.db.Exec(
+		`UPDATE `+TABLE_NAME+`
+		SET DownloadState = ?, Err = ?, ErrCategory = ?
+		WHERE ID = ? AND DownloadState = ?`,
 		newState,
 		dbErr,
+		dbErrCategory,
 		t.ID(),
+		state,
 	)
+	if err := checkStateUpdate(res, err); err != nil {
+		return err
+	}
 
-	return err
+	t.DownloadState = newState
+
+	if newState == states.TASK_STATE_QUEUED {
+		sdb.notifyQueue()
+	}
+
+	return nil
 }
 
-// Advances the completion state of a specific task
+// Advances the state of a specific task along the Queued -> Running -> Completed/Failed path.
+// A running task with an error becomes failed
 //
-// Returns an error if the task has reached a completion state and the updated state value
+// Returns an error if the task has reached an ended state and the updated state value
 func (sdb *SQLiteDB) AdvanceState(t *task.Task) (int, error) {
 	state, err := sdb.getStateInt(t)
 	if err != nil {
 		return 0, err
 	}
 
-	if state >= states.MaxCompletionState() {
+	var next int
+
+	switch state {
+	case states.TASK_STATE_QUEUED:
+		next = states.TASK_STATE_RUNNING
+	case states.TASK_STATE_RUNNING:
+		next = states.TASK_STATE_COMPLETED
+		if t.Err != nil {
+			next = states.TASK_STATE_FAILED
+		}
+	default:
 		return state, fmt.Errorf("Cannot advance the status of this task anymore")
 	}
 
-	state = state + 1
+	dbErr, dbErrCategory := errToColumns(t.Err)
 
-	dbErr := ""
-	if t.Err != nil {
-		dbErr = t.Err.Error()
-	}
-
-	_, err = sdb.db.Exec(
-		`UPDATE `+TABLE_NAME+` SET DownloadState = ?, Err = ? WHERE ID = ?`,
-		state,
+	res, err := sdb.db.Exec(
+		`UPDATE `+TABLE_NAME+`
+		SET DownloadState = ?, Err = ?, ErrCategory = ?
+		WHERE ID = ? AND DownloadState = ?`,
+		next,
 		dbErr,
+		dbErrCategory,
 		t.Id,
+		state,
 	)
-	if err != nil {
+	if err := checkStateUpdate(res, err); err != nil {
 		return state, err
 	}
 
-	t.DownloadState = state
+	t.DownloadState = next
 
-	return state, nil
+	return next, nil
 }
 
-// Regresses the completion state of a specific task
+// Regresses the state of a specific task back to the queued state, clearing its error
 //
-// Returns an error if the task has reached a queued state and the updated state value
+// Returns an error if the task is already queued and the updated state value
 func (sdb *SQLiteDB) RegressState(t *task.Task) (int, error) {
 	state, err := sdb.getStateInt(t)
 	if err != nil {
 		return 0, err
 	}
 
-	if state <= 0 {
+	if state == states.TASK_STATE_QUEUED {
 		return state, fmt.Errorf("Cannot regress the status of this task anymore")
 	}
 
-	state = state - 1
+	if !states.CanTransition(state, states.TASK_STATE_QUEUED) {
+		return state, transitionErr(state, states.TASK_STATE_QUEUED)
+	}
 
-	_, err = sdb.db.Exec(
-		`UPDATE `+TABLE_NAME+` SET DownloadState = ?, Err = ? WHERE ID = ?`,
-		state,
-		"",
+	res, err := sdb.db.Exec(
+		`UPDATE `+TABLE_NAME+`
+		SET DownloadState = ?, Err = '', ErrCategory = '', AcknowledgedAt = 0
+		WHERE ID = ? AND DownloadState = ?`,
+		states.TASK_STATE_QUEUED,
 		t.Id,
+		state,
 	)
-	if err != nil {
-		return 0, err
+	if err := checkStateUpdate(res, err); err != nil {
+		return state, err
 	}
 
-	t.DownloadState = state
+	t.DownloadState = states.TASK_STATE_QUEUED
+	t.Err = nil
+	t.AcknowledgedAt = time.Time{}
 
-	return state, nil
+	sdb.notifyQueue()
+
+	return states.TASK_STATE_QUEUED, nil
 }
 
 // Resets the state of a specific task to a queued state
 //
-// Returns an error if trying to reset the state of a task in a running state and the updated state value
+// Returns the updated state value, ErrResetRunning if the task is running, even if t is a
+// stale copy, or sql.ErrNoRows if it doesn't exist
 func (sdb *SQLiteDB) ResetState(t *task.Task) (int, error) {
 	if t.DownloadState == states.TASK_STATE_RUNNING {
-		return -1, fmt.Errorf("Cannot reset a running task")
+		return -1, ErrResetRunning
 	}
 
-	_, err := sdb.db.Exec(
-		`UPDATE `+TABLE_NAME+` SET DownloadState = ?, Err = ? WHERE ID = ?`,
+	query, args, err := sqlx.In(
+		`UPDATE `+TABLE_NAME+`
+		SET
+			DownloadState = ?, Err = '', ErrCategory = '', Attempts = 0, NextRetryAt = 0,
+			StartedAt = 0, FinishedAt = 0, AcknowledgedAt = 0
+		WHERE ID = ? AND DownloadState IN (?)`,
 		states.TASK_STATE_QUEUED,
-		"",
 		t.Id,
+		resettableStates(),
 	)
+	if err != nil {
+		return -1, err
+	}
+
+	res, err := sdb.db.Exec(query, args...)
+	if err != nil {
+		return -1, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	if count == 0 {
+		var from int
+
+		err := sdb.db.Get(&from, `SELECT DownloadState FROM `+TABLE_NAME+` WHERE ID = ?`, t.Id)
+		if err != nil {
+			return -1, err
+		}
+
+		if from == states.TASK_STATE_RUNNING {
+			return -1, ErrResetRunning
+		}
+
+		return -1, transitionErr(from, states.TASK_STATE_QUEUED)
+	}
 
 	t.DownloadState = states.TASK_STATE_QUEUED
 	t.Err = nil
+	t.Attempts = 0
+	t.NextRetryAt = time.Time{}
+	t.StartedAt = time.Time{}
+	t.FinishedAt = time.Time{}
+	t.AcknowledgedAt = time.Time{}
+
+	sdb.notifyQueue()
 
-	return states.TASK_STATE_QUEUED, err
+	return states.TASK_STATE_QUEUED, nil
 }
 
 // Drops specified table name
@@ -1,10 +1,15 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
 	"github.com/relepega/doujinstyle-downloader/internal/task"
 )
 
@@ -58,7 +63,7 @@ func TestInsertAndCount(t *testing.T) {
 		t.Fatal("Expected count: 3, returned:", count)
 	}
 
-	err = db.Drop("*")
+	err = db.Drop()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,7 +106,7 @@ func TestGet(t *testing.T) {
 
 	partialSlug := "ite"
 
-	tsk, err := db.Get(partialSlug)
+	tsk, err := db.Get(t1.Id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -122,7 +127,7 @@ func TestGet(t *testing.T) {
 		t.Fatalf("IDs mismatch: wanted: %v, got %v", tasks[2].Id, t1.Id)
 	}
 
-	err = db.Drop("*")
+	err = db.Drop()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -207,7 +212,7 @@ func TestDelete(t *testing.T) {
 		t.Fatalf("DB:RemoveAll: Expected 0 records left, got %d", count)
 	}
 
-	err = db.Drop("*")
+	err = db.Drop()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -247,12 +252,27 @@ func TestStateManipulation1(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	err = db.SetState(t2, states.TASK_STATE_COMPLETED)
+	if err == nil {
+		t.Fatal("DB:SetState: A queued task has been completed without running")
+	}
+
+	err = db.SetState(t2, states.TASK_STATE_RUNNING)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	err = db.SetState(t2, states.TASK_STATE_COMPLETED)
 	if err != nil {
 		t.Fatal(err)
 	}
 	t2.DownloadState = states.TASK_STATE_COMPLETED
 
+	err = db.SetState(t2, states.TASK_STATE_RUNNING)
+	if err == nil {
+		t.Fatal("DB:SetState: A completed task has been moved back to running")
+	}
+
 	state, err := db.GetState(t2)
 	if state != states.GetStateStr(t2.DownloadState) {
 		t.Fatalf(
@@ -271,7 +291,7 @@ func TestStateManipulation1(t *testing.T) {
 		t.Fatalf("DB:ResetFromCompletionState: Expected 2 rows affected, got %d", count)
 	}
 
-	err = db.Drop("*")
+	err = db.Drop()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -332,7 +352,417 @@ func TestStateManipulation2(t *testing.T) {
 		t.Fatalf("Expected state %d, got %d", t1.DownloadState, state)
 	}
 
-	err = db.Drop("*")
+	err = db.Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateTransitions(t *testing.T) {
+	forEachStore(t, testUpdateTransitions)
+}
+
+func testUpdateTransitions(t *testing.T, db taskStore) {
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tsk := task.NewTask("aborted")
+	if _, err := db.Insert(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	// the task is aborted while its runner is still working on its own copy
+	aborted, err := db.Get(tsk.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aborted.DownloadState = states.TASK_STATE_CANCELED
+
+	if err := db.Update(aborted); err != nil {
+		t.Fatal(err)
+	}
+
+	tsk.DownloadState = states.TASK_STATE_COMPLETED
+
+	if err := db.Update(tsk); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("Update: Expected ErrIllegalTransition for Canceled -> Completed, got %v", err)
+	}
+
+	stored, err := db.Get(tsk.Id)
+	if err != nil || stored.DownloadState != states.TASK_STATE_CANCELED {
+		t.Fatalf("Update: Expected the refused update to leave the task canceled, got %+v (%v)", stored, err)
+	}
+
+	// there's nothing to update once the task is gone
+	missing := task.NewTask("missing")
+	missing.DownloadState = states.TASK_STATE_COMPLETED
+
+	if err := db.Update(missing); err != nil {
+		t.Errorf("Update: Expected no error for a missing task, got %v", err)
+	}
+
+	if _, err := db.ResetState(missing); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ResetState: Expected sql.ErrNoRows for a missing task, got %v", err)
+	}
+
+	// a task read as failed, then started again while the copy was around
+	stale, err := db.Get(tsk.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.DownloadState = states.TASK_STATE_FAILED
+
+	if _, err := db.ResetState(tsk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AdvanceState(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ResetState(stale); !errors.Is(err, ErrResetRunning) {
+		t.Errorf("ResetState: Expected ErrResetRunning for a stale copy of a running task, got %v", err)
+	}
+
+	stored, err = db.Get(tsk.Id)
+	if err != nil || stored.DownloadState != states.TASK_STATE_RUNNING {
+		t.Fatalf("ResetState: Expected the running task to be left alone, got %+v (%v)", stored, err)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	db := NewSQLite(true)
+
+	err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	t1 := task.NewTask("hello")
+	_, err = db.Insert(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := db.GetJournal(t1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Fatal("DB:GetJournal: Expected no journal for a new task")
+	}
+
+	err = db.SaveJournal(&task.Journal{
+		TaskID:       t1.Id,
+		TempFilepath: "/tmp/" + t1.Id + "/file.part",
+		BytesWritten: 1024,
+		ETag:         `"v1"`,
+		FilehostUrl:  "https://www.mediafire.com/file/abc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SaveJournal(&task.Journal{
+		TaskID:       "removed-task",
+		TempFilepath: "/tmp/removed-task/file.part",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = db.GetJournal(t1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.BytesWritten != 1024 || j.ETag != `"v1"` {
+		t.Fatalf("DB:GetJournal: Unexpected journal: %+v", j)
+	}
+
+	journals, err := db.GetAllJournals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journals) != 1 {
+		t.Fatalf("DB:GetAllJournals: Expected 1 journal, got %d", len(journals))
+	}
+
+	orphans, err := db.RemoveOrphanJournals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].TaskID != "removed-task" {
+		t.Fatalf("DB:RemoveOrphanJournals: Expected the journal of the removed task, got %+v", orphans)
+	}
+
+	err = db.Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	db := NewSQLite(true)
+
+	err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	t1 := task.NewTask("hello")
+	t1.Attempts = 1
+	t1.NextRetryAt = time.Now().Add(time.Hour)
+
+	_, err = db.Insert(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.GetNextQueued(time.Now())
+	if err == nil {
+		t.Fatal("DB:GetNextQueued: A task waiting for its backoff has been dequeued")
+	}
+
+	next, err := db.GetNextQueued(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Id != t1.Id || next.Attempts != 1 {
+		t.Fatalf("DB:GetNextQueued: Unexpected task: %+v", next)
+	}
+
+	id1, err := db.StartAttempt(t1.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.EndAttempt(id1, fmt.Errorf("timeout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id2, err := db.StartAttempt(t1.Id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.EndAttempt(id2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := db.GetAttempts(t1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("DB:GetAttempts: Expected 2 attempts, got %d", len(attempts))
+	}
+	if attempts[0].Err != "timeout" || attempts[1].Err != "" || attempts[1].EndedAt.IsZero() {
+		t.Fatalf("DB:GetAttempts: Unexpected attempts: %+v %+v", attempts[0], attempts[1])
+	}
+
+	_, err = db.ResetState(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t1, err = db.Get(t1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if t1.Attempts != 0 || !t1.NextRetryAt.IsZero() {
+		t.Fatalf("DB:ResetState: Attempts have not been reset: %+v", t1)
+	}
+
+	err = db.Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrCategory(t *testing.T) {
+	db := NewSQLite(true)
+
+	err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	t1 := task.NewTask("hello")
+	t1.DownloadState = states.TASK_STATE_FAILED
+	t1.Err = dsdlerr.New(dsdlerr.QuotaExceeded, "out of quota")
+
+	t2 := task.NewTask("world")
+	t2.DownloadState = states.TASK_STATE_FAILED
+	t2.Err = dsdlerr.New(dsdlerr.NotFound, "taken down")
+
+	for _, tsk := range []*task.Task{t1, t2} {
+		_, err = db.Insert(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := db.Get(t1.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ErrCategory() != dsdlerr.QuotaExceeded || got.Err.Error() != "out of quota" {
+		t.Fatalf("DB:Get: Error category has not been restored: %v (%v)", got.Err, got.ErrCategory())
+	}
+
+	count, err := db.RemoveFailedWithErrCategory(dsdlerr.NotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("DB:RemoveFailedWithErrCategory: Expected 1 removed task, got %d", count)
+	}
+
+	if _, err := db.Get(t2.Id); err == nil {
+		t.Fatal("DB:RemoveFailedWithErrCategory: Task has not been removed")
+	}
+
+	err = db.Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	db := NewSQLite(true)
+
+	err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ids := make([]string, 0)
+
+	for _, slug := range []string{"first", "second", "third", "fourth"} {
+		id, err := db.Insert(task.NewTask(slug))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	order := func() string {
+		tasks, err := db.GetAllWithState(states.TASK_STATE_QUEUED)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slugs := make([]string, 0, len(tasks))
+		for _, tsk := range tasks {
+			slugs = append(slugs, tsk.Slug)
+		}
+
+		return strings.Join(slugs, " ")
+	}
+
+	if got := order(); got != "first second third fourth" {
+		t.Fatalf("DB: Expected FIFO order, got %q", got)
+	}
+
+	err = db.MoveToTop(ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.MoveToBottom(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.MoveBefore(ids[3], ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := order(); got != "third fourth second first" {
+		t.Fatalf("DB: Unexpected order after moving tasks: %q", got)
+	}
+
+	err = db.SetPriority(ids[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := db.GetNextQueued(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Id != ids[0] {
+		t.Fatalf("DB:GetNextQueued: Expected the highest priority task, got %q", next.Slug)
+	}
+
+	err = db.Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueNotifications(t *testing.T) {
+	db := NewSQLite(true)
+
+	err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	notified := 0
+	db.OnQueueChange(func() { notified++ })
+
+	t1 := task.NewTask("hello")
+
+	_, err = db.Insert(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 1 {
+		t.Fatalf("DB:Insert: Expected 1 notification, got %d", notified)
+	}
+
+	_, err = db.AdvanceState(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 1 {
+		t.Fatalf("DB:AdvanceState: Starting a task should not notify the queue")
+	}
+
+	// failed attempt waiting for a retry
+	retryAt := time.Now().Add(time.Minute)
+
+	t1.DownloadState = states.TASK_STATE_QUEUED
+	t1.NextRetryAt = retryAt
+
+	err = db.Update(t1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 2 {
+		t.Fatalf("DB:Update: Expected 2 notifications, got %d", notified)
+	}
+
+	next, err := db.GetNextRetryAt(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.UnixMilli() != retryAt.UnixMilli() {
+		t.Fatalf("DB:GetNextRetryAt: Expected %v, got %v", retryAt, next)
+	}
+
+	err = db.Drop()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1,10 +1,23 @@
 package states
 
+import "strings"
+
 // Emun of completion states. Used to track a task's state.
+//
+// The values are stored in the database, so new states must only be appended
 const (
 	TASK_STATE_QUEUED int = iota
 	TASK_STATE_RUNNING
+	// the task has been downloaded successfully
 	TASK_STATE_COMPLETED
+	// the task ended with an error and it won't be retried anymore
+	TASK_STATE_FAILED
+	// the task has been aborted by the user
+	TASK_STATE_CANCELED
+	// the task is waiting in the queue, but it won't be dequeued until resumed
+	TASK_STATE_PAUSED
+	// the task didn't need to be downloaded, e.g. because it is a duplicate
+	TASK_STATE_SKIPPED
 	max_completion_state
 )
 
@@ -19,14 +32,127 @@ const (
 	TASK_STATE_QUEUED_STR    = "Queued"
 	TASK_STATE_RUNNING_STR   = "Running"
 	TASK_STATE_COMPLETED_STR = "Completed"
+	TASK_STATE_FAILED_STR    = "Failed"
+	TASK_STATE_CANCELED_STR  = "Canceled"
+	TASK_STATE_PAUSED_STR    = "Paused"
+	TASK_STATE_SKIPPED_STR   = "Skipped"
 )
 
 var statesMap = map[int]string{
 	TASK_STATE_QUEUED:    TASK_STATE_QUEUED_STR,
 	TASK_STATE_RUNNING:   TASK_STATE_RUNNING_STR,
 	TASK_STATE_COMPLETED: TASK_STATE_COMPLETED_STR,
+	TASK_STATE_FAILED:    TASK_STATE_FAILED_STR,
+	TASK_STATE_CANCELED:  TASK_STATE_CANCELED_STR,
+	TASK_STATE_PAUSED:    TASK_STATE_PAUSED_STR,
+	TASK_STATE_SKIPPED:   TASK_STATE_SKIPPED_STR,
 }
 
 func GetStateStr(state int) string {
 	return statesMap[state]
 }
+
+// Returns the state named s, ignoring the case. The second value is false if no state has that name
+func FromString(s string) (int, bool) {
+	for state, name := range statesMap {
+		if strings.EqualFold(name, s) {
+			return state, true
+		}
+	}
+
+	return -1, false
+}
+
+// Returns whether state is a known state
+func IsValid(state int) bool {
+	return state >= 0 && state <= MaxCompletionState()
+}
+
+// Legal moves between states. Any move not listed here is rejected
+var transitions = map[int][]int{
+	TASK_STATE_QUEUED: {
+		TASK_STATE_RUNNING,
+		TASK_STATE_CANCELED,
+		TASK_STATE_PAUSED,
+		TASK_STATE_SKIPPED,
+	},
+	TASK_STATE_RUNNING: {
+		// retries and server shutdowns put the task back in the queue
+		TASK_STATE_QUEUED,
+		TASK_STATE_COMPLETED,
+		TASK_STATE_FAILED,
+		TASK_STATE_CANCELED,
+		TASK_STATE_PAUSED,
+		TASK_STATE_SKIPPED,
+	},
+	TASK_STATE_PAUSED: {
+		TASK_STATE_QUEUED,
+		TASK_STATE_CANCELED,
+	},
+	TASK_STATE_COMPLETED: {TASK_STATE_QUEUED},
+	TASK_STATE_FAILED:    {TASK_STATE_QUEUED},
+	TASK_STATE_CANCELED:  {TASK_STATE_QUEUED},
+	TASK_STATE_SKIPPED:   {TASK_STATE_QUEUED},
+}
+
+// Returns whether a task can move from a state to another one.
+//
+// Staying in the same state is always allowed
+func CanTransition(from, to int) bool {
+	if !IsValid(from) || !IsValid(to) {
+		return false
+	}
+
+	if from == to {
+		return true
+	}
+
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns every state a task can move to the given state from, the state itself included
+func AllowedFrom(to int) []int {
+	from := make([]int, 0)
+
+	for _, s := range []int{
+		TASK_STATE_QUEUED,
+		TASK_STATE_RUNNING,
+		TASK_STATE_COMPLETED,
+		TASK_STATE_FAILED,
+		TASK_STATE_CANCELED,
+		TASK_STATE_PAUSED,
+		TASK_STATE_SKIPPED,
+	} {
+		if CanTransition(s, to) {
+			from = append(from, s)
+		}
+	}
+
+	return from
+}
+
+// Returns whether the task won't be processed anymore, unless reset by the user
+func IsEnded(state int) bool {
+	switch state {
+	case TASK_STATE_COMPLETED, TASK_STATE_FAILED, TASK_STATE_CANCELED, TASK_STATE_SKIPPED:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns every state in which a task is considered ended
+func EndedStates() []int {
+	return []int{
+		TASK_STATE_COMPLETED,
+		TASK_STATE_FAILED,
+		TASK_STATE_CANCELED,
+		TASK_STATE_SKIPPED,
+	}
+}
@@ -0,0 +1,301 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Removed tasks, kept until they are restored or the trash is purged
+const TRASH_TABLE_NAME string = "dsdl_trash"
+
+// Columns a trashed task is restored from: a task removed while running is queued again
+var restoredColumns = fmt.Sprintf(`
+	ID, Aggregator, Slug, AggregatorPageURL, FilehostUrl, DisplayName, Filename,
+	CASE DownloadState WHEN %d THEN %d ELSE DownloadState END,
+	Err, ErrCategory, Attempts, NextRetryAt, Priority, Position, CreatedAt, StartedAt, FinishedAt,
+	Tags, AlbumID, Filehost, FileID, AcknowledgedAt`,
+	states.TASK_STATE_RUNNING,
+	states.TASK_STATE_QUEUED,
+)
+
+// The tasks moved to the trash by the same removal, restored together by an undo
+type TrashBatch struct {
+	ID        string
+	Count     int
+	DeletedAt time.Time
+}
+
+// Returns the ID of a new batch. IDs sort in creation order, even within the same millisecond
+func newTrashBatch() string {
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), appUtils.GenerateRandomFilename())
+}
+
+// Moves the tasks matching the condition to the trash as a single batch, returning how many have been moved
+func (sdb *SQLiteDB) trash(where string, args ...any) (int, error) {
+	tx, err := sdb.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO `+TRASH_TABLE_NAME+` (`+storedColumns+`, DeletedAt, Batch)
+		SELECT `+storedColumns+`, ?, ? FROM `+TABLE_NAME+` WHERE `+where,
+		append([]any{time.Now().UnixMilli(), newTrashBatch()}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM `+TABLE_NAME+` WHERE `+where, args...); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(count), sdb.pruneIndex()
+}
+
+// Moves the tasks to the trash as a single batch, returning how many have been found
+func (sdb *SQLiteDB) RemoveFromIDs(ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	where, args, err := sqlx.In(`ID IN (?)`, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	return sdb.trash(where, args...)
+}
+
+// Returns the batch of the latest removal, or sql.ErrNoRows if the trash is empty
+func (sdb *SQLiteDB) LastTrashBatch() (*TrashBatch, error) {
+	var (
+		b         TrashBatch
+		deletedAt int64
+	)
+
+	err := sdb.db.QueryRow(
+		`SELECT Batch, COUNT(*), MAX(DeletedAt)
+		FROM `+TRASH_TABLE_NAME+`
+		GROUP BY Batch
+		ORDER BY Batch DESC
+		LIMIT 1`,
+	).Scan(&b.ID, &b.Count, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	b.DeletedAt = fromUnixMilli(deletedAt)
+
+	return &b, nil
+}
+
+/*
+Moves the tasks of a batch back where they were removed from.
+
+The tasks whose album has been queued again in the meantime stay in the trash.
+Returns the number of restored tasks
+*/
+func (sdb *SQLiteDB) RestoreBatch(batch string) (int, error) {
+	return sdb.restore(`Batch = ?`, batch)
+}
+
+/*
+Moves a trashed task back where it was removed from.
+
+Returns sql.ErrNoRows if the task isn't in the trash, and ErrDuplicate if its
+album has been queued again in the meantime
+*/
+func (sdb *SQLiteDB) RestoreTrashed(id string) (*task.Task, error) {
+	if _, err := sdb.GetTrashed(id); err != nil {
+		return nil, err
+	}
+
+	count, err := sdb.restore(`ID = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, ErrDuplicate
+	}
+
+	return sdb.Get(id)
+}
+
+// Moves the trashed tasks matching the condition back to the tasks table, skipping the duplicates
+func (sdb *SQLiteDB) restore(where string, args ...any) (int, error) {
+	tx, err := sdb.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT OR IGNORE INTO `+TABLE_NAME+` (`+storedColumns+`)
+		SELECT `+restoredColumns+` FROM `+TRASH_TABLE_NAME+` WHERE `+where,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var restored []string
+
+	err = tx.Select(
+		&restored,
+		`SELECT ID FROM `+TRASH_TABLE_NAME+` WHERE (`+where+`) AND ID IN (SELECT ID FROM `+TABLE_NAME+`)`,
+		args...,
+	)
+	if err != nil || len(restored) == 0 {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM `+TRASH_TABLE_NAME+` WHERE (`+where+`) AND ID IN (SELECT ID FROM `+TABLE_NAME+`)`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range restored {
+		if err := sdb.indexTask(id); err != nil {
+			return 0, err
+		}
+	}
+
+	sdb.notifyQueue()
+
+	return len(restored), nil
+}
+
+// Returns a trashed task, or sql.ErrNoRows if it isn't in the trash
+func (sdb *SQLiteDB) GetTrashed(id string) (*task.Task, error) {
+	var deletedAt int64
+
+	t, err := scanTask(
+		sdb.db.QueryRowx(
+			`SELECT `+taskColumns+`, DeletedAt FROM `+TRASH_TABLE_NAME+` WHERE ID = ?`,
+			id,
+		),
+		&deletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	t.DeletedAt = fromUnixMilli(deletedAt)
+
+	return t, nil
+}
+
+// Returns the tasks trashed by a removal, an empty slice if none of them is still in the trash
+func (sdb *SQLiteDB) GetTrashBatch(batch string) ([]*task.Task, error) {
+	rows, err := sdb.db.Queryx(
+		`SELECT `+taskColumns+`, DeletedAt FROM `+TRASH_TABLE_NAME+` WHERE Batch = ? ORDER BY ID`,
+		batch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dest := make([]*task.Task, 0)
+
+	for rows.Next() {
+		var deletedAt int64
+
+		t, err := scanTask(rows, &deletedAt)
+		if err != nil {
+			return dest, err
+		}
+
+		t.DeletedAt = fromUnixMilli(deletedAt)
+		dest = append(dest, t)
+	}
+
+	return dest, rows.Err()
+}
+
+/*
+Returns up to limit trashed tasks matching the search, every match if limit is 0,
+the most recently removed first. An empty search matches every trashed task
+*/
+func (sdb *SQLiteDB) SearchTrash(text string, limit int) ([]*task.Task, error) {
+	var terms []searchTerm
+
+	if strings.TrimSpace(text) != "" {
+		var err error
+
+		terms, err = parseSearch(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := sdb.db.Queryx(
+		`SELECT ` + taskColumns + `, DeletedAt
+		FROM ` + TRASH_TABLE_NAME + `
+		ORDER BY DeletedAt DESC, ID DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dest := make([]*task.Task, 0)
+
+	for rows.Next() && (limit <= 0 || len(dest) < limit) {
+		var deletedAt int64
+
+		t, err := scanTask(rows, &deletedAt)
+		if err != nil {
+			return dest, err
+		}
+
+		t.DeletedAt = fromUnixMilli(deletedAt)
+
+		if terms == nil || matchesSearch(terms, t) {
+			dest = append(dest, t)
+		}
+	}
+
+	return dest, rows.Err()
+}
+
+// Deletes for good the tasks removed before the given time, returning how many have been deleted
+func (sdb *SQLiteDB) PurgeTrash(before time.Time) (int, error) {
+	res, err := sdb.db.Exec(
+		`DELETE FROM `+TRASH_TABLE_NAME+` WHERE DeletedAt < ?`,
+		before.UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+
+	return int(count), err
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+func TestTrash(t *testing.T) {
+	forEachStore(t, testTrash)
+}
+
+func testTrash(t *testing.T, db taskStore) {
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.LastTrashBatch(); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("LastTrashBatch: Expected sql.ErrNoRows for an empty trash, got %v", err)
+	}
+
+	ids := make(map[string]string)
+
+	for _, spec := range []struct {
+		name  string
+		state int
+	}{
+		{"first queued", states.TASK_STATE_QUEUED},
+		{"second queued", states.TASK_STATE_QUEUED},
+		{"completed", states.TASK_STATE_COMPLETED},
+		{"failed", states.TASK_STATE_FAILED},
+	} {
+		tsk := task.NewTask(spec.name)
+		tsk.DisplayName = spec.name
+		tsk.AlbumID = spec.name
+		tsk.DownloadState = spec.state
+
+		if _, err := db.Insert(tsk); err != nil {
+			t.Fatal(err)
+		}
+
+		ids[spec.name] = tsk.Id
+	}
+
+	if err := db.AddEvent(ids["completed"], &task.Event{Kind: task.EventFinished}); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := db.RemoveFromIDs([]string{ids["completed"], ids["failed"]}); err != nil || count != 2 {
+		t.Fatalf("RemoveFromIDs: Expected 2 removed tasks, got %d (%v)", count, err)
+	}
+
+	first, err := db.LastTrashBatch()
+	if err != nil || first.Count != 2 {
+		t.Fatalf("LastTrashBatch: Expected a batch of 2 tasks, got %+v (%v)", first, err)
+	}
+
+	if count, err := db.RemoveFromState(states.TASK_STATE_QUEUED); err != nil || count != 2 {
+		t.Fatalf("RemoveFromState: Expected 2 removed tasks, got %d (%v)", count, err)
+	}
+
+	last, err := db.LastTrashBatch()
+	if err != nil || last.Count != 2 || last.ID == first.ID {
+		t.Fatalf("LastTrashBatch: Expected the batch of the queued tasks, got %+v (%v)", last, err)
+	}
+
+	batch, err := db.GetTrashBatch(first.ID)
+	if err != nil || len(batch) != 2 || batch[0].DeletedAt.IsZero() {
+		t.Fatalf("GetTrashBatch: Expected the 2 tasks of the first removal, got %d (%v)", len(batch), err)
+	}
+
+	for _, tsk := range batch {
+		if tsk.Id != ids["completed"] && tsk.Id != ids["failed"] {
+			t.Errorf("GetTrashBatch: Unexpected task %q in the first removal", tsk.DisplayName)
+		}
+	}
+
+	if _, err := db.Get(ids["first queued"]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Get: Expected a trashed task to leave the queue, got %v", err)
+	}
+
+	trashed, err := db.GetTrashed(ids["failed"])
+	if err != nil || trashed.DeletedAt.IsZero() {
+		t.Fatalf("GetTrashed: Expected the removal time to be set, got %+v (%v)", trashed, err)
+	}
+
+	found, err := db.SearchTrash("queued", 0)
+	if err != nil || len(found) != 2 {
+		t.Fatalf("SearchTrash: Expected 2 matching tasks, got %d (%v)", len(found), err)
+	}
+
+	// the album of a trashed task is free to be queued again, so restoring it is a duplicate
+	again := task.NewTask("second queued")
+	again.AlbumID = "second queued"
+
+	if _, err := db.Insert(again); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := db.RestoreBatch(last.ID); err != nil || count != 1 {
+		t.Fatalf("RestoreBatch: Expected 1 restored task, got %d (%v)", count, err)
+	}
+
+	restored, err := db.Get(ids["first queued"])
+	if err != nil || restored.DownloadState != states.TASK_STATE_QUEUED {
+		t.Fatalf("RestoreBatch: Expected the task to be queued again, got %+v (%v)", restored, err)
+	}
+
+	// the duplicate stays in the trash
+	batch, err = db.GetTrashBatch(last.ID)
+	if err != nil || len(batch) != 1 || batch[0].Id != ids["second queued"] {
+		t.Fatalf("GetTrashBatch: Expected the task left in the trash, got %d (%v)", len(batch), err)
+	}
+
+	if _, err := db.RestoreTrashed(ids["second queued"]); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("RestoreTrashed: Expected ErrDuplicate, got %v", err)
+	}
+
+	if _, err := db.RestoreTrashed("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreTrashed: Expected sql.ErrNoRows, got %v", err)
+	}
+
+	completed, err := db.RestoreTrashed(ids["completed"])
+	if err != nil || completed.DownloadState != states.TASK_STATE_COMPLETED {
+		t.Fatalf("RestoreTrashed: Expected the task to be restored as it was, got %+v (%v)", completed, err)
+	}
+
+	if count, err := db.PurgeTrash(time.Now().Add(-time.Hour)); err != nil || count != 0 {
+		t.Errorf("PurgeTrash: Expected no purged task, got %d (%v)", count, err)
+	}
+
+	if count, err := db.PurgeTrash(time.Now().Add(time.Second)); err != nil || count != 2 {
+		t.Errorf("PurgeTrash: Expected 2 purged tasks, got %d (%v)", count, err)
+	}
+
+	found, err = db.SearchTrash("", 0)
+	if err != nil || len(found) != 0 {
+		t.Errorf("SearchTrash: Expected an empty trash, got %d tasks (%v)", len(found), err)
+	}
+
+	// the timeline of the restored task is still there
+	events, err := db.GetEvents(ids["completed"])
+	if err != nil || len(events) != 1 {
+		t.Errorf("GetEvents: Expected the timeline to survive the trash, got %d events (%v)", len(events), err)
+	}
+}
@@ -3,37 +3,44 @@ package dsdl
 import (
 	"log"
 
-	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
 )
 
-func restoreDB(sqlite *db.SQLiteDB) *db.SQLiteDB {
-	// check if app has perms to open a sorage-based db, fallbacks to memory db
-	err := sqlite.Open()
+// Cleans up an opened store and requeues the tasks that were running when the app stopped
+func restoreDB(store TaskStore) TaskStore {
+	// partial downloads of deleted tasks can't be resumed anymore,
+	// their files are left to PruneTempDir
+	orphans, err := store.RemoveOrphanJournals()
 	if err != nil {
-		log.Printf(
-			"Failed to open selected db: \"%s\", falling back to the in-memory db",
-			sqlite.Name(),
-		)
+		log.Panicf("TQWrapper: DB error: %v", err)
+	}
+	if len(orphans) != 0 {
+		log.Printf("DB: Removed %d orphan download journals", len(orphans))
+	}
 
-		return db.NewSQLite(true)
+	_, err = store.RemoveOrphanAttempts()
+	if err != nil {
+		log.Panicf("TQWrapper: DB error: %v", err)
 	}
 
-	log.Println("DB: Using", sqlite.Name())
+	_, err = store.RemoveOrphanEvents()
+	if err != nil {
+		log.Panicf("TQWrapper: DB error: %v", err)
+	}
 
 	// restore saved data
-	count, err := sqlite.Count()
+	count, err := store.Count()
 	if err != nil {
 		log.Panicf("TQWrapper: constructor: cannot evaluate db count: %v", err)
 	}
 
 	if count != 0 {
-		running, err := sqlite.GetAllWithState(states.TASK_STATE_RUNNING)
+		running, err := store.GetAllWithState(states.TASK_STATE_RUNNING)
 		if err != nil {
 			log.Panicf("TQWrapper: DB error: %v", err)
 		}
 
-		queued, err := sqlite.GetAllWithState(states.TASK_STATE_QUEUED)
+		queued, err := store.GetAllWithState(states.TASK_STATE_QUEUED)
 		if err != nil {
 			log.Panicf("TQWrapper: DB error: %v", err)
 		}
@@ -41,15 +48,15 @@ func restoreDB(sqlite *db.SQLiteDB) *db.SQLiteDB {
 		for _, t := range running {
 			t.DownloadState = states.TASK_STATE_QUEUED
 			t.Err = nil
-			sqlite.SetState(t, states.TASK_STATE_QUEUED)
+			store.SetState(t, states.TASK_STATE_QUEUED)
 		}
 
 		for _, t := range queued {
 			t.DownloadState = states.TASK_STATE_QUEUED
 			t.Err = nil
-			sqlite.SetState(t, states.TASK_STATE_QUEUED)
+			store.SetState(t, states.TASK_STATE_QUEUED)
 		}
 	}
 
-	return sqlite
+	return store
 }
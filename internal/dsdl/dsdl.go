@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sync"
+	"sync/atomic"
 
 	"github.com/playwright-community/playwright-go"
 
-	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
 )
 
 type (
@@ -25,17 +28,38 @@ const (
 )
 
 type DSDL struct {
-	db *db.SQLiteDB
+	db TaskStore
 
 	aggregators Aggregators
 	filehosts   Filehosts
 
+	retryPolicy     RetryPolicy
+	retentionPolicy RetentionPolicy
+
+	// tasks handed to a runner, indexed by ID
+	active map[string]*task.Task
+	// closed once the runner of the task has returned
+	runnerDone map[string]chan struct{}
+	activeMu   sync.Mutex
+	// serializes the start of the tasks with the changes that depend on whether they run
+	claimMu sync.Mutex
+
+	queuePaused atomic.Bool
+	// wakes the queue runner up
+	wake chan struct{}
+
 	pw      *playwright.Playwright
 	browser playwright.Browser
 }
 
-func NewDSDL(browser playwright.Browser) *DSDL {
-	dsdl := &DSDL{}
+// Creates the engine on top of an already opened task store
+func NewDSDL(browser playwright.Browser, store TaskStore) *DSDL {
+	dsdl := &DSDL{
+		retryPolicy:     DefaultRetryPolicy(),
+		retentionPolicy: DefaultRetentionPolicy(),
+		active:          make(map[string]*task.Task),
+		wake:            make(chan struct{}, 1),
+	}
 
 	// start browser
 	pw, err := playwright.Run()
@@ -68,9 +92,9 @@ func NewDSDL(browser playwright.Browser) *DSDL {
 	// dsdl.browser = browser
 	dsdl.pw = pw
 
-	// start database
-	sqlite := db.NewSQLite(false)
-	dsdl.db = restoreDB(sqlite)
+	// restore the stored tasks
+	dsdl.db = restoreDB(store)
+	dsdl.db.OnQueueChange(dsdl.Wake)
 
 	return dsdl
 }
@@ -97,7 +121,11 @@ func (dsdl *DSDL) Shutdown() error {
 
 func (dsdl *DSDL) Browser() playwright.Browser { return dsdl.browser }
 
-func (dsdl *DSDL) DB() *db.SQLiteDB { return dsdl.db }
+func (dsdl *DSDL) DB() TaskStore { return dsdl.db }
+
+func (dsdl *DSDL) RetryPolicy() RetryPolicy { return dsdl.retryPolicy }
+
+func (dsdl *DSDL) SetRetryPolicy(rp RetryPolicy) { dsdl.retryPolicy = rp }
 
 func (dsdl *DSDL) RegisterAggregator(f *Aggregator) error {
 	unique := true
@@ -123,17 +151,7 @@ addAggr:
 }
 
 func (dsdl *DSDL) IsValidAggregator(name string) bool {
-	if len(dsdl.aggregators) == 0 {
-		return false
-	}
-
-	for _, v := range dsdl.aggregators {
-		if v.Name == name {
-			return true
-		}
-	}
-
-	return false
+	return dsdl.aggregators.Has(name)
 }
 
 func (dsdl *DSDL) EvaluateAggregator(aggrID string) (AggregatorConstrFn, error) {
@@ -147,7 +165,7 @@ func (dsdl *DSDL) EvaluateAggregator(aggrID string) (AggregatorConstrFn, error)
 		}
 	}
 
-	return nil, fmt.Errorf("Aggregator not found:\"%s\"", aggrID)
+	return nil, dsdlerr.New(dsdlerr.NotFound, "Aggregator not found:\"%s\"", aggrID)
 }
 
 func (dsdl *DSDL) EvaluateAggregatorFromUrl(url string) (AggregatorConstrFn, error) {
@@ -165,7 +183,7 @@ func (dsdl *DSDL) EvaluateAggregatorFromUrl(url string) (AggregatorConstrFn, err
 		}
 	}
 
-	return nil, fmt.Errorf("Aggregator not found for this url: \"%s\"", url)
+	return nil, dsdlerr.New(dsdlerr.NotFound, "Aggregator not found for this url: \"%s\"", url)
 }
 
 func (dsdl *DSDL) RegisterFilehost(f *Filehost) error {
@@ -220,5 +238,5 @@ func (dsdl *DSDL) EvaluateFilehost(url string) (FilehostConstrFn, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("Filehost not found for this url: \"%s\"", url)
+	return nil, dsdlerr.New(dsdlerr.NotFound, "Filehost not found for this url: \"%s\"", url)
 }
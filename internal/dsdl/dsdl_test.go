@@ -1,14 +1,23 @@
 package dsdl
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"path/filepath"
 	"testing"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 )
 
 func TestProperFunctioning(t *testing.T) {
-	d := NewDSDL(nil)
+	store := db.NewSQLite(true)
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDSDL(nil, store)
 
 	aggregatorName := "test"
 
@@ -20,8 +29,10 @@ func TestProperFunctioning(t *testing.T) {
 
 	aggregator := aggregatorConstructor("112334", nil)
 
+	ctx := context.Background()
+
 	// get filehost url
-	filehostPage, err := aggregator.EvaluateDownloadPage()
+	filehostPage, err := aggregator.EvaluateDownloadPage(ctx)
 	if err != nil {
 		log.Fatalf(
 			"Cannot evaluate a filehost url from this aggregator link: \"%s\"",
@@ -40,17 +51,17 @@ func TestProperFunctioning(t *testing.T) {
 	var fn string
 	var fext string
 
-	fn, err = filehost.EvaluateFileName()
+	fn, err = filehost.EvaluateFileName(ctx)
 	if err != nil {
-		fn, err = aggregator.EvaluateFileName()
+		fn, err = aggregator.EvaluateFileName(ctx)
 		if err != nil {
 			log.Fatalln("Cannot evaluate a proper filename")
 		}
 	}
 
-	fext, err = filehost.EvaluateFileExt()
+	fext, err = filehost.EvaluateFileExt(ctx)
 	if err != nil {
-		fext, err = aggregator.EvaluateFileExt()
+		fext, err = aggregator.EvaluateFileExt(ctx)
 		if err != nil {
 			log.Fatalln("Cannot evaluate a proper file extension")
 		}
@@ -60,7 +71,7 @@ func TestProperFunctioning(t *testing.T) {
 
 	dlpath := filepath.Join(".", "test-downloads", filename)
 
-	err = filehost.Download(dlpath, dlpath, filename, func(p int8) {})
+	err = filehost.Download(ctx, dlpath, dlpath, filename, func(p progress.Progress) {}, nil)
 	if err != nil {
 		log.Fatalln("Could not download file:", err)
 	}
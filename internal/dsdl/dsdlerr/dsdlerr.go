@@ -0,0 +1,205 @@
+/*
+Typed errors returned by aggregators, filehosts and the task runner.
+
+Every error carries a Category, which is persisted alongside the task so that
+the retry logic, the UI and the API can branch on it instead of parsing messages.
+
+# Usage
+
+	if is404 {
+		return dsdlerr.New(dsdlerr.NotFound, "The requested page has been taken down")
+	}
+
+	_, err := page.Goto(url)
+	if err != nil {
+		return dsdlerr.Wrap(dsdlerr.Network, err)
+	}
+
+	if dsdlerr.CategoryOf(err).Retryable() {
+		...
+	}
+*/
+package dsdlerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+type Category string
+
+const (
+	// The error has not been categorized
+	Unknown Category = "unknown"
+	// The page or file doesn't exist (anymore)
+	NotFound Category = "not-found"
+	// The filehost refuses to serve more data for now
+	QuotaExceeded Category = "quota-exceeded"
+	// Connection errors, timeouts and server-side failures
+	Network Category = "network"
+	// A selector didn't match anything: the website layout likely changed
+	SelectorBroken Category = "selector-broken"
+	// The file requires a password to be downloaded
+	PasswordProtected Category = "password-protected"
+	// The task has been stopped on purpose
+	Aborted Category = "aborted"
+	// The same album has already been downloaded
+	Duplicate Category = "duplicate"
+)
+
+var categories = []Category{
+	Unknown,
+	NotFound,
+	QuotaExceeded,
+	Network,
+	SelectorBroken,
+	PasswordProtected,
+	Aborted,
+	Duplicate,
+}
+
+// Returns every known category
+func Categories() []Category {
+	return categories
+}
+
+// Returns whether s is the name of a known category
+func IsValidCategory(s string) bool {
+	for _, c := range categories {
+		if string(c) == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns whether a task failed with this category may succeed if attempted again.
+//
+// Unknown errors are not retried: they are as likely to be a bug or a layout change
+// as a transient failure, and retrying them would only delay the report
+func (c Category) Retryable() bool {
+	switch c {
+	case Network, QuotaExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c Category) String() string {
+	return string(c)
+}
+
+// Categorized error
+type Error struct {
+	Category Category
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Formats a new error of the given category. Supports %w just like fmt.Errorf
+func New(c Category, format string, a ...any) error {
+	return &Error{
+		Category: c,
+		Err:      fmt.Errorf(format, a...),
+	}
+}
+
+// Assigns a category to err. Errors that already have one are returned untouched
+//
+// Returns nil if err is nil
+func Wrap(c Category, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	return &Error{
+		Category: c,
+		Err:      err,
+	}
+}
+
+// Causes of a cancelled task context, retrieved through context.Cause
+var (
+	ErrUserAbort = New(Aborted, "Task aborted by user")
+	ErrPaused    = New(Aborted, "Task paused by user")
+	ErrShutdown  = New(Aborted, "Interrupted by server shutdown")
+	// the task took longer than the configured limit: it may succeed if attempted again
+	ErrTimeout = New(Network, "Task timed out")
+)
+
+// Returns why ctx has been cancelled as a categorized error, or nil if it is still alive.
+//
+// Contexts cancelled without one of the causes above are reported as Aborted,
+// expired deadlines as Network
+func FromContext(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+
+	cause := context.Cause(ctx)
+	if errors.Is(cause, context.DeadlineExceeded) {
+		return Wrap(Network, cause)
+	}
+
+	return Wrap(Aborted, cause)
+}
+
+// Returns the category of err.
+//
+// Uncategorized timeouts and connection errors are reported as Network,
+// everything else as Unknown
+func CategoryOf(err error) Category {
+	if err == nil {
+		return ""
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, playwright.ErrTimeout) {
+		return Network
+	}
+
+	return Unknown
+}
+
+// Rebuilds an error from its stored message and category
+//
+// Returns nil if msg is empty
+func FromString(c Category, msg string) error {
+	if msg == "" {
+		return nil
+	}
+
+	if !IsValidCategory(string(c)) {
+		c = Unknown
+	}
+
+	return &Error{
+		Category: c,
+		Err:      errors.New(msg),
+	}
+}
@@ -0,0 +1,92 @@
+package dsdlerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCategoryOf(t *testing.T) {
+	notFound := New(NotFound, "page %s has been taken down", "22816")
+
+	cases := []struct {
+		err  error
+		want Category
+	}{
+		{nil, ""},
+		{notFound, NotFound},
+		{fmt.Errorf("aggregator: %w", notFound), NotFound},
+		{Wrap(Network, notFound), NotFound},
+		{Wrap(QuotaExceeded, errors.New("bandwidth limit")), QuotaExceeded},
+		{fmt.Errorf("page load: %w", context.DeadlineExceeded), Network},
+		{errors.New("something else"), Unknown},
+	}
+
+	for _, c := range cases {
+		if got := CategoryOf(c.err); got != c.want {
+			t.Fatalf("CategoryOf(%v): expected %q, got %q", c.err, c.want, got)
+		}
+	}
+}
+
+func TestFromString(t *testing.T) {
+	if FromString(Network, "") != nil {
+		t.Fatal("FromString: expected nil error from an empty message")
+	}
+
+	err := FromString(PasswordProtected, "file is password protected")
+	if CategoryOf(err) != PasswordProtected || err.Error() != "file is password protected" {
+		t.Fatalf("FromString: unexpected error: %v (%v)", err, CategoryOf(err))
+	}
+
+	// errors stored before categories existed
+	if CategoryOf(FromString("", "legacy error")) != Unknown {
+		t.Fatal("FromString: expected uncategorized errors to be Unknown")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, c := range []Category{Network, QuotaExceeded} {
+		if !c.Retryable() {
+			t.Fatalf("%q should be retryable", c)
+		}
+	}
+
+	for _, c := range []Category{Unknown, NotFound, SelectorBroken, PasswordProtected, Aborted, Duplicate} {
+		if c.Retryable() {
+			t.Fatalf("%q should not be retryable", c)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	if FromContext(ctx) != nil {
+		t.Fatal("FromContext: expected nil error from a live context")
+	}
+
+	cancel(ErrPaused)
+	if err := FromContext(ctx); !errors.Is(err, ErrPaused) || CategoryOf(err) != Aborted {
+		t.Fatalf("FromContext: expected the pause cause, got %v (%v)", err, CategoryOf(err))
+	}
+
+	ctx, cancel = context.WithCancelCause(context.Background())
+	cancel(nil)
+	if CategoryOf(FromContext(ctx)) != Aborted {
+		t.Fatal("FromContext: expected a plain cancellation to be Aborted")
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), 0)
+	defer stop()
+	if CategoryOf(FromContext(ctx)) != Network {
+		t.Fatal("FromContext: expected an expired deadline to be Network")
+	}
+
+	ctx, stop = context.WithTimeoutCause(context.Background(), 0, ErrTimeout)
+	defer stop()
+	if err := FromContext(ctx); !errors.Is(err, ErrTimeout) || !CategoryOf(err).Retryable() {
+		t.Fatalf("FromContext: expected a retryable timeout, got %v", err)
+	}
+}
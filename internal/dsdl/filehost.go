@@ -1,15 +1,28 @@
 package dsdl
 
-import "github.com/playwright-community/playwright-go"
+import (
+	"context"
+
+	"github.com/playwright-community/playwright-go"
+
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
+)
 
 type FilehostImpl interface {
 	PwPageNavigator
 
 	SetPage(p playwright.Page)
 	Page() playwright.Page
-	EvaluateFileName() (string, error)
-	EvaluateFileExt() (string, error)
-	Download(tempDir, finalDir, filename string, setProgress func(p int8)) error
+	EvaluateFileName(ctx context.Context) (string, error)
+	EvaluateFileExt(ctx context.Context) (string, error)
+	// Downloads the file into finalDir. Cancelling ctx stops the download, keeping its partial data
+	Download(
+		ctx context.Context,
+		tempDir, finalDir, filename string,
+		setProgress func(p progress.Progress),
+		checkpoint func(pd *appUtils.PartialDownload),
+	) error
 }
 
 type FilehostConstrFn func(p playwright.Page) FilehostImpl
@@ -23,4 +36,6 @@ type Filehost struct {
 	Constructor FilehostConstrFn
 	// regexes tested against url
 	AllowedUrlWildcards []string
+	// returns the ID of the file of a page of the filehost, the same for every link to it
+	FileID func(url string) (string, error)
 }
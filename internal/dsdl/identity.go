@@ -0,0 +1,159 @@
+package dsdl
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Returned when the album or the file of a task is already stored by another task
+type DuplicateError struct {
+	// The task already storing the album, nil if it couldn't be found
+	Existing *task.Task
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Existing == nil {
+		return "The album is already present in the database"
+	}
+
+	return fmt.Sprintf("The album is %s (task %s)", e.Reason(), e.Existing.Id)
+}
+
+func (e *DuplicateError) Unwrap() error { return ErrDuplicateTask }
+
+// Tells the users what happened to the existing task: "already queued", "already downloaded" or "failed earlier, retry?"
+func (e *DuplicateError) Reason() string {
+	if e.Existing == nil {
+		return "already queued"
+	}
+
+	switch e.Existing.DownloadState {
+	case states.TASK_STATE_COMPLETED, states.TASK_STATE_SKIPPED:
+		return "already downloaded"
+	case states.TASK_STATE_FAILED, states.TASK_STATE_CANCELED:
+		return "failed earlier, retry?"
+	default:
+		return "already queued"
+	}
+}
+
+// Returns the aggregator with this name, nil if it isn't in the list
+func (a Aggregators) Get(name string) *Aggregator {
+	for _, v := range a {
+		if v.Name == name {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// Returns the ID of the album referred to by the slug, the slug itself if the aggregator has no Canonicalize
+func (a *Aggregator) AlbumID(slug string) (string, error) {
+	if a.Canonicalize == nil {
+		return slug, nil
+	}
+
+	return a.Canonicalize(slug)
+}
+
+/*
+Returns the name of the filehost of the url and the ID of the file on it.
+
+Both are empty when no filehost matches the url or it can't tell the ID of its files
+*/
+func (dsdl *DSDL) FileIdentity(url string) (string, string) {
+	for _, v := range dsdl.filehosts {
+		for _, wildcard := range v.AllowedUrlWildcards {
+			r, _ := regexp.Compile(wildcard)
+
+			if !r.MatchString(url) {
+				continue
+			}
+
+			if v.FileID == nil {
+				return "", ""
+			}
+
+			id, err := v.FileID(url)
+			if err != nil {
+				return "", ""
+			}
+
+			return v.Name, id
+		}
+	}
+
+	return "", ""
+}
+
+// Returns the task already storing the album, wrapped in a DuplicateError
+func duplicateOf(store TaskStore, aggregator, albumID string) error {
+	existing, err := store.GetByAlbum(aggregator, albumID)
+	if err != nil {
+		existing = nil
+	}
+
+	return &DuplicateError{Existing: existing}
+}
+
+/*
+Sets the album ID of the tasks stored before it existed.
+
+A task referring to an album already stored by another one keeps an empty album ID,
+as the database can't hold both: it is logged and left as it is
+*/
+func (dsdl *DSDL) BackfillAlbumIDs() error {
+	tasks, err := dsdl.db.GetAll()
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+
+	for _, t := range tasks {
+		if t.AlbumID != "" {
+			continue
+		}
+
+		aggr := dsdl.aggregators.Get(t.Aggregator)
+		if aggr == nil {
+			continue
+		}
+
+		id, err := aggr.AlbumID(t.Slug)
+		if err != nil || id == "" {
+			continue
+		}
+
+		t.AlbumID = id
+
+		if err := dsdl.db.Update(t); err != nil {
+			if !errors.Is(err, ErrDuplicateTask) {
+				return err
+			}
+
+			if existing, err := dsdl.db.GetByAlbum(t.Aggregator, id); err == nil {
+				log.Printf("Engine: Task %s is a duplicate of task %s\n", t.Id, existing.Id)
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+
+			continue
+		}
+
+		updated++
+	}
+
+	if updated != 0 {
+		log.Printf("Engine: Set the album ID of %d tasks\n", updated)
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package dsdl
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// How long a removal waits for the runners of the removed tasks to return
+const stopTimeout = 30 * time.Second
+
+var (
+	// Returned when aborting a task that has already ended
+	ErrTaskEnded = errors.New("The task has already ended")
+	// Returned when retrying a task that hasn't ended yet
+	ErrTaskNotEnded = errors.New("Only ended tasks can be retried")
+	// Returned when removing a running task whose runner takes too long to stop
+	ErrTaskStopping = errors.New("The task is still stopping, try again later")
+)
+
+/*
+Registers a task handed to a runner, so that it can be reached while running.
+
+Reports false, tracking nothing, if the runner of a previous run of the task
+hasn't returned yet
+*/
+func (dsdl *DSDL) TrackTask(t *task.Task) bool {
+	dsdl.activeMu.Lock()
+	defer dsdl.activeMu.Unlock()
+
+	if _, ok := dsdl.active[t.Id]; ok {
+		return false
+	}
+
+	if dsdl.runnerDone == nil {
+		dsdl.runnerDone = make(map[string]chan struct{})
+	}
+
+	dsdl.active[t.Id] = t
+	dsdl.runnerDone[t.Id] = make(chan struct{})
+
+	return true
+}
+
+// Unregisters a task whose runner has returned. A later run of the same task is left alone
+func (dsdl *DSDL) UntrackTask(t *task.Task) {
+	dsdl.activeMu.Lock()
+	defer dsdl.activeMu.Unlock()
+
+	if dsdl.active[t.Id] != t {
+		return
+	}
+
+	delete(dsdl.active, t.Id)
+
+	if done, ok := dsdl.runnerDone[t.Id]; ok {
+		close(done)
+		delete(dsdl.runnerDone, t.Id)
+	}
+}
+
+// Aborts the task if it is running, returning a channel closed once its runner has returned
+func (dsdl *DSDL) abortActive(id string) (<-chan struct{}, bool) {
+	dsdl.activeMu.Lock()
+	defer dsdl.activeMu.Unlock()
+
+	t, ok := dsdl.active[id]
+	if !ok {
+		return nil, false
+	}
+
+	t.Abort()
+
+	return dsdl.runnerDone[id], true
+}
+
+// Returns the running task with that ID, if any
+func (dsdl *DSDL) ActiveTask(id string) (*task.Task, bool) {
+	dsdl.activeMu.Lock()
+	defer dsdl.activeMu.Unlock()
+
+	t, ok := dsdl.active[id]
+
+	return t, ok
+}
+
+// Returns every task handed to a runner
+func (dsdl *DSDL) ActiveTasks() []*task.Task {
+	dsdl.activeMu.Lock()
+	defer dsdl.activeMu.Unlock()
+
+	tasks := make([]*task.Task, 0, len(dsdl.active))
+	for _, t := range dsdl.active {
+		tasks = append(tasks, t)
+	}
+
+	return tasks
+}
+
+// Returns the number of tasks handed to a runner
+func (dsdl *DSDL) ActiveCount() int {
+	dsdl.activeMu.Lock()
+	defer dsdl.activeMu.Unlock()
+
+	return len(dsdl.active)
+}
+
+/*
+Hands the next queued task to a runner, returning sql.ErrNoRows if none can start now.
+
+The task is tracked before being marked as running, under the lock taken by PauseTask
+and AbortTask: they reach either the queued task or the running one, never a task
+running in the database that its runner doesn't know is stopped.
+The runner must call UntrackTask once it returns. Returns ErrTaskStopping if the
+runner of a previous run of the task hasn't returned yet
+*/
+func (dsdl *DSDL) ClaimNext(now time.Time) (*task.Task, error) {
+	dsdl.claimMu.Lock()
+	defer dsdl.claimMu.Unlock()
+
+	t, err := dsdl.db.GetNextQueued(now)
+	if err != nil {
+		return nil, err
+	}
+
+	// the queue runner is woken up again once the previous runner returns
+	if !dsdl.TrackTask(t) {
+		return nil, fmt.Errorf("%w: %s", ErrTaskStopping, t.Id)
+	}
+
+	if _, err := dsdl.db.AdvanceState(t); err != nil {
+		dsdl.UntrackTask(t)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Notifies the queue runner that a task may be started. Never blocks
+func (dsdl *DSDL) Wake() {
+	select {
+	case dsdl.wake <- struct{}{}:
+	default:
+		// a wake up is already pending
+	}
+}
+
+// Receives a value every time the queue runner should look for tasks to start
+func (dsdl *DSDL) WakeChan() <-chan struct{} { return dsdl.wake }
+
+// Stops the queue runner from starting new tasks. Running tasks are not affected
+func (dsdl *DSDL) PauseQueue() { dsdl.queuePaused.Store(true) }
+
+func (dsdl *DSDL) ResumeQueue() {
+	dsdl.queuePaused.Store(false)
+	dsdl.Wake()
+}
+
+func (dsdl *DSDL) IsQueuePaused() bool { return dsdl.queuePaused.Load() }
+
+/*
+Pauses a task.
+
+A queued task is simply skipped by the queue runner, while a running one is stopped
+keeping its partial data: its runner moves it to the paused state once the download
+has been interrupted, so the returned task could still be running.
+*/
+func (dsdl *DSDL) PauseTask(id string) (*task.Task, error) {
+	dsdl.claimMu.Lock()
+	defer dsdl.claimMu.Unlock()
+
+	if t, ok := dsdl.ActiveTask(id); ok {
+		t.Pause()
+		return t, nil
+	}
+
+	t, err := dsdl.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.DownloadState != states.TASK_STATE_QUEUED {
+		return t, fmt.Errorf("Only queued or running tasks can be paused")
+	}
+
+	return t, dsdl.db.SetState(t, states.TASK_STATE_PAUSED)
+}
+
+// Puts a paused task back in the queue
+func (dsdl *DSDL) ResumeTask(id string) (*task.Task, error) {
+	t, err := dsdl.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.DownloadState != states.TASK_STATE_PAUSED {
+		return t, fmt.Errorf("Only paused tasks can be resumed")
+	}
+
+	return t, dsdl.db.SetState(t, states.TASK_STATE_QUEUED)
+}
+
+/*
+Cancels a task.
+
+A queued or paused task is canceled right away, while a running one is stopped:
+its runner marks it as canceled once the download has been interrupted, so the
+returned task could still be running.
+
+Returns ErrTaskEnded if the task has already ended
+*/
+func (dsdl *DSDL) AbortTask(id string) (*task.Task, error) {
+	dsdl.claimMu.Lock()
+	defer dsdl.claimMu.Unlock()
+
+	if t, ok := dsdl.ActiveTask(id); ok {
+		t.Abort()
+		return t, nil
+	}
+
+	t, err := dsdl.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if states.IsEnded(t.DownloadState) {
+		return t, ErrTaskEnded
+	}
+
+	t.DownloadState = states.TASK_STATE_CANCELED
+	t.Err = dsdlerr.ErrUserAbort
+	t.FinishedAt = time.Now()
+
+	if err := dsdl.db.Update(t); err != nil {
+		return nil, err
+	}
+
+	dsdl.db.AddEvent(t.Id, &task.Event{
+		Kind:    task.EventFinished,
+		Message: fmt.Sprintf("%s: %v", states.GetStateStr(t.DownloadState), t.Err),
+	})
+
+	return t, nil
+}
+
+/*
+Moves tasks to the trash as a single removal, returning how many have been found.
+
+The running tasks are aborted and removed once their runner has returned: it would
+otherwise keep downloading a task that left the queue, and an undo would queue the
+task again while its first runner is still alive.
+
+Returns ErrTaskStopping, removing nothing, if a runner doesn't return within stopTimeout
+*/
+func (dsdl *DSDL) RemoveTasks(ids []string) (int, error) {
+	// no task can start in the meantime
+	dsdl.claimMu.Lock()
+	defer dsdl.claimMu.Unlock()
+
+	var stopping []<-chan struct{}
+
+	for _, id := range ids {
+		if done, ok := dsdl.abortActive(id); ok {
+			stopping = append(stopping, done)
+		}
+	}
+
+	timeout := time.NewTimer(stopTimeout)
+	defer timeout.Stop()
+
+	for _, done := range stopping {
+		select {
+		case <-done:
+		case <-timeout.C:
+			return 0, ErrTaskStopping
+		}
+	}
+
+	return dsdl.db.RemoveFromIDs(ids)
+}
+
+/*
+Queues an ended task again, from its first attempt.
+
+Returns ErrTaskNotEnded if it hasn't ended yet, or if its runner hasn't returned:
+the task is ended in the database before its runner lets go of it, and a second
+runner would otherwise start while the first one is still tracked
+*/
+func (dsdl *DSDL) RetryTask(id string) (*task.Task, error) {
+	dsdl.claimMu.Lock()
+	defer dsdl.claimMu.Unlock()
+
+	if _, ok := dsdl.ActiveTask(id); ok {
+		return nil, ErrTaskNotEnded
+	}
+
+	t, err := dsdl.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !states.IsEnded(t.DownloadState) {
+		return t, ErrTaskNotEnded
+	}
+
+	if _, err := dsdl.db.ResetState(t); err != nil {
+		return nil, err
+	}
+
+	return dsdl.db.Get(id)
+}
@@ -0,0 +1,271 @@
+package dsdl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+func TestAbortAndRetryTask(t *testing.T) {
+	store := db.NewJSONFile(filepath.Join(t.TempDir(), "tasks.json"))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	engine := &DSDL{db: store, active: make(map[string]*task.Task)}
+
+	queued := task.NewTask("queued")
+	if _, err := store.Insert(queued); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.RetryTask(queued.Id); !errors.Is(err, ErrTaskNotEnded) {
+		t.Errorf("RetryTask: Expected ErrTaskNotEnded for a queued task, got %v", err)
+	}
+
+	aborted, err := engine.AbortTask(queued.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aborted.DownloadState != states.TASK_STATE_CANCELED || !errors.Is(aborted.Err, dsdlerr.ErrUserAbort) ||
+		aborted.FinishedAt.IsZero() {
+		t.Errorf("AbortTask: Expected a canceled task, got %+v", aborted)
+	}
+
+	if _, err := engine.AbortTask(queued.Id); !errors.Is(err, ErrTaskEnded) {
+		t.Errorf("AbortTask: Expected ErrTaskEnded for a canceled task, got %v", err)
+	}
+
+	// ended in the database, but its runner hasn't returned yet
+	engine.TrackTask(aborted)
+
+	if _, err := engine.RetryTask(queued.Id); !errors.Is(err, ErrTaskNotEnded) {
+		t.Errorf("RetryTask: Expected ErrTaskNotEnded while the runner is still tracked, got %v", err)
+	}
+
+	engine.UntrackTask(aborted)
+
+	retried, err := engine.RetryTask(queued.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if retried.DownloadState != states.TASK_STATE_QUEUED || retried.Err != nil {
+		t.Errorf("RetryTask: Expected a queued task without errors, got %+v", retried)
+	}
+
+	if _, err := engine.AbortTask("missing"); err == nil {
+		t.Error("AbortTask: Expected an error for a missing task")
+	}
+}
+
+func TestClaimNext(t *testing.T) {
+	store := db.NewJSONFile(filepath.Join(t.TempDir(), "tasks.json"))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	engine := &DSDL{db: store, active: make(map[string]*task.Task)}
+
+	if _, err := engine.ClaimNext(time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ClaimNext: Expected sql.ErrNoRows for an empty queue, got %v", err)
+	}
+
+	queued := task.NewTask("queued")
+	if _, err := store.Insert(queued); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := engine.ClaimNext(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := engine.ActiveTask(claimed.Id); !ok || claimed.DownloadState != states.TASK_STATE_RUNNING {
+		t.Fatalf("ClaimNext: Expected a tracked running task, got %+v (tracked: %v)", claimed, ok)
+	}
+
+	// a claimed task is stopped through its runner, the database is left alone
+	if _, err := engine.PauseTask(claimed.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Get(claimed.Id)
+	if err != nil || stored.DownloadState != states.TASK_STATE_RUNNING {
+		t.Fatalf("PauseTask: Expected the task to stay running until its runner stops, got %+v (%v)", stored, err)
+	}
+
+	ctx, cancel := claimed.Start(context.Background())
+	defer cancel()
+
+	if !errors.Is(context.Cause(ctx), dsdlerr.ErrPaused) {
+		t.Errorf("PauseTask: Expected the runner to be told to pause, got %v", context.Cause(ctx))
+	}
+}
+
+func TestTrackTask(t *testing.T) {
+	engine := &DSDL{active: make(map[string]*task.Task)}
+
+	first := task.NewTask("tracked")
+	if !engine.TrackTask(first) {
+		t.Fatal("TrackTask: Expected the task to be tracked")
+	}
+
+	// a second run of the same task, while the first runner is still alive
+	second := task.NewTask("tracked")
+	second.Id = first.Id
+
+	if engine.TrackTask(second) {
+		t.Error("TrackTask: Expected a task still tracked not to be replaced")
+	}
+
+	engine.UntrackTask(second)
+
+	if tracked, ok := engine.ActiveTask(first.Id); !ok || tracked != first {
+		t.Fatalf("UntrackTask: Expected only the tracked run to be untracked, got %p (tracked: %v)", tracked, ok)
+	}
+
+	done, _ := engine.abortActive(first.Id)
+	engine.UntrackTask(first)
+
+	select {
+	case <-done:
+	default:
+		t.Error("UntrackTask: Expected the runner to be reported as returned")
+	}
+
+	if _, ok := engine.ActiveTask(first.Id); ok {
+		t.Error("UntrackTask: Expected the task not to be tracked anymore")
+	}
+}
+
+func TestRemoveRunningTask(t *testing.T) {
+	store := db.NewJSONFile(filepath.Join(t.TempDir(), "tasks.json"))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	engine := &DSDL{db: store, active: make(map[string]*task.Task)}
+
+	if _, err := store.Insert(task.NewTask("running")); err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := engine.ClaimNext(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a runner downloading until it is stopped, then marking the task as canceled
+	go func() {
+		defer engine.UntrackTask(running)
+
+		ctx, cancel := running.Start(context.Background())
+		defer cancel()
+
+		<-ctx.Done()
+
+		running.DownloadState = states.TASK_STATE_CANCELED
+		running.Err = context.Cause(ctx)
+
+		if err := store.Update(running); err != nil {
+			t.Errorf("Update: Couldn't mark the aborted task as canceled: %v", err)
+		}
+	}()
+
+	if count, err := engine.RemoveTasks([]string{running.Id}); err != nil || count != 1 {
+		t.Fatalf("RemoveTasks: Expected 1 removed task, got %d (%v)", count, err)
+	}
+
+	if _, ok := engine.ActiveTask(running.Id); ok {
+		t.Error("RemoveTasks: Expected the runner to have returned")
+	}
+
+	batch, err := store.LastTrashBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := store.RestoreBatch(batch.ID); err != nil || count != 1 {
+		t.Fatalf("RestoreBatch: Expected 1 restored task, got %d (%v)", count, err)
+	}
+
+	// queued again, it would be started by a second runner
+	restored, err := store.Get(running.Id)
+	if err != nil || restored.DownloadState != states.TASK_STATE_CANCELED {
+		t.Fatalf("RestoreBatch: Expected the task to be restored as canceled, got %+v (%v)", restored, err)
+	}
+
+	if _, err := engine.ClaimNext(time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ClaimNext: Expected nothing to start after the restore, got %v", err)
+	}
+}
+
+func TestPurgeTrashPartialDownload(t *testing.T) {
+	store := db.NewJSONFile(filepath.Join(t.TempDir(), "tasks.json"))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	engine := &DSDL{
+		db:              store,
+		active:          make(map[string]*task.Task),
+		retentionPolicy: RetentionPolicy{KeepTrashed: time.Hour},
+	}
+
+	trashed := task.NewTask("trashed")
+	if _, err := store.Insert(trashed); err != nil {
+		t.Fatal(err)
+	}
+
+	partial := filepath.Join(t.TempDir(), trashed.Id, "file.part")
+	if err := os.MkdirAll(filepath.Dir(partial), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SaveJournal(&task.Journal{TaskID: trashed.Id, TempFilepath: partial}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.RemoveTasks([]string{trashed.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	// it can still be restored and resumed
+	if count, err := engine.PurgeTrash(time.Now()); err != nil || count != 0 {
+		t.Fatalf("PurgeTrash: Expected nothing to purge yet, got %d (%v)", count, err)
+	}
+
+	if _, err := os.Stat(partial); err != nil {
+		t.Fatalf("PurgeTrash: Expected the partial download of a trashed task to be kept, got %v", err)
+	}
+
+	if count, err := engine.PurgeTrash(time.Now().Add(2 * time.Hour)); err != nil || count != 1 {
+		t.Fatalf("PurgeTrash: Expected 1 purged task, got %d (%v)", count, err)
+	}
+
+	if _, err := os.Stat(filepath.Dir(partial)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("PurgeTrash: Expected the partial download to be deleted, got %v", err)
+	}
+
+	if journal, err := store.GetJournal(trashed.Id); err != nil || journal != nil {
+		t.Errorf("PurgeTrash: Expected the journal to be deleted, got %+v (%v)", journal, err)
+	}
+}
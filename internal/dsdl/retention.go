@@ -0,0 +1,122 @@
+package dsdl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Decides when ended tasks leave the queue for the archive
+type RetentionPolicy struct {
+	// How long completed and skipped tasks stay in the queue after ending, 0 keeping them forever.
+	// Failed and canceled tasks stay until the user acknowledges them
+	KeepSucceeded time.Duration
+	// How long removed tasks can be restored from the trash, 0 keeping them forever
+	KeepTrashed time.Duration
+}
+
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		KeepSucceeded: 30 * 24 * time.Hour,
+		KeepTrashed:   7 * 24 * time.Hour,
+	}
+}
+
+func (dsdl *DSDL) RetentionPolicy() RetentionPolicy { return dsdl.retentionPolicy }
+
+func (dsdl *DSDL) SetRetentionPolicy(rp RetentionPolicy) { dsdl.retentionPolicy = rp }
+
+// Moves the tasks the retention policy lets go to the archive, returning how many have been moved
+func (dsdl *DSDL) ArchiveExpired(now time.Time) (int, error) {
+	var successBefore time.Time
+
+	if keep := dsdl.retentionPolicy.KeepSucceeded; keep > 0 {
+		successBefore = now.Add(-keep)
+	}
+
+	return dsdl.db.Archive(successBefore)
+}
+
+/*
+Deletes for good the tasks that have been in the trash longer than the retention policy allows,
+along with their attempts, timeline and partial download.
+
+Returns how many tasks have been deleted
+*/
+func (dsdl *DSDL) PurgeTrash(now time.Time) (int, error) {
+	keep := dsdl.retentionPolicy.KeepTrashed
+	if keep <= 0 {
+		return 0, nil
+	}
+
+	count, err := dsdl.db.PurgeTrash(now.Add(-keep))
+	if err != nil || count == 0 {
+		return count, err
+	}
+
+	if _, err := dsdl.db.RemoveOrphanAttempts(); err != nil {
+		return count, err
+	}
+
+	journals, err := dsdl.db.RemoveOrphanJournals()
+	if err != nil {
+		return count, err
+	}
+
+	for _, j := range journals {
+		appUtils.DiscardPartialFile(j.TempFilepath)
+	}
+
+	_, err = dsdl.db.RemoveOrphanEvents()
+
+	return count, err
+}
+
+// Marks a failed or canceled task as seen by the user and moves it to the archive
+func (dsdl *DSDL) Acknowledge(id string) error {
+	if err := dsdl.db.Acknowledge(id); err != nil {
+		return err
+	}
+
+	_, err := dsdl.ArchiveExpired(time.Now())
+
+	return err
+}
+
+// Queues an archived task again, returning a *DuplicateError if its album has been queued in the meantime
+func (dsdl *DSDL) Redownload(id string) (*task.Task, error) {
+	t, err := dsdl.db.Unarchive(id)
+	if err == nil {
+		return t, nil
+	}
+
+	if !errors.Is(err, ErrDuplicateTask) {
+		return nil, err
+	}
+
+	if archived, getErr := dsdl.db.GetArchived(id); getErr == nil {
+		return nil, duplicateOf(dsdl.db, archived.Aggregator, archived.AlbumID)
+	}
+
+	return nil, err
+}
+
+// Moves a trashed task back where it was removed from, returning a *DuplicateError if its album has been queued in the meantime
+func (dsdl *DSDL) Restore(id string) (*task.Task, error) {
+	t, err := dsdl.db.RestoreTrashed(id)
+	if err == nil {
+		return t, nil
+	}
+
+	if !errors.Is(err, ErrDuplicateTask) {
+		return nil, err
+	}
+
+	if trashed, getErr := dsdl.db.GetTrashed(id); getErr == nil {
+		return nil, duplicateOf(dsdl.db, trashed.Aggregator, trashed.AlbumID)
+	}
+
+	return nil, err
+}
@@ -0,0 +1,46 @@
+package dsdl
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Decides whether and when a failed task is automatically queued again
+type RetryPolicy struct {
+	// Total number of attempts, the first one included. 1 disables automatic retries
+	MaxAttempts int
+	// Delay before the first retry, doubled on every following one
+	BaseDelay time.Duration
+	// Upper bound of the delay between two attempts
+	MaxDelay time.Duration
+	// Fraction of the delay (0-1) randomly added or subtracted, so that
+	// tasks failed together don't all retry at the same time
+	Jitter float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    10 * time.Minute,
+		Jitter:      0.2,
+	}
+}
+
+// Returns whether a task that failed its n-th attempt can be retried
+func (rp RetryPolicy) CanRetry(attempt int) bool {
+	return attempt < rp.MaxAttempts
+}
+
+// Returns how long to wait before retrying a task that failed its n-th attempt
+func (rp RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(rp.BaseDelay) * math.Pow(2, float64(max(attempt-1, 0)))
+	delay = min(delay, float64(rp.MaxDelay))
+
+	if rp.Jitter > 0 {
+		delay += delay * rp.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(max(delay, 0))
+}
@@ -0,0 +1,108 @@
+package dsdl
+
+import (
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+/*
+Storage of the tasks, their download journals and their attempts.
+
+Implementations must be safe for concurrent use, return sql.ErrNoRows from Get and
+GetNextQueued when nothing matches, and refuse the state changes forbidden by
+states.CanTransition.
+*/
+type TaskStore interface {
+	Open() error
+	Close() error
+	Name() string
+	// Whether the tasks survive a restart of the application
+	Persistent() bool
+	OnQueueChange(fn func())
+
+	Count() (int, error)
+	// Returns db.ErrDuplicate if a task with the same ID, album or file is already stored
+	Insert(t *task.Task) (string, error)
+	Find(slugOrID string) (bool, int, error)
+	Get(id string) (*task.Task, error)
+	// Return sql.ErrNoRows when no task has the album or the file
+	GetByAlbum(aggregator, albumID string) (*task.Task, error)
+	GetByFile(filehost, fileID string) (*task.Task, error)
+	GetNextQueued(now time.Time) (*task.Task, error)
+	GetNextRetryAt(now time.Time) (time.Time, error)
+	GetAll() ([]*task.Task, error)
+	GetAllWithState(state int) ([]*task.Task, error)
+	GetEnded() ([]*task.Task, error)
+	Query(q db.TaskQuery) (*db.TaskPage, error)
+	// Words, "phrases" and prefix* searched in the names, slugs, filenames, services and tags
+	Search(text string, limit int) ([]*task.Task, error)
+	// Returns db.ErrDuplicate if another task has the same album or file
+	Update(t *task.Task) error
+
+	// Removals move the tasks to the trash, each call as a single batch
+	RemoveFromID(id string) error
+	RemoveFromIDs(ids []string) (int, error)
+	RemoveFromState(state int) (int, error)
+	RemoveFailedWithErrCategory(c dsdlerr.Category) (int, error)
+	RemoveEnded() (int, error)
+
+	// Returns sql.ErrNoRows if the trash is empty
+	LastTrashBatch() (*db.TrashBatch, error)
+	// Restores the tasks of a batch, leaving in the trash those whose album has been queued in the meantime
+	RestoreBatch(batch string) (int, error)
+	// Returns sql.ErrNoRows if the task isn't trashed, db.ErrDuplicate if its album has been queued in the meantime
+	RestoreTrashed(id string) (*task.Task, error)
+	// Returns sql.ErrNoRows if the task isn't trashed
+	GetTrashed(id string) (*task.Task, error)
+	// Returns the tasks of a batch still in the trash
+	GetTrashBatch(batch string) ([]*task.Task, error)
+	// Same syntax as Search, an empty text matching every trashed task
+	SearchTrash(text string, limit int) ([]*task.Task, error)
+	// Deletes for good the tasks removed before the given time
+	PurgeTrash(before time.Time) (int, error)
+
+	// Marks a failed or canceled task as seen, returning db.ErrNotAcknowledgeable for the other states
+	Acknowledge(id string) error
+	// Moves the successes ended before successBefore (none if zero) and the acknowledged failures to the archive
+	Archive(successBefore time.Time) (int, error)
+	// Returns sql.ErrNoRows if the task isn't archived
+	GetArchived(id string) (*task.Task, error)
+	// Same syntax as Search, an empty text matching every archived task
+	SearchArchive(text string, limit int) ([]*task.Task, error)
+	// Queues an archived task again, returning db.ErrDuplicate if its album has been queued in the meantime
+	Unarchive(id string) (*task.Task, error)
+
+	SetState(t *task.Task, newState int) error
+	AdvanceState(t *task.Task) (int, error)
+	RegressState(t *task.Task) (int, error)
+	ResetState(t *task.Task) (int, error)
+
+	SetPriority(id string, priority int) error
+	SetTags(id string, tags []string) error
+	MoveToTop(id string) error
+	MoveToBottom(id string) error
+	MoveBefore(id, beforeID string) error
+
+	StartAttempt(taskID string, number int) (int64, error)
+	EndAttempt(attemptID int64, attemptErr error) error
+	GetAttempts(taskID string) ([]*task.Attempt, error)
+	RemoveOrphanAttempts() (int, error)
+
+	AddEvent(taskID string, e *task.Event) error
+	GetEvents(taskID string) ([]*task.Event, error)
+	RemoveOrphanEvents() (int, error)
+
+	SaveJournal(j *task.Journal) error
+	GetJournal(taskID string) (*task.Journal, error)
+	GetAllJournals() ([]*task.Journal, error)
+	RemoveJournal(taskID string) error
+	RemoveOrphanJournals() ([]*task.Journal, error)
+}
+
+var (
+	_ TaskStore = (*db.SQLiteDB)(nil)
+	_ TaskStore = (*db.JSONFileDB)(nil)
+)
@@ -0,0 +1,314 @@
+package dsdl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// File format of exported tasks
+type ExportFormat string
+
+const (
+	ExportJSON ExportFormat = "json"
+	ExportCSV  ExportFormat = "csv"
+)
+
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch f := ExportFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return ExportJSON, nil
+	case ExportJSON, ExportCSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("Not a valid export format: %q, expected \"json\" or \"csv\"", s)
+	}
+}
+
+// A task as written by an export. An import only needs the Aggregator and either the Slug or the AggregatorPageURL
+type ExportedTask struct {
+	Aggregator        string
+	Slug              string
+	AggregatorPageURL string
+	FilehostUrl       string
+	DisplayName       string
+	Filename          string
+	State             string
+	Err               string `json:",omitempty"`
+}
+
+// Header of the CSV exports, in the order of the fields of ExportedTask
+var exportColumns = []string{
+	"Aggregator",
+	"Slug",
+	"AggregatorPageURL",
+	"FilehostUrl",
+	"DisplayName",
+	"Filename",
+	"State",
+	"Err",
+}
+
+func NewExportedTask(t *task.Task) ExportedTask {
+	e := ExportedTask{
+		Aggregator:        t.Aggregator,
+		Slug:              t.Slug,
+		AggregatorPageURL: t.AggregatorPageURL,
+		FilehostUrl:       t.FilehostUrl,
+		DisplayName:       t.DisplayName,
+		Filename:          t.Filename,
+		State:             states.GetStateStr(t.DownloadState),
+	}
+
+	if t.Err != nil {
+		e.Err = t.Err.Error()
+	}
+
+	return e
+}
+
+func (e *ExportedTask) row() []string {
+	fields := e.fields()
+	row := make([]string, len(fields))
+
+	for i, f := range fields {
+		row[i] = *f
+	}
+
+	return row
+}
+
+// Writes the tasks in the given format
+func Export(w io.Writer, tasks []*task.Task, format ExportFormat) error {
+	exported := make([]ExportedTask, 0, len(tasks))
+	for _, t := range tasks {
+		exported = append(exported, NewExportedTask(t))
+	}
+
+	switch format {
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(exported)
+
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+
+		if err := cw.Write(exportColumns); err != nil {
+			return err
+		}
+
+		for _, e := range exported {
+			if err := cw.Write(e.row()); err != nil {
+				return err
+			}
+		}
+
+		cw.Flush()
+
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("Not a valid export format: %q", format)
+	}
+}
+
+/*
+Reads the tasks written by Export, telling JSON and CSV apart by their content.
+
+CSV columns are matched by their header, in any order and case, so that files
+edited by hand or made by other tools can be imported too.
+*/
+func ReadExport(r io.Reader) ([]ExportedTask, error) {
+	br := bufio.NewReader(r)
+
+	// skip the byte order mark spreadsheets like to add
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+
+	for {
+		b, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("Nothing to import")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.ContainsRune(" \t\r\n", rune(b)) {
+			continue
+		}
+
+		br.UnreadByte()
+
+		if b == '[' {
+			return readJSONExport(br)
+		}
+
+		return readCSVExport(br)
+	}
+}
+
+func readJSONExport(r io.Reader) ([]ExportedTask, error) {
+	var exported []ExportedTask
+
+	if err := json.NewDecoder(r).Decode(&exported); err != nil {
+		return nil, fmt.Errorf("Not a valid JSON export: %v", err)
+	}
+
+	return exported, nil
+}
+
+func readCSVExport(r io.Reader) ([]ExportedTask, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Not a valid CSV export: %v", err)
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	_, hasAggregator := index["aggregator"]
+	_, hasSlug := index["slug"]
+	_, hasURL := index["aggregatorpageurl"]
+
+	if !hasAggregator || (!hasSlug && !hasURL) {
+		return nil, fmt.Errorf(
+			"Not a valid CSV export: the header needs an Aggregator column and a Slug or AggregatorPageURL one",
+		)
+	}
+
+	exported := make([]ExportedTask, 0)
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Not a valid CSV export: %v", err)
+		}
+
+		var e ExportedTask
+		fields := e.fields()
+
+		for i, name := range exportColumns {
+			if col, ok := index[strings.ToLower(name)]; ok && col < len(record) {
+				*fields[i] = strings.TrimSpace(record[col])
+			}
+		}
+
+		exported = append(exported, e)
+	}
+
+	return exported, nil
+}
+
+// Pointers to the fields of the task, in the order of exportColumns
+func (e *ExportedTask) fields() []*string {
+	return []*string{
+		&e.Aggregator,
+		&e.Slug,
+		&e.AggregatorPageURL,
+		&e.FilehostUrl,
+		&e.DisplayName,
+		&e.Filename,
+		&e.State,
+		&e.Err,
+	}
+}
+
+// What happened to an imported task
+type ImportStatus string
+
+const (
+	ImportInserted          ImportStatus = "inserted"
+	ImportDuplicate         ImportStatus = "duplicate"
+	ImportInvalidAggregator ImportStatus = "invalid-aggregator"
+	// The task has no slug or an invalid one, or it couldn't be stored
+	ImportInvalid ImportStatus = "invalid"
+)
+
+type ImportResult struct {
+	// Position of the task in the imported file, starting from 1
+	Row        int
+	Aggregator string
+	Slug       string
+	Status     ImportStatus
+	// ID of the created task
+	ID string `json:",omitempty"`
+	// ID of the task already storing the album of a duplicate
+	ExistingID string `json:",omitempty"`
+	Err        string `json:",omitempty"`
+}
+
+/*
+Queues the exported tasks again, validating each of them just like the albums added
+by the users. Their state, error and filename are not imported: they start over.
+
+Returns the outcome of every task, in the same order
+*/
+func Import(store TaskStore, aggregators Aggregators, exported []ExportedTask) []ImportResult {
+	results := make([]ImportResult, 0, len(exported))
+
+	for i, e := range exported {
+		slug := e.Slug
+		if strings.TrimSpace(slug) == "" {
+			slug = e.AggregatorPageURL
+		}
+
+		res := ImportResult{
+			Row:        i + 1,
+			Aggregator: e.Aggregator,
+			Slug:       slug,
+			Status:     ImportInserted,
+		}
+
+		t, err := AddTask(store, aggregators, e.Aggregator, slug)
+
+		switch {
+		case err == nil:
+			res.ID = t.Id
+		case errors.Is(err, ErrDuplicateTask):
+			res.Status = ImportDuplicate
+
+			var dup *DuplicateError
+			if errors.As(err, &dup) && dup.Existing != nil {
+				res.ExistingID = dup.Existing.Id
+			}
+		case errors.Is(err, ErrInvalidAggregator):
+			res.Status = ImportInvalidAggregator
+		default:
+			res.Status = ImportInvalid
+		}
+
+		if err != nil {
+			res.Err = err.Error()
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// Queues the exported tasks again, see Import
+func (dsdl *DSDL) Import(exported []ExportedTask) []ImportResult {
+	return Import(dsdl.db, dsdl.aggregators, exported)
+}
@@ -0,0 +1,120 @@
+package dsdl
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	done := task.NewTask("22816")
+	done.Aggregator = "doujinstyle"
+	done.FilehostUrl = "https://mega.nz/file/1"
+	done.DisplayName = `Circle, "Album"`
+	done.Filename = "album.zip"
+	done.Err = dsdlerr.New(dsdlerr.Network, "timeout")
+
+	queued := task.NewTask("https://sukidesuost.info/album")
+	queued.Aggregator = "sukidesuost"
+	queued.AggregatorPageURL = queued.Slug
+
+	for _, format := range []ExportFormat{ExportJSON, ExportCSV} {
+		var buf bytes.Buffer
+
+		if err := Export(&buf, []*task.Task{done, queued}, format); err != nil {
+			t.Fatal(err)
+		}
+
+		exported, err := ReadExport(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		expected := []ExportedTask{NewExportedTask(done), NewExportedTask(queued)}
+
+		if len(exported) != len(expected) {
+			t.Fatalf("%s: expected %d tasks, got %d", format, len(expected), len(exported))
+		}
+
+		for i := range expected {
+			if exported[i] != expected[i] {
+				t.Errorf("%s: expected %+v, got %+v", format, expected[i], exported[i])
+			}
+		}
+	}
+}
+
+func TestReadHandMadeCSV(t *testing.T) {
+	exported, err := ReadExport(strings.NewReader(
+		"\xef\xbb\xbfslug, AGGREGATOR\n22816,doujinstyle\n\"https://x/y\", sukidesuost, extra\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exported) != 2 || exported[0].Slug != "22816" || exported[1].Aggregator != "sukidesuost" {
+		t.Errorf("Unexpected tasks: %+v", exported)
+	}
+
+	for _, input := range []string{"", "  \n", "slug\n1\n", "[{"} {
+		if _, err := ReadExport(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	store := db.NewJSONFile(filepath.Join(t.TempDir(), "tasks.json"))
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	aggregators := Aggregators{{Name: "doujinstyle"}}
+
+	results := Import(store, aggregators, []ExportedTask{
+		{Aggregator: "doujinstyle", Slug: "22816", State: "Failed"},
+		{Aggregator: "doujinstyle", Slug: "22816"},
+		{Aggregator: "unknown", Slug: "1"},
+		{Aggregator: "doujinstyle"},
+		{Aggregator: "doujinstyle", AggregatorPageURL: "https://doujinstyle.com/?p=page&type=1&id=2"},
+	})
+
+	expected := []ImportStatus{
+		ImportInserted,
+		ImportDuplicate,
+		ImportInvalidAggregator,
+		ImportInvalid,
+		ImportInserted,
+	}
+
+	for i, res := range results {
+		if res.Row != i+1 || res.Status != expected[i] {
+			t.Errorf("Row %d: expected %s, got %+v", i+1, expected[i], res)
+		}
+	}
+
+	tasks, err := store.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tasks) != 2 {
+		t.Fatalf("Expected 2 stored tasks, got %d", len(tasks))
+	}
+
+	// imported tasks start over, whatever their exported state
+	if tasks[0].Id != results[0].ID || tasks[0].Err != nil || tasks[0].DownloadState != states.TASK_STATE_QUEUED {
+		t.Errorf("Unexpected first task: %+v", tasks[0])
+	}
+
+	if tasks[1].AggregatorPageURL == "" {
+		t.Error("Expected the URL of the album page to be kept")
+	}
+}
@@ -1 +1,74 @@
 package dsdl
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+)
+
+// Removes every file inside the temp directory that is not claimed by the
+// download journal of a stored or trashed task, along with the directories left empty.
+//
+// Partial downloads of restored tasks are kept, so that they can be resumed, and so
+// are the ones of trashed tasks, which an undo brings back
+func (dsdl *DSDL) PruneTempDir(dir string) error {
+	if !appUtils.DirectoryExists(dir) {
+		return nil
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	journals, err := dsdl.db.GetAllJournals()
+	if err != nil {
+		return err
+	}
+
+	isClaimed := func(path string) bool {
+		for _, j := range journals {
+			if appUtils.IsPartialDownloadFile(path, j.TempFilepath) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var dirs []string
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != root {
+				dirs = append(dirs, path)
+			}
+
+			return nil
+		}
+
+		if isClaimed(path) {
+			return nil
+		}
+
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	// deepest directories first, non-empty ones are simply left in place
+	slices.Reverse(dirs)
+	for _, d := range dirs {
+		os.Remove(d)
+	}
+
+	return nil
+}
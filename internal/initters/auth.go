@@ -0,0 +1,90 @@
+package initters
+
+import (
+	"log"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/auth"
+	"github.com/relepega/doujinstyle-downloader/internal/configManager"
+)
+
+// Name of the user whose password is set by the "passwd" command
+const AdminUser = "admin"
+
+// Returns the users of the config, skipping the ones that can't be used
+func authUsers(cfg *configManager.Config) []auth.User {
+	var users []auth.User
+
+	if cfg.Auth.PasswordHash != "" {
+		users = append(users, auth.User{Name: AdminUser, PasswordHash: cfg.Auth.PasswordHash, Role: auth.RoleAdmin})
+	}
+
+	for _, u := range cfg.Auth.Users {
+		role, err := auth.ParseRole(u.Role)
+		if err != nil {
+			log.Printf("Auth: Ignoring the user %q: %v\n", u.Name, err)
+			continue
+		}
+
+		if u.Name == AdminUser && cfg.Auth.PasswordHash != "" {
+			log.Printf("Auth: Ignoring the user %q, whose password is set by the \"passwd\" command\n", u.Name)
+			continue
+		}
+
+		users = append(users, auth.User{Name: u.Name, PasswordHash: u.PasswordHash, Role: role})
+	}
+
+	return users
+}
+
+// Returns the authenticator set up by the config, saving the tokens created or revoked to the config file
+func InitAuth(cfg *configManager.Config) *auth.Authenticator {
+	tokens := make([]auth.Token, 0, len(cfg.Auth.Tokens))
+
+	for _, t := range cfg.Auth.Tokens {
+		// tokens created before roles existed had full access
+		role := auth.RoleAdmin
+
+		if t.Role != "" {
+			var err error
+
+			role, err = auth.ParseRole(t.Role)
+			if err != nil {
+				log.Printf("Auth: Ignoring the token %q: %v\n", t.Name, err)
+				continue
+			}
+		}
+
+		tokens = append(tokens, auth.Token{Name: t.Name, Hash: t.Hash, Role: role, CreatedAt: t.CreatedAt})
+	}
+
+	a := auth.New(auth.Options{
+		Enabled:    cfg.Auth.Enabled,
+		Users:      authUsers(cfg),
+		Tokens:     tokens,
+		SessionTTL: time.Duration(cfg.Auth.SessionHours) * time.Hour,
+		SaveTokens: func(tokens []auth.Token) error {
+			cfg.Auth.Tokens = make([]configManager.APIToken, 0, len(tokens))
+
+			for _, t := range tokens {
+				cfg.Auth.Tokens = append(cfg.Auth.Tokens, configManager.APIToken{
+					Name:      t.Name,
+					Hash:      t.Hash,
+					Role:      t.Role.String(),
+					CreatedAt: t.CreatedAt,
+				})
+			}
+
+			return cfg.Save()
+		},
+	})
+
+	switch {
+	case !a.Enabled():
+		log.Println("Auth: Disabled, anyone reaching the webserver can manage the queue")
+	case !a.HasUsers():
+		log.Println("Auth: No user can log in to the web UI. Set the admin password with the \"passwd\" command")
+	}
+
+	return a
+}
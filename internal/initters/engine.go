@@ -1,9 +1,15 @@
 package initters
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/playwright-community/playwright-go"
@@ -13,12 +19,38 @@ import (
 	"github.com/relepega/doujinstyle-downloader/internal/downloader/aggregators"
 	"github.com/relepega/doujinstyle-downloader/internal/downloader/filehosts"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
 	"github.com/relepega/doujinstyle-downloader/internal/playwrightWrapper"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 	pubsub "github.com/relepega/doujinstyle-downloader/internal/pubSub"
 	"github.com/relepega/doujinstyle-downloader/internal/task"
 )
 
+const (
+	// Each task sends at most one progress update to the UI every progressInterval
+	progressInterval = 250 * time.Millisecond
+	// Downloaded bytes are added to the task timeline at most once every bytesEventInterval
+	bytesEventInterval = 30 * time.Second
+)
+
+// Returns the supported aggregators
+func Aggregators() dsdl.Aggregators {
+	return dsdl.Aggregators{
+		{
+			Name:         "doujinstyle",
+			Constructor:  aggregators.NewDoujinstyle,
+			Canonicalize: aggregators.DoujinstyleID,
+		},
+		{
+			Name:         "sukidesuost",
+			Constructor:  aggregators.NewSukiDesuOst,
+			Canonicalize: aggregators.SukiDesuOstID,
+		},
+	}
+}
+
 func InitEngine(cfg *configManager.Config) *dsdl.DSDL {
 	log.Println("Engine: Starting playwright")
 	pww, err := playwrightWrapper.UsePlaywright(
@@ -35,45 +67,54 @@ func InitEngine(cfg *configManager.Config) *dsdl.DSDL {
 	log.Println("Engine: Playwright started without errors")
 
 	log.Println("Engine: Initializing DSDL instance")
-	engine := dsdl.NewDSDL(pww.Browser)
+	engine := dsdl.NewDSDL(pww.Browser, InitStore(cfg))
 
-	engine.RegisterAggregator(&dsdl.Aggregator{
-		Name:        "doujinstyle",
-		Constructor: aggregators.NewDoujinstyle,
+	engine.SetRetryPolicy(dsdl.RetryPolicy{
+		MaxAttempts: cfg.Download.Retry.MaxAttempts,
+		BaseDelay:   time.Duration(cfg.Download.Retry.BaseDelay) * time.Second,
+		MaxDelay:    time.Duration(cfg.Download.Retry.MaxDelay) * time.Second,
+		Jitter:      cfg.Download.Retry.Jitter,
 	})
 
-	engine.RegisterAggregator(&dsdl.Aggregator{
-		Name:        "sukidesuost",
-		Constructor: aggregators.NewSukiDesuOst,
+	engine.SetRetentionPolicy(dsdl.RetentionPolicy{
+		KeepSucceeded: time.Duration(cfg.Retention.SucceededDays) * 24 * time.Hour,
+		KeepTrashed:   time.Duration(cfg.Retention.TrashDays) * 24 * time.Hour,
 	})
 
+	for _, a := range Aggregators() {
+		engine.RegisterAggregator(a)
+	}
+
 	engine.RegisterFilehost(&dsdl.Filehost{
 		Name:                "Mediafire",
 		AllowedUrlWildcards: []string{"www.mediafire.com"},
 		Constructor:         filehosts.NewMediafire,
+		FileID:              filehosts.MediafireFileID,
 	})
 
 	engine.RegisterFilehost(&dsdl.Filehost{
 		Name:                "Mega",
 		AllowedUrlWildcards: []string{"mega.nz"},
 		Constructor:         filehosts.NewMega,
+		FileID:              filehosts.MegaFileID,
 	})
 
 	engine.RegisterFilehost(&dsdl.Filehost{
 		Name:                "Google Drive",
 		AllowedUrlWildcards: []string{"drive.google.com"},
 		Constructor:         filehosts.NewGDrive,
+		FileID:              filehosts.GDriveFileID,
 	})
 
 	engine.RegisterFilehost(&dsdl.Filehost{
 		Name:                "Jottacloud",
 		AllowedUrlWildcards: []string{"jottacloud.com"},
 		Constructor:         filehosts.NewJottacloud,
+		FileID:              filehosts.JottacloudFileID,
 	})
 
-	err = engine.DB().Open()
-	if err != nil {
-		log.Fatalln(err)
+	if err := engine.BackfillAlbumIDs(); err != nil {
+		log.Println("Engine: Couldn't set the album ID of the stored tasks:", err)
 	}
 
 	log.Println("Engine: DSDL initialized")
@@ -88,6 +129,14 @@ func InitEngine(cfg *configManager.Config) *dsdl.DSDL {
 // 	}
 // }
 
+/*
+Starts the queued tasks, running at most cfg.Download.ConcurrentJobs of them at once.
+
+The runner sleeps until it is woken up by the engine (a task has been queued, a slot
+has been freed or the queue has been resumed) or until the retry backoff of a task
+expires. Closing stop shuts down the active tasks, and QueueRunner returns only after
+all of them have stopped.
+*/
 func QueueRunner(
 	engine *dsdl.DSDL,
 	cfg *configManager.Config,
@@ -97,70 +146,136 @@ func QueueRunner(
 
 	maxJobs := int(cfg.Download.ConcurrentJobs)
 
+	abs_downloadDir, _ := filepath.Abs(cfg.Download.Directory)
+	abs_tempDir, _ := filepath.Abs(cfg.Download.Tempdir)
+
+	timeout := time.Duration(cfg.Download.Timeout) * time.Second
+
+	publisher, err := pubsub.GetGlobalPublisher("task-updater")
+	if err != nil {
+		publisher = pubsub.NewGlobalPublisher("task-updater")
+	}
+
+	// progress updates are frequent and only the latest one matters:
+	// rate-limit them so that downloads never wait for the UI
+	progressPub := pubsub.NewCoalescer(publisher, progressInterval)
+	defer progressPub.Close()
+
 	db := engine.DB()
 
-	var activeTasks []*task.Task
+	var wg sync.WaitGroup
+
+	retryTimer := time.NewTimer(0)
+	<-retryTimer.C
 
 	for {
+		// start as many tasks as there are free slots
+		for !engine.IsQueuePaused() && engine.ActiveCount() < maxJobs {
+			t, err := engine.ClaimNext(time.Now())
+			if err != nil {
+				// nothing to start right now
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("QueueRunner: Couldn't activate the next task: %v\n", err)
+				}
+
+				break
+			}
+
+			log.Printf("QueueRunner: Activated task with ID %v\n", t.Id)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// the slot is free again
+				defer engine.Wake()
+
+				taskRunner(engine, t, progressPub, abs_downloadDir, abs_tempDir, timeout)
+			}()
+		}
+
+		// sleep until the first retry backoff expires
+		retryTimer.Stop()
+
+		nextRetry, err := db.GetNextRetryAt(time.Now())
+		if err == nil && !nextRetry.IsZero() {
+			retryTimer.Reset(time.Until(nextRetry))
+		}
+
 		select {
 		case <-stop:
 			log.Println("QueueRunner: Stopping runner and active tasks")
-			for _, t := range activeTasks {
+
+			for _, t := range engine.ActiveTasks() {
 				t.Shutdown()
 			}
 
 			// make main wait for tasks to stop so that when closing the database we won't lose any data
+			wg.Wait()
+
 			log.Println("QueueRunner: All tasks stopped")
 
 			return nil
 
-		default:
-			if db.CountFromStateNoErr(states.TASK_STATE_QUEUED) <= 0 ||
-				db.CountFromStateNoErr(states.TASK_STATE_RUNNING) == maxJobs {
-				time.Sleep(250 * time.Millisecond)
-				continue
-			}
-
-			log.Println("QueueRunner: Dequeuing task")
-
-			t, err := db.GetFromState(states.TASK_STATE_QUEUED)
-			if err != nil {
-				continue
-			}
-
-			log.Printf("QueueRunner: Activating task with ID %v\n", t.Id)
+		case <-engine.WakeChan():
+		case <-retryTimer.C:
+		}
+	}
+}
 
-			_, err = db.AdvanceState(t)
-			if err != nil {
-				continue
-			}
+// Appends an event to the timeline of a task. Failures are only logged, as the timeline is informative
+func recordEvent(engine *dsdl.DSDL, t *task.Task, kind task.EventKind, msg string, bytes int64) {
+	err := engine.DB().AddEvent(t.Id, &task.Event{
+		Kind:    kind,
+		Message: msg,
+		Bytes:   bytes,
+	})
+	if err != nil {
+		log.Printf("TaskRunner: Couldn't record the %s event of task %v: %v\n", kind, t.Id, err)
+	}
+}
 
-			// swap any completed task if array is full
-			if len(activeTasks) < maxJobs {
-				activeTasks = append(activeTasks, t)
-			} else {
-				for i, v := range activeTasks {
-					if v.DownloadState == states.TASK_STATE_COMPLETED {
-						activeTasks[i] = t
-						break
-					}
-				}
-			}
+/*
+Saves the task of a runner, only logging the failures: the task may have been aborted,
+paused or removed in the meantime, and a refused state change must not take the
+whole server down
+*/
+func saveTask(engine *dsdl.DSDL, t *task.Task) {
+	err := engine.DB().Update(t)
+	if errors.Is(err, db.ErrIllegalTransition) {
+		log.Printf("TaskRunner: Task %v changed in the meantime, dropping its update: %v\n", t.Id, err)
+		return
+	}
+	if err != nil {
+		log.Printf("TaskRunner: Error while updating task %v in DB: %v\n", t.Id, err)
+	}
+}
 
-			abs_downloadDir, _ := filepath.Abs(cfg.Download.Directory)
-			abs_tempDir, _ := filepath.Abs(cfg.Download.Tempdir)
+// Returns the state a task ends in after failing with err
+func endState(err error) int {
+	if err == nil {
+		return states.TASK_STATE_COMPLETED
+	}
 
-			go taskRunner(engine, t, abs_downloadDir, abs_tempDir)
-		}
+	switch dsdlerr.CategoryOf(err) {
+	case dsdlerr.Aborted:
+		return states.TASK_STATE_CANCELED
+	case dsdlerr.Duplicate:
+		return states.TASK_STATE_SKIPPED
+	default:
+		return states.TASK_STATE_FAILED
 	}
 }
 
 func taskRunner(
 	engine *dsdl.DSDL,
 	t *task.Task,
+	progressPub *pubsub.Coalescer,
 	downloadDir string,
 	tempDir string,
+	timeout time.Duration,
 ) {
+	defer engine.UntrackTask(t)
+
 	var bwContext playwright.BrowserContext
 	var publisher *pubsub.Publisher
 
@@ -169,243 +284,434 @@ func taskRunner(
 		publisher = pubsub.NewGlobalPublisher("task-updater")
 	}
 
-	markCompleted := func() {
-		log.Printf("TaskRunner: Marking task %v as complete\n", t.Id)
+	// every run of the task is recorded as a new attempt
+	t.Attempts++
+	t.NextRetryAt = time.Time{}
+
+	if t.StartedAt.IsZero() {
+		t.StartedAt = time.Now()
+	}
+
+	attemptID, err := engine.DB().StartAttempt(t.Id, t.Attempts)
+	if err != nil {
+		log.Printf("TaskRunner: Couldn't record the attempt of task %v: %v\n", t.Id, err)
+	}
 
+	saveTask(engine, t)
+
+	recordEvent(engine, t, task.EventStarted, fmt.Sprintf("attempt %d", t.Attempts), 0)
+
+	markCompleted := func() {
 		if bwContext != nil {
 			bwContext.Close()
 		}
 
-		t.DownloadState = states.TASK_STATE_COMPLETED
+		// a late progress update must not overwrite the final state
+		progressPub.Discard(t.Id)
 
-		err := engine.DB().Update(t)
+		err := engine.DB().EndAttempt(attemptID, t.Err)
 		if err != nil {
-			log.Fatalf("TaskRunner: Error while updating task in DB: %v", err)
+			log.Printf("TaskRunner: Couldn't record the attempt of task %v: %v\n", t.Id, err)
+		}
+
+		policy := engine.RetryPolicy()
+
+		if t.Err != nil && t.ErrCategory().Retryable() && policy.CanRetry(t.Attempts) {
+			delay := policy.Delay(t.Attempts)
+
+			log.Printf(
+				"TaskRunner: Attempt %d/%d of task %v failed, retrying in %v\n",
+				t.Attempts,
+				policy.MaxAttempts,
+				t.Id,
+				delay.Round(time.Second),
+			)
+
+			t.DownloadState = states.TASK_STATE_QUEUED
+			t.NextRetryAt = time.Now().Add(delay)
+
+			saveTask(engine, t)
+
+			recordEvent(
+				engine,
+				t,
+				task.EventRetryScheduled,
+				fmt.Sprintf("%v, retrying in %v", t.Err, delay.Round(time.Second)),
+				t.Progress.BytesDone,
+			)
+
+			publisher.Publish(&pubsub.PublishEvent{
+				EvtType: "requeue-task",
+				Data:    t.Snapshot(),
+			})
+
+			return
+		}
+
+		t.DownloadState = endState(t.Err)
+		t.FinishedAt = time.Now()
+
+		log.Printf(
+			"TaskRunner: Marking task %v as %s\n",
+			t.Id,
+			strings.ToLower(states.GetStateStr(t.DownloadState)),
+		)
+
+		saveTask(engine, t)
+
+		msg := states.GetStateStr(t.DownloadState)
+		if t.Err != nil {
+			msg = fmt.Sprintf("%s: %v", msg, t.Err)
 		}
 
+		recordEvent(engine, t, task.EventFinished, msg, t.Progress.BytesDone)
+
 		publisher.Publish(&pubsub.PublishEvent{
 			EvtType: "mark-task-as-done",
-			Data:    t,
+			Data:    t.Snapshot(),
 		})
 	}
 
+	aggConstFn, err := engine.EvaluateAggregator(t.Aggregator)
+	if err != nil {
+		t.Err = err
+		markCompleted()
+		return
+	}
+
+	bwContext, err = engine.Browser().NewContext()
+	if err != nil {
+		t.Err = fmt.Errorf("Playwright: Cannot open new browser context")
+		markCompleted()
+		return
+	}
+	defer bwContext.Close()
+
 	publisher.Publish(&pubsub.PublishEvent{
 		EvtType: "activate-task",
-		Data:    t,
+		Data:    t.Snapshot(),
 	})
 
-	running := false
+	ctx, release := t.Start(context.Background())
+	defer release()
 
-	for {
-		select {
-		case msg := <-t.Stop:
-			if msg == "user-abort" {
-				t.Err = fmt.Errorf("Task aborted by user")
-				markCompleted()
+	if timeout > 0 {
+		var cancel context.CancelFunc
 
-				return
-			}
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, dsdlerr.ErrTimeout)
+		defer cancel()
+	}
 
-			if msg == "shutdown" {
-				log.Printf("TaskRunner: Marking task as aborted (server shutdown) (ID: %v)\n", t.Id)
-
-				if bwContext != nil {
-					err := bwContext.Close()
-					if err != nil {
-						log.Printf(
-							"TaskRunner: An error occurred while stopping task ID %v: %v",
-							t.Id,
-							err,
-						)
-					}
-				}
+	// closing the browser context interrupts any pending playwright call or download right away
+	stopBrowser := context.AfterFunc(ctx, func() { bwContext.Close() })
+	defer stopBrowser()
+
+	err = processTask(
+		ctx,
+		engine,
+		t,
+		aggConstFn,
+		bwContext,
+		progressPub,
+		downloadDir,
+		tempDir,
+	)
 
-				engine.DB().Update(t)
+	// a task that managed to finish before being stopped is still done
+	cause := dsdlerr.FromContext(ctx)
+	if err == nil || cause == nil {
+		t.Err = err
+		markCompleted()
+		return
+	}
 
-				publisher.Publish(&pubsub.PublishEvent{
-					EvtType: "mark-task-as-done",
-					Data:    t,
-				})
+	switch {
+	case errors.Is(cause, dsdlerr.ErrPaused):
+		log.Printf("TaskRunner: Pausing task %v\n", t.Id)
 
-				return
-			}
+		progressPub.Discard(t.Id)
 
-		default:
-			if running {
-				continue
-			}
+		// the paused run doesn't count as a failed attempt
+		engine.DB().EndAttempt(attemptID, cause)
+		t.Attempts--
 
-			// mark running, so that we don't end with a memory leak :)
-			running = true
+		t.DownloadState = states.TASK_STATE_PAUSED
+		bytesDone := t.Progress.BytesDone
+		t.SetPhase(progress.Idle)
 
-			// process the task
-			aggConstFn, err := engine.EvaluateAggregator(t.Aggregator)
-			if err != nil {
-				t.Err = err
-				markCompleted()
-				return
-			}
+		saveTask(engine, t)
 
-			bwContext, err = engine.Browser().NewContext()
-			if err != nil {
-				t.Err = fmt.Errorf("Playwright: Cannot open new browser context")
-				markCompleted()
-				return
-			}
-			defer bwContext.Close()
+		recordEvent(engine, t, task.EventPaused, "", bytesDone)
 
-			p, err := bwContext.NewPage()
-			if err != nil {
-				t.Err = fmt.Errorf("Playwright: Cannot open new browser context page")
-				markCompleted()
-				return
-			}
-			defer p.Close()
+		publisher.Publish(&pubsub.PublishEvent{
+			EvtType: "requeue-task",
+			Data:    t.Snapshot(),
+		})
 
-			aggregator := aggConstFn(t.Slug, p)
+	case errors.Is(cause, dsdlerr.ErrShutdown):
+		log.Printf("TaskRunner: Marking task as aborted (server shutdown) (ID: %v)\n", t.Id)
 
-			t.AggregatorPageURL = aggregator.Url()
+		progressPub.Discard(t.Id)
 
-			_, err = p.Goto(aggregator.Url())
-			// check internet connection
-			if err != nil {
-				t.Err = err
-				markCompleted()
-				return
-			}
+		// the interrupted run doesn't count as a failed attempt
+		engine.DB().EndAttempt(attemptID, cause)
+		t.Attempts--
 
-			t.Slug = aggregator.Slug()
+		saveTask(engine, t)
 
-			// check if page is actually not deleted
-			is404, err := aggregator.Is404()
-			if err != nil {
-				t.Err = err
-				markCompleted()
-				return
-			}
-			if is404 {
-				t.Err = fmt.Errorf(
-					"Aggregator: The requested page has been taken down or is invalid",
-				)
-				markCompleted()
-				return
-			}
+		recordEvent(engine, t, task.EventInterrupted, "server shutdown", t.Progress.BytesDone)
 
-			// evaluate displayName filename
-			fname, err := aggregator.EvaluateFileName()
-			if fname != "" {
-				t.DisplayName = fname
-			}
+		publisher.Publish(&pubsub.PublishEvent{
+			EvtType: "mark-task-as-done",
+			Data:    t.Snapshot(),
+		})
 
-			publisher.Publish(&pubsub.PublishEvent{
-				EvtType: "update-node-content",
-				Data:    t,
-			})
-			engine.DB().Update(t)
+	default:
+		// aborted by the user or timed out
+		t.Err = cause
+		markCompleted()
+	}
+}
 
-			// get download page
-			dlPage, err := aggregator.EvaluateDownloadPage()
-			if err != nil {
-				t.Err = err
-				markCompleted()
-				return
-			}
-			defer dlPage.Close()
+// Downloads the album of a task, returning the error that made it fail.
+//
+// Cancelling ctx stops the download keeping its partial data: the reason is
+// available through context.Cause
+func processTask(
+	ctx context.Context,
+	engine *dsdl.DSDL,
+	t *task.Task,
+	aggConstFn dsdl.AggregatorConstrFn,
+	bwContext playwright.BrowserContext,
+	progressPub *pubsub.Coalescer,
+	downloadDir string,
+	tempDir string,
+) error {
+	p, err := bwContext.NewPage()
+	if err != nil {
+		return fmt.Errorf("Playwright: Cannot open new browser context page")
+	}
+	defer p.Close()
 
-			// parse a filehost downloader
-			filehostConstructor, err := engine.EvaluateFilehost(dlPage.URL())
-			if err != nil {
-				t.Err = err
-				markCompleted()
-				return
-			}
-			filehost := filehostConstructor(dlPage)
+	aggregator := aggConstFn(t.Slug, p)
 
-			t.FilehostUrl = filehost.Page().URL()
+	t.AggregatorPageURL = aggregator.Url()
 
-			// evaluate final filename
-			if fname == "" {
-				fname, err = filehost.EvaluateFileName()
-				if err != nil {
-					t.Err = fmt.Errorf("TaskRunner: Couldn't evaluate the filename")
-					markCompleted()
-					return
-				}
+	t.SetPhase(progress.LoadingAggregator)
+	progressPub.Publish(t.Id, &pubsub.PublishEvent{
+		EvtType: "update-node-content",
+		Data:    t.Snapshot(),
+	})
 
-				// setting the filename only if it is stil not set
-				t.DisplayName = fname
-			}
+	_, err = p.Goto(aggregator.Url())
+	// check internet connection
+	if err != nil {
+		return dsdlerr.Wrap(dsdlerr.Network, err)
+	}
 
-			fext, err := aggregator.EvaluateFileExt()
-			if err != nil {
-				fext, err = filehost.EvaluateFileExt()
-				if err != nil {
+	t.Slug = aggregator.Slug()
 
-					t.Err = fmt.Errorf("TaskRunner: Couldn't evaluate the file extension")
-					markCompleted()
-					return
-				}
-			}
+	recordEvent(engine, t, task.EventPageLoaded, t.AggregatorPageURL, 0)
 
-			// re-check if task is already done by other means
-			found, _, _ := engine.DB().Find(t.DisplayName)
-			if found {
-				t.SetErrMsg("This task is already present in the database")
-				markCompleted()
-				return
-			}
+	// check if page is actually not deleted
+	is404, err := aggregator.Is404(ctx)
+	if err != nil {
+		return err
+	}
+	if is404 {
+		return dsdlerr.New(
+			dsdlerr.NotFound,
+			"Aggregator: The requested page has been taken down or is invalid",
+		)
+	}
 
-			engine.DB().Update(t)
-			publisher.Publish(&pubsub.PublishEvent{
-				EvtType: "update-node-content",
-				Data:    t,
-			})
+	// evaluate displayName filename
+	fname, err := aggregator.EvaluateFileName(ctx)
+	if fname != "" {
+		t.DisplayName = fname
+	}
 
-			// check if out dirs exist
-			if !appUtils.DirectoryExists(downloadDir) {
-				err := appUtils.MkdirAll(downloadDir)
-				if err != nil {
-					log.Fatalln("taskRunner.DirCheck:", err)
-				}
-			}
+	t.SetPhase(progress.ResolvingFilehost)
+	progressPub.Publish(t.Id, &pubsub.PublishEvent{
+		EvtType: "update-node-content",
+		Data:    t.Snapshot(),
+	})
 
-			if !appUtils.DirectoryExists(tempDir) {
-				err := appUtils.MkdirAll(tempDir)
-				if err != nil {
-					log.Fatalln("taskRunner.DirCheck:", err)
-				}
-			}
+	// refused if the task has been stopped or removed in the meantime
+	if err := engine.DB().Update(t); err != nil {
+		return fmt.Errorf("TaskRunner: Couldn't save the task: %w", err)
+	}
 
-			// download the file into temp
-			var fullFilename string
+	// get download page
+	dlPage, err := aggregator.EvaluateDownloadPage(ctx)
+	if err != nil {
+		return err
+	}
+	defer dlPage.Close()
 
-			if fext == "" {
-				fullFilename = fname
-			} else {
-				fullFilename = fmt.Sprintf("%s.%s", fname, fext)
-			}
+	// parse a filehost downloader
+	filehostConstructor, err := engine.EvaluateFilehost(dlPage.URL())
+	if err != nil {
+		return err
+	}
+	filehost := filehostConstructor(dlPage)
 
-			updateHandler := func(prog int8) {
-				t.SetProgress(prog)
+	t.FilehostUrl = filehost.Page().URL()
 
-				// fmt.Println("downloading (", prog, "%)", t.DisplayName)
+	recordEvent(engine, t, task.EventFilehostResolved, t.FilehostUrl, 0)
 
-				publisher.Publish(&pubsub.PublishEvent{
-					EvtType: "update-node-content",
-					Data:    t,
-				})
-			}
+	// evaluate final filename
+	if fname == "" {
+		fname, err = filehost.EvaluateFileName(ctx)
+		if err != nil {
+			return dsdlerr.Wrap(
+				dsdlerr.SelectorBroken,
+				fmt.Errorf("TaskRunner: Couldn't evaluate the filename: %w", err),
+			)
+		}
 
-			err = filehost.Download(tempDir, downloadDir, fullFilename, updateHandler)
-			if err != nil {
-				t.SetErr(err)
-				markCompleted()
-				return
-			}
+		// setting the filename only if it is stil not set
+		t.DisplayName = fname
+	}
 
-			// task done :)
-			markCompleted()
-			return
+	fext, err := aggregator.EvaluateFileExt(ctx)
+	if err != nil {
+		fext, err = filehost.EvaluateFileExt(ctx)
+		if err != nil {
+			return dsdlerr.Wrap(
+				dsdlerr.SelectorBroken,
+				fmt.Errorf("TaskRunner: Couldn't evaluate the file extension: %w", err),
+			)
+		}
+	}
+
+	// the same file may already be stored by a task of another album or aggregator
+	t.Filehost, t.FileID = engine.FileIdentity(t.FilehostUrl)
+	if t.FileID != "" {
+		other, err := engine.DB().GetByFile(t.Filehost, t.FileID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// no other task stores this file
+		case err != nil:
+			return fmt.Errorf("TaskRunner: Couldn't look for tasks storing the same file: %w", err)
+		case other.Id != t.Id:
+			t.Filehost, t.FileID = "", ""
+			return dsdlerr.New(dsdlerr.Duplicate, "This file has already been added by task %s", other.Id)
 		}
 	}
+
+	err = engine.DB().Update(t)
+	if errors.Is(err, dsdl.ErrDuplicateTask) {
+		t.Filehost, t.FileID = "", ""
+		return dsdlerr.New(dsdlerr.Duplicate, "This file has already been added by another task")
+	}
+	if err != nil {
+		return fmt.Errorf("TaskRunner: Couldn't save the filehost of the task: %w", err)
+	}
+	progressPub.Publish(t.Id, &pubsub.PublishEvent{
+		EvtType: "update-node-content",
+		Data:    t.Snapshot(),
+	})
+
+	// check if out dirs exist
+	if !appUtils.DirectoryExists(downloadDir) {
+		err := appUtils.MkdirAll(downloadDir)
+		if err != nil {
+			log.Fatalln("taskRunner.DirCheck:", err)
+		}
+	}
+
+	if !appUtils.DirectoryExists(tempDir) {
+		err := appUtils.MkdirAll(tempDir)
+		if err != nil {
+			log.Fatalln("taskRunner.DirCheck:", err)
+		}
+	}
+
+	// download the file into temp
+	var fullFilename string
+
+	if fext == "" {
+		fullFilename = fname
+	} else {
+		fullFilename = fmt.Sprintf("%s.%s", fname, fext)
+	}
+
+	updateHandler := func(prog progress.Progress) {
+		t.SetProgress(prog)
+
+		progressPub.Publish(t.Id, &pubsub.PublishEvent{
+			EvtType: "update-node-content",
+			Data:    t.Snapshot(),
+		})
+	}
+
+	// partial data is kept under a stable per-task directory,
+	// so that a retried download can resume where it stopped
+	taskTempDir := filepath.Join(tempDir, t.Id)
+
+	journal, err := engine.DB().GetJournal(t.Id)
+	if err != nil {
+		log.Printf("TaskRunner: Couldn't read the journal of task %v: %v\n", t.Id, err)
+	}
+
+	if journal != nil {
+		if journal.FilehostUrl == t.FilehostUrl {
+			log.Printf(
+				"TaskRunner: Resuming task %v from %d bytes\n",
+				t.Id,
+				journal.BytesWritten,
+			)
+		} else {
+			// the album points to another file now, the partial data is useless
+			os.RemoveAll(taskTempDir)
+			engine.DB().RemoveJournal(t.Id)
+		}
+	}
+
+	// the download started event already holds the initial amount of bytes
+	lastBytesEvent := time.Now()
+
+	checkpointHandler := func(pd *appUtils.PartialDownload) {
+		if time.Since(lastBytesEvent) >= bytesEventInterval {
+			recordEvent(engine, t, task.EventBytes, "", pd.BytesWritten)
+			lastBytesEvent = time.Now()
+		}
+
+		err := engine.DB().SaveJournal(&task.Journal{
+			TaskID:       t.Id,
+			TempFilepath: pd.TempFilepath,
+			BytesWritten: pd.BytesWritten,
+			ETag:         pd.ETag,
+			LastModified: pd.LastModified,
+			FilehostUrl:  t.FilehostUrl,
+		})
+		if err != nil {
+			log.Printf("TaskRunner: Couldn't save the journal of task %v: %v\n", t.Id, err)
+		}
+	}
+
+	var resumeFrom int64
+	if journal != nil && journal.FilehostUrl == t.FilehostUrl {
+		resumeFrom = journal.BytesWritten
+	}
+
+	recordEvent(engine, t, task.EventDownloadStarted, fullFilename, resumeFrom)
+
+	err = filehost.Download(
+		ctx,
+		taskTempDir,
+		downloadDir,
+		fullFilename,
+		updateHandler,
+		checkpointHandler,
+	)
+	if err != nil {
+		return err
+	}
+
+	engine.DB().RemoveJournal(t.Id)
+	os.RemoveAll(taskTempDir)
+
+	return nil
 }
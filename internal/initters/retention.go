@@ -0,0 +1,65 @@
+package initters
+
+import (
+	"log"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	pubsub "github.com/relepega/doujinstyle-downloader/internal/pubSub"
+)
+
+// How often the retention policy is applied
+const retentionInterval = time.Hour
+
+/*
+Moves the ended tasks let go by the retention policy to the archive and purges the trash,
+right away and then every retentionInterval, until stop is closed.
+
+The UI re-renders the queue every time some tasks have been archived
+*/
+func RetentionRunner(engine *dsdl.DSDL, stop chan struct{}) {
+	publisher, err := pubsub.GetGlobalPublisher("task-updater")
+	if err != nil {
+		publisher = pubsub.NewGlobalPublisher("task-updater")
+	}
+
+	archive := func() {
+		count, err := engine.ArchiveExpired(time.Now())
+		if err != nil {
+			log.Println("RetentionRunner: Couldn't archive the expired tasks:", err)
+			return
+		}
+
+		if count != 0 {
+			log.Printf("RetentionRunner: Archived %d ended tasks\n", count)
+
+			publisher.Publish(&pubsub.PublishEvent{EvtType: "archived-tasks"})
+		}
+	}
+
+	purge := func() {
+		purged, err := engine.PurgeTrash(time.Now())
+		if err != nil {
+			log.Println("RetentionRunner: Couldn't purge the trash:", err)
+			return
+		}
+
+		if purged != 0 {
+			log.Printf("RetentionRunner: Deleted %d tasks from the trash\n", purged)
+		}
+	}
+
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		archive()
+		purge()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
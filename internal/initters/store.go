@@ -0,0 +1,78 @@
+package initters
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/configManager"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+)
+
+// Returns the task store selected by the config, without opening it
+func newStore(cfg *configManager.Config) (dsdl.TaskStore, error) {
+	switch strings.ToLower(cfg.Database.Backend) {
+	case "", "sqlite":
+		if cfg.Database.Path == "" {
+			return db.NewSQLite(false), nil
+		}
+
+		return db.NewSQLiteFromPath(cfg.Database.Path), nil
+
+	case "json":
+		return db.NewJSONFile(cfg.Database.Path), nil
+
+	case "memory":
+		return db.NewSQLite(true), nil
+
+	default:
+		return nil, fmt.Errorf(
+			"unknown database backend \"%s\", expected \"sqlite\", \"json\" or \"memory\"",
+			cfg.Database.Backend,
+		)
+	}
+}
+
+// Opens the task store selected by the config, with no fallback
+func OpenStore(cfg *configManager.Config) (dsdl.TaskStore, error) {
+	store, err := newStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, store.Open()
+}
+
+/*
+Opens the task store selected by the config.
+
+When the store cannot be opened the app refuses to start, unless the
+Database.MemoryFallback option allows it to keep the tasks in memory.
+*/
+func InitStore(cfg *configManager.Config) dsdl.TaskStore {
+	store, err := OpenStore(cfg)
+	if err != nil {
+		if !cfg.Database.MemoryFallback {
+			log.Fatalf("DB: Couldn't open the database: %v", err)
+		}
+
+		log.Printf("DB: Couldn't open the database: %v", err)
+		log.Println("DB: !!! WARNING: falling back to an in-memory database, tasks WILL BE LOST on restart !!!")
+
+		store = db.NewSQLite(true)
+
+		err = store.Open()
+		if err != nil {
+			log.Fatalf("DB: Couldn't open the in-memory database: %v", err)
+		}
+	}
+
+	log.Println("DB: Using", store.Name())
+
+	if !store.Persistent() {
+		log.Println("DB: Tasks are kept in memory and will be lost on restart")
+	}
+
+	return store
+}
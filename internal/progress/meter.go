@@ -0,0 +1,62 @@
+package progress
+
+import "time"
+
+const (
+	// minimum time between two speed samples
+	sampleInterval = 500 * time.Millisecond
+	// weight of the newest sample in the moving average of the speed
+	rateSmoothing = 0.3
+)
+
+// Measures the speed of a transfer, smoothing it over time
+type Meter struct {
+	lastSample time.Time
+	lastBytes  int64
+	rate       float64
+
+	// used in tests to control the passing of time
+	now func() time.Time
+}
+
+// Returns a meter for a transfer that starts with done bytes already written,
+// e.g. when resuming a partial file
+func NewMeter(done int64) *Meter {
+	m := &Meter{
+		lastBytes: done,
+		now:       time.Now,
+	}
+	m.lastSample = m.now()
+
+	return m
+}
+
+// Records that done bytes out of total (-1 if unknown) have been written so far,
+// returning the resulting download progress
+func (m *Meter) Update(done, total int64) Progress {
+	now := m.now()
+
+	if elapsed := now.Sub(m.lastSample); elapsed >= sampleInterval {
+		sample := float64(done-m.lastBytes) / elapsed.Seconds()
+
+		if m.rate == 0 {
+			m.rate = sample
+		} else {
+			m.rate = rateSmoothing*sample + (1-rateSmoothing)*m.rate
+		}
+
+		m.lastSample = now
+		m.lastBytes = done
+	}
+
+	p := New(Downloading)
+	p.BytesDone = done
+	p.BytesTotal = total
+	p.Rate = m.rate
+
+	if total > 0 && m.rate > 0 && done < total {
+		p.ETA = time.Duration(float64(total-done) / m.rate * float64(time.Second))
+	}
+
+	return p
+}
@@ -0,0 +1,132 @@
+/*
+Progress reports of a running task.
+
+A task goes through several phases before its file is on disk: only the
+download phase moves bytes, so the others are reported without any amount.
+
+Reporters send a Progress every time something changes. Transfers use a Meter
+to fill the rate and the ETA from the amount of bytes written so far:
+
+	m := progress.NewMeter(alreadyWritten)
+
+	for ... {
+		setProgress(m.Update(written, total))
+	}
+*/
+package progress
+
+import (
+	"fmt"
+	"time"
+)
+
+type Phase string
+
+const (
+	// The task isn't running
+	Idle Phase = ""
+	// Opening the album page on the aggregator
+	LoadingAggregator Phase = "loading-aggregator"
+	// Following the album page to the filehost and evaluating the file name
+	ResolvingFilehost Phase = "resolving-filehost"
+	// Transferring the file
+	Downloading Phase = "downloading"
+	// Moving the downloaded file to its final location
+	Finalizing Phase = "finalizing"
+)
+
+var phaseNames = map[Phase]string{
+	Idle:              "Idle",
+	LoadingAggregator: "Loading album page",
+	ResolvingFilehost: "Resolving filehost",
+	Downloading:       "Downloading",
+	Finalizing:        "Finalizing",
+}
+
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+type Progress struct {
+	Phase Phase
+	// Amount of bytes transferred so far, resumed data included
+	BytesDone int64
+	// Size of the file, -1 if unknown
+	BytesTotal int64
+	// Transfer speed in bytes per second
+	Rate float64
+	// Estimated time left, 0 if unknown
+	ETA time.Duration
+	// Completion percentage (0-100) of downloads whose size is not known in bytes
+	// (e.g. reported by the filehost web page), -1 if unknown
+	Pct int8
+	// Files transferred so far and total, for filehosts that download folders file by file
+	FilesDone  int
+	FilesTotal int
+}
+
+// Returns the progress of a task that is about to enter phase
+func New(phase Phase) Progress {
+	return Progress{
+		Phase:      phase,
+		BytesTotal: -1,
+		Pct:        -1,
+	}
+}
+
+// Returns the completion percentage (0-100), or -1 if it cannot be known
+func (p Progress) Percent() int {
+	switch {
+	case p.Pct >= 0:
+		return int(p.Pct)
+	case p.BytesTotal > 0:
+		return int(float64(p.BytesDone) / float64(p.BytesTotal) * 100)
+	default:
+		return -1
+	}
+}
+
+// Returns whether the task is moving bytes, so that amounts and speed make sense
+func (p Progress) Transferring() bool {
+	return p.Phase == Downloading && p.BytesDone > 0
+}
+
+// Human readable amount of bytes transferred, e.g. "12.3 MiB / 100.0 MiB"
+func (p Progress) BytesStr() string {
+	if p.BytesTotal < 0 {
+		return FormatBytes(p.BytesDone)
+	}
+
+	return FormatBytes(p.BytesDone) + " / " + FormatBytes(p.BytesTotal)
+}
+
+// Human readable transfer speed, e.g. "1.5 MiB/s"
+func (p Progress) RateStr() string {
+	return FormatBytes(int64(p.Rate)) + "/s"
+}
+
+// Human readable time left, or an empty string if unknown
+func (p Progress) ETAStr() string {
+	if p.ETA <= 0 {
+		return ""
+	}
+
+	return p.ETA.Round(time.Second).String()
+}
+
+// Formats an amount of bytes with binary units
+func FormatBytes(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,60 @@
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	now := time.Now()
+
+	m := NewMeter(1000)
+	m.now = func() time.Time { return now }
+	m.lastSample = now
+
+	// no sample yet: the speed is unknown
+	p := m.Update(1000, 11000)
+	if p.Rate != 0 || p.ETA != 0 {
+		t.Fatalf("Expected unknown rate and ETA, got %v and %v", p.Rate, p.ETA)
+	}
+
+	now = now.Add(time.Second)
+	p = m.Update(2000, 11000)
+	if p.Rate != 1000 {
+		t.Fatalf("Expected a rate of 1000 B/s, got %v", p.Rate)
+	}
+	if p.ETA != 9*time.Second {
+		t.Fatalf("Expected an ETA of 9s, got %v", p.ETA)
+	}
+	if p.Percent() != 18 {
+		t.Fatalf("Expected 18%%, got %d%%", p.Percent())
+	}
+
+	// samples closer than sampleInterval don't change the rate
+	now = now.Add(100 * time.Millisecond)
+	if p = m.Update(9000, 11000); p.Rate != 1000 {
+		t.Fatalf("Expected the rate to be unchanged, got %v", p.Rate)
+	}
+
+	p = m.Update(5000, -1)
+	if p.Percent() != -1 || p.ETA != 0 {
+		t.Fatalf("Expected unknown percentage and ETA without a total size")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1024:                   "1.0 KiB",
+		1536:                   "1.5 KiB",
+		5 * 1024 * 1024:        "5.0 MiB",
+		3 * 1024 * 1024 * 1024: "3.0 GiB",
+	}
+
+	for n, want := range cases {
+		if got := FormatBytes(n); got != want {
+			t.Fatalf("FormatBytes(%d): expected %q, got %q", n, want, got)
+		}
+	}
+}
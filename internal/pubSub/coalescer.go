@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Rate-limits high frequency events, such as download progress.
+
+Events are grouped by key: only the latest event of every key is kept, and the
+pending ones are forwarded to the publisher at most once per interval. Publishing
+never blocks the caller, so a slow subscriber cannot slow down the event source:
+it misses the events forwarded while its buffer is full.
+*/
+type Coalescer struct {
+	pub      *Publisher
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*PublishEvent
+	// keys in the order they first became pending, so that events are flushed fairly
+	order []string
+
+	closeOnce sync.Once
+	close     chan struct{}
+	done      chan struct{}
+}
+
+// Starts a coalescer forwarding to pub at most one event per key every interval
+func NewCoalescer(pub *Publisher, interval time.Duration) *Coalescer {
+	c := &Coalescer{
+		pub:      pub,
+		interval: interval,
+		pending:  make(map[string]*PublishEvent),
+		close:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c
+}
+
+// Queues evt, replacing the pending event with the same key, if any
+func (c *Coalescer) Publish(key string, evt *PublishEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pending[key]; !ok {
+		c.order = append(c.order, key)
+	}
+
+	c.pending[key] = evt
+}
+
+/*
+Drops the pending event with this key, e.g. because a newer state is about to be published
+directly.
+
+Events are forwarded under the same lock, so once Discard returns the dropped event can't
+reach the subscribers after the one published next
+*/
+func (c *Coalescer) Discard(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pending[key]; !ok {
+		return
+	}
+
+	delete(c.pending, key)
+
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Forwards every pending event right away. Subscribers lagging behind miss them instead
+// of blocking the flush
+func (c *Coalescer) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range c.order {
+		c.pub.TryPublish(c.pending[key])
+	}
+
+	c.pending = make(map[string]*PublishEvent)
+	c.order = nil
+}
+
+// Stops the coalescer, forwarding the events still pending
+func (c *Coalescer) Close() {
+	c.closeOnce.Do(func() {
+		close(c.close)
+		<-c.done
+
+		c.Flush()
+	})
+}
+
+func (c *Coalescer) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.close:
+			return
+		case <-ticker.C:
+			c.Flush()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	pub := NewPublisher()
+	sub := pub.Subscribe()
+
+	c := NewCoalescer(pub, time.Hour)
+
+	for i := range 100 {
+		c.Publish("a", &PublishEvent{EvtType: "progress", Data: i})
+	}
+	c.Publish("b", &PublishEvent{EvtType: "progress", Data: "b"})
+	c.Publish("c", &PublishEvent{EvtType: "progress", Data: "c"})
+	c.Discard("c")
+
+	c.Flush()
+
+	if evt := <-sub; evt.Data != 99 {
+		t.Fatalf("Expected only the latest event of key a, got %v", evt.Data)
+	}
+	if evt := <-sub; evt.Data != "b" {
+		t.Fatalf("Expected the event of key b, got %v", evt.Data)
+	}
+
+	select {
+	case evt := <-sub:
+		t.Fatalf("Unexpected event: %v", evt.Data)
+	default:
+	}
+
+	// pending events are not lost when closing
+	c.Publish("a", &PublishEvent{EvtType: "progress", Data: "last"})
+	c.Close()
+
+	if evt := <-sub; evt.Data != "last" {
+		t.Fatalf("Expected the pending event to be flushed on close, got %v", evt.Data)
+	}
+}
+
+func TestCoalescerInterval(t *testing.T) {
+	pub := NewPublisher()
+	sub := pub.Subscribe()
+
+	c := NewCoalescer(pub, 10*time.Millisecond)
+	defer c.Close()
+
+	c.Publish("a", &PublishEvent{EvtType: "progress", Data: 1})
+
+	select {
+	case evt := <-sub:
+		if evt.Data != 1 {
+			t.Fatalf("Unexpected event: %v", evt.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The pending event has not been flushed")
+	}
+}
+
+func TestCoalescerSlowSubscriber(t *testing.T) {
+	pub := NewPublisher()
+	sub := pub.Subscribe()
+
+	c := NewCoalescer(pub, time.Hour)
+	defer c.Close()
+
+	// a subscriber that stopped reading
+	for range subscriberBuffer {
+		pub.Publish(&PublishEvent{EvtType: "filler"})
+	}
+
+	c.Publish("a", &PublishEvent{EvtType: "progress", Data: "dropped"})
+
+	stopped := make(chan struct{})
+	go func() {
+		c.Flush()
+		c.Discard("a")
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Flush and Discard have been blocked by a full subscriber")
+	}
+
+	for range subscriberBuffer {
+		if evt := <-sub; evt.EvtType != "filler" {
+			t.Fatalf("Expected only the events published before, got %v", evt.Data)
+		}
+	}
+
+	c.Publish("a", &PublishEvent{EvtType: "progress", Data: "next"})
+	c.Flush()
+
+	if evt := <-sub; evt.Data != "next" {
+		t.Fatalf("Expected the next event once the subscriber caught up, got %v", evt.Data)
+	}
+}
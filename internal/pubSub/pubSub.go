@@ -5,6 +5,9 @@ import (
 	"sync"
 )
 
+// Amount of events a subscriber can lag behind before Publish blocks
+const subscriberBuffer = 64
+
 type PublishEvent struct {
 	EvtType string
 	Data    any
@@ -62,7 +65,7 @@ func (p *Publisher) Subscribe() <-chan *PublishEvent {
 		return nil
 	}
 
-	newSubscriber := make(chan *PublishEvent)
+	newSubscriber := make(chan *PublishEvent, subscriberBuffer)
 	p.subscribers = append(p.subscribers, newSubscriber)
 
 	return newSubscriber
@@ -81,6 +84,25 @@ func (p *Publisher) Publish(val *PublishEvent) {
 	}
 }
 
+// Publishes val to the subscribers that have room for it, dropping it for the others.
+// Never blocks, so it only suits events that a newer one supersedes
+func (p *Publisher) TryPublish(val *PublishEvent) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return
+	}
+
+	for _, subscriber := range p.subscribers {
+		select {
+		case subscriber <- val:
+		default:
+			// a lagging subscriber gets the next one
+		}
+	}
+}
+
 func (p *Publisher) Close() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -1,11 +1,17 @@
 package task
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 )
 
 type Task struct {
@@ -17,34 +23,119 @@ type Task struct {
 	Slug string `db:"Slug"`
 	// Full URL calculated by combining name & slug
 	AggregatorPageURL string `db:"AggregatorPageURL"`
+	// ID of the album on its aggregator, the same whether it has been added by slug or by URL
+	AlbumID string
 	// Filehost full url
 	FilehostUrl string `db:"FilehostUrl"`
+	// Name of the filehost and ID of the file on it, set once the filehost page has been resolved
+	Filehost string
+	FileID   string
 	// Full name to be displayed on GUI
 	DisplayName string `db:"DisplayName"`
 	// Downloaded filename
 	Filename string `db:"Filename"`
+	// Free labels making the task easier to find, e.g. the event or the circle
+	Tags []string
 	// Mirror value of the one stored in the database
 	DownloadState int `db:"DownloadState"`
-	// State progress percentage (from -1 (not yet downloading) to 100)
-	Progress int8
+	// What the running task is doing and how far it got. Not stored in the database
+	Progress progress.Progress
 	// Stores an eventual error occurred in the task lifecycle
 	Err error
-	// Aborts the task progression
-	Stop chan string
+	// Number of times the task has been started since it was queued
+	Attempts int
+	// When a task waiting to be retried can be started again, zero if it can start right away
+	NextRetryAt time.Time
+	// Queued tasks with a higher priority are started first
+	Priority int
+	// Queue order among tasks with the same priority, lower first
+	Position int64
+	// When the task has been added
+	CreatedAt time.Time
+	// When the task started running for the first time, zero if it never ran
+	StartedAt time.Time
+	// When the task reached an ended state, zero if it hasn't ended yet
+	FinishedAt time.Time
+	// When the user acknowledged the failure of the task, zero if they didn't
+	AcknowledgedAt time.Time
+	// When the task has been moved to the archive, zero while it is in the queue
+	ArchivedAt time.Time
+	// When the task has been moved to the trash, zero unless it has been removed
+	DeletedAt time.Time
+
+	// Cancels the context of the running task, with the reason it has been stopped
+	cancel context.CancelCauseFunc
+	// Stop reason received while the task wasn't running yet
+	pendingCause error
+	cancelMu     sync.Mutex
+}
+
+// Crash-safe record of the file a task is downloading, stored alongside the task
+// so that a restored task can continue its partial file
+type Journal struct {
+	// ID of the task owning the partial file
+	TaskID string `db:"TaskID"`
+	// Path of the partial file inside the temp directory
+	TempFilepath string `db:"TempFilepath"`
+	// Amount of bytes written at the last checkpoint
+	BytesWritten int64 `db:"BytesWritten"`
+	// Validators returned by the server when the download started
+	ETag         string `db:"ETag"`
+	LastModified string `db:"LastModified"`
+	// Filehost page the file is being downloaded from
+	FilehostUrl string `db:"FilehostUrl"`
+}
+
+// Kind of a step in the lifecycle of a task
+type EventKind string
+
+const (
+	EventStarted          EventKind = "started"
+	EventPageLoaded       EventKind = "page-loaded"
+	EventFilehostResolved EventKind = "filehost-resolved"
+	EventDownloadStarted  EventKind = "download-started"
+	EventBytes            EventKind = "bytes"
+	EventRetryScheduled   EventKind = "retry-scheduled"
+	EventPaused           EventKind = "paused"
+	EventInterrupted      EventKind = "interrupted"
+	EventFinished         EventKind = "finished"
+)
+
+// A step in the lifecycle of a task, kept to show its timeline
+type Event struct {
+	At   time.Time
+	Kind EventKind
+	// Human readable details, e.g. the filehost URL or the final state
+	Message string
+	// Bytes downloaded so far, only meaningful for the download events
+	Bytes int64
+}
+
+// A single run of a task, kept as history of the automatic retries
+type Attempt struct {
+	// Attempt number, starting from 1
+	Number int
+	// When the attempt started and ended. EndedAt is zero while it is still running
+	StartedAt time.Time
+	EndedAt   time.Time
+	// Error that ended the attempt, empty on success
+	Err string
 }
 
 func NewTask(slug string) *Task {
+	now := time.Now()
+
 	t := &Task{
 		Id: fmt.Sprintf(
 			"%d-%s",
-			time.Now().UnixMilli(),
+			now.UnixMilli(),
 			appUtils.GenerateRandomFilename(),
 		),
 		Slug:          slug,
 		DisplayName:   slug,
 		DownloadState: states.TASK_STATE_QUEUED,
-		Progress:      -1,
-		Stop:          make(chan string),
+		Progress:      progress.New(progress.Idle),
+		CreatedAt:     now,
 	}
 
 	return t
@@ -52,18 +143,143 @@ func NewTask(slug string) *Task {
 
 func (t *Task) ID() string { return t.Id }
 
+// Returns a copy of the task, safe to read from other goroutines while its runner
+// keeps changing the original. The copy can't be stopped
+func (t *Task) Snapshot() *Task {
+	return &Task{
+		Id:                t.Id,
+		Aggregator:        t.Aggregator,
+		Slug:              t.Slug,
+		AggregatorPageURL: t.AggregatorPageURL,
+		AlbumID:           t.AlbumID,
+		FilehostUrl:       t.FilehostUrl,
+		Filehost:          t.Filehost,
+		FileID:            t.FileID,
+		DisplayName:       t.DisplayName,
+		Filename:          t.Filename,
+		Tags:              slices.Clone(t.Tags),
+		DownloadState:     t.DownloadState,
+		Progress:          t.Progress,
+		Err:               t.Err,
+		Attempts:          t.Attempts,
+		NextRetryAt:       t.NextRetryAt,
+		Priority:          t.Priority,
+		Position:          t.Position,
+		CreatedAt:         t.CreatedAt,
+		StartedAt:         t.StartedAt,
+		FinishedAt:        t.FinishedAt,
+		AcknowledgedAt:    t.AcknowledgedAt,
+		ArchivedAt:        t.ArchivedAt,
+		DeletedAt:         t.DeletedAt,
+	}
+}
+
 func (t *Task) SetState(state int) { t.DownloadState = state }
 
-func (t *Task) SetProgress(p int8) { t.Progress = p }
+func (t *Task) SetProgress(p progress.Progress) { t.Progress = p }
+
+func (t *Task) SetPhase(phase progress.Phase) { t.Progress = progress.New(phase) }
 
 func (t *Task) SetErrMsg(m string) { t.Err = fmt.Errorf("%s", m) }
 
 func (t *Task) SetErr(err error) { t.Err = err }
 
+// Returns how long the task has been running until it ended, or until now if it hasn't ended yet.
+//
+// Returns 0 if the task never started
+func (t *Task) Duration() time.Duration {
+	if t.StartedAt.IsZero() {
+		return 0
+	}
+
+	if t.FinishedAt.IsZero() {
+		return time.Since(t.StartedAt)
+	}
+
+	return t.FinishedAt.Sub(t.StartedAt)
+}
+
+// Returns the category of the task error, or an empty one if there's no error
+func (t *Task) ErrCategory() dsdlerr.Category { return dsdlerr.CategoryOf(t.Err) }
+
+// Splits comma separated tags and trims them, dropping the empty ones and the duplicates,
+// which are compared case-insensitively
+func CleanTags(tags []string) []string {
+	cleaned := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		for t := range strings.SplitSeq(tag, ",") {
+			t = strings.TrimSpace(t)
+
+			key := strings.ToLower(t)
+			if t == "" || seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			cleaned = append(cleaned, t)
+		}
+	}
+
+	return cleaned
+}
+
+/*
+Returns the context of a new run of the task, derived from parent.
+
+The context is cancelled by Abort, Pause and Shutdown, with the matching dsdlerr
+cause. A stop requested before the run started cancels it right away.
+
+The returned function releases the context and must be called once the run is over
+*/
+func (t *Task) Start(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	t.cancelMu.Lock()
+	defer t.cancelMu.Unlock()
+
+	t.cancel = cancel
+
+	if t.pendingCause != nil {
+		cancel(t.pendingCause)
+		t.pendingCause = nil
+	}
+
+	return ctx, func() {
+		t.cancelMu.Lock()
+		defer t.cancelMu.Unlock()
+
+		t.cancel = nil
+		cancel(nil)
+	}
+}
+
+// Cancels the running context with cause. Only the first cause of a run is kept
+func (t *Task) stop(cause error) {
+	t.cancelMu.Lock()
+	defer t.cancelMu.Unlock()
+
+	if t.cancel == nil {
+		if t.pendingCause == nil {
+			t.pendingCause = cause
+		}
+
+		return
+	}
+
+	t.cancel(cause)
+}
+
 func (t *Task) Abort() {
-	t.Stop <- "user-abort"
+	t.stop(dsdlerr.ErrUserAbort)
+}
+
+// Stops the task keeping its partial data, so that it can be resumed later on
+func (t *Task) Pause() {
+	t.stop(dsdlerr.ErrPaused)
 }
 
 func (t *Task) Shutdown() {
-	t.Stop <- "shutdown"
+	t.stop(dsdlerr.ErrShutdown)
 }
@@ -0,0 +1,380 @@
+package v2
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
+)
+
+// Largest JSON body accepted by the v1 API
+const maxAPIBodySize = 1 << 20
+
+// OpenAPI description of the v1 API, shipped along with the other views
+var openAPIPath = filepath.Join(".", "views", "api", "openapi.json")
+
+// A task to be created through POST /api/v1/tasks
+type NewTaskRequest struct {
+	// Name of the aggregator, e.g. "doujinstyle"
+	Aggregator string `json:"Aggregator"`
+	// Page ID or full URL of the album
+	Slug string `json:"Slug"`
+}
+
+type CreateTasksRequest struct {
+	Tasks []NewTaskRequest `json:"Tasks"`
+}
+
+// Body of the batch actions on existing tasks
+type TaskIDsRequest struct {
+	IDs []string `json:"IDs"`
+}
+
+// Outcome of a single item of a request, with its own HTTP status
+type TaskResult struct {
+	// ID of the task the action has been applied to
+	ID string `json:"ID,omitempty"`
+	// Slug of the task to be created
+	Slug   string `json:"Slug,omitempty"`
+	Status int    `json:"Status"`
+	Error  string `json:"Error,omitempty"`
+	// Task already storing the album, when creating a duplicate
+	ExistingID string `json:"ExistingID,omitempty"`
+	// The task after the action, if it succeeded
+	Task *TaskSummary `json:"Task,omitempty"`
+}
+
+type TaskResults struct {
+	Results []TaskResult `json:"Results"`
+}
+
+// Returns the status shared by every result, or 207 Multi-Status if they differ
+func (res *TaskResults) status() int {
+	if len(res.Results) == 0 {
+		return http.StatusOK
+	}
+
+	status := res.Results[0].Status
+
+	for _, r := range res.Results[1:] {
+		if r.Status != status {
+			return http.StatusMultiStatus
+		}
+	}
+
+	return status
+}
+
+// Allows the browser userscript, running on the aggregators' pages, to call the API
+func allowCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// Decodes the JSON body of a request into v, replying with 400 Bad Request if it can't
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+
+	if err := dec.Decode(v); err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": "Invalid JSON body: " + err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// Reads the IDs of a batch action, replying with 400 Bad Request if there are none
+func readTaskIDs(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var req TaskIDsRequest
+
+	if !readJSON(w, r, &req) {
+		return nil, false
+	}
+
+	var ids []string
+
+	for _, id := range req.IDs {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": "At least one task ID is required"})
+		return nil, false
+	}
+
+	return ids, true
+}
+
+// Adds a task to the queue, telling the clients about it
+func (ws *Webserver) createTask(req NewTaskRequest) TaskResult {
+	aggregator := strings.TrimSpace(req.Aggregator)
+	slug := strings.TrimSpace(req.Slug)
+
+	res := TaskResult{Slug: slug}
+
+	if slug == "" {
+		res.Status, res.Error = http.StatusBadRequest, "The slug is required"
+		return res
+	}
+
+	if !ws.engine.IsValidAggregator(aggregator) {
+		res.Status, res.Error = http.StatusBadRequest, fmt.Sprintf("Not a valid aggregator: %q", aggregator)
+		return res
+	}
+
+	t, err := ws.engine.AddTask(aggregator, slug)
+
+	var dup *dsdl.DuplicateError
+
+	switch {
+	case errors.As(err, &dup):
+		res.Status, res.Error = http.StatusConflict, "The album is "+dup.Reason()
+
+		if dup.Existing != nil {
+			res.ExistingID = dup.Existing.Id
+		}
+
+		return res
+	case errors.Is(err, dsdl.ErrInvalidSlug):
+		res.Status, res.Error = http.StatusBadRequest, err.Error()
+		return res
+	case err != nil:
+		res.Status, res.Error = http.StatusInternalServerError, err.Error()
+		return res
+	}
+
+	ws.publishTaskEvent(EventTaskCreated, t)
+
+	if tmpl, err := ws.templates.Execute("task", t); err == nil {
+		ws.msgChan <- sse.NewSSEBuilder().Event("new-task").Data(appUtils.CleanString(tmpl)).Build()
+	}
+
+	summary := newTaskSummary(t)
+	res.ID, res.Status, res.Task = t.Id, http.StatusCreated, &summary
+
+	return res
+}
+
+/*
+POST /api/v1/tasks { Tasks: [{ Aggregator, Slug }] }
+
+Replies 201 Created if every task has been created, the status shared by every
+item if they all failed the same way, and 207 Multi-Status otherwise
+*/
+func (ws *Webserver) handleV1TaskCreate(w http.ResponseWriter, r *http.Request) {
+	var req CreateTasksRequest
+
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.Tasks) == 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": "At least one task is required"})
+		return
+	}
+
+	res := &TaskResults{Results: make([]TaskResult, 0, len(req.Tasks))}
+
+	for _, t := range req.Tasks {
+		res.Results = append(res.Results, ws.createTask(t))
+	}
+
+	WriteJSON(w, res.status(), res)
+}
+
+// Maps the error of an action on an existing task to its result
+func actionResult(id string, err error) TaskResult {
+	res := TaskResult{ID: id, Status: http.StatusInternalServerError, Error: err.Error()}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res.Status, res.Error = http.StatusNotFound, "Task not found"
+	case errors.Is(err, dsdl.ErrTaskEnded), errors.Is(err, dsdl.ErrTaskNotEnded), errors.Is(err, dsdl.ErrTaskStopping):
+		res.Status = http.StatusConflict
+	}
+
+	return res
+}
+
+// Queues an ended task again
+func (ws *Webserver) retryTask(id string) TaskResult {
+	t, err := ws.engine.RetryTask(id)
+	if err != nil {
+		return actionResult(id, err)
+	}
+
+	summary := newTaskSummary(t)
+
+	return TaskResult{ID: id, Status: http.StatusOK, Task: &summary}
+}
+
+/*
+Cancels a task. A running task is stopped by its runner, so the reply
+is 202 Accepted rather than 200 OK
+*/
+func (ws *Webserver) abortTask(id string) TaskResult {
+	t, err := ws.engine.AbortTask(id)
+	if err != nil {
+		return actionResult(id, err)
+	}
+
+	summary := newTaskSummary(t)
+
+	if t.DownloadState == states.TASK_STATE_RUNNING {
+		return TaskResult{ID: id, Status: http.StatusAccepted, Task: &summary}
+	}
+
+	return TaskResult{ID: id, Status: http.StatusOK, Task: &summary}
+}
+
+// Runs an action on every task, then re-renders the task divisions if any succeeded
+func (ws *Webserver) applyToTasks(ids []string, action func(id string) TaskResult) *TaskResults {
+	res := &TaskResults{Results: make([]TaskResult, 0, len(ids))}
+	changed := false
+
+	for _, id := range ids {
+		r := action(id)
+		changed = changed || r.Error == ""
+
+		res.Results = append(res.Results, r)
+	}
+
+	if changed {
+		if err := ws.renderQueue(); err != nil {
+			log.Println("WebServer: API:", err)
+		}
+
+		if err := ws.renderEnded(); err != nil {
+			log.Println("WebServer: API:", err)
+		}
+	}
+
+	return res
+}
+
+// Moves the tasks to the trash as a single removal, which the clients are offered to undo
+func (ws *Webserver) deleteTasks(ids []string) *TaskResults {
+	ws.removeMu.Lock()
+	defer ws.removeMu.Unlock()
+
+	res := &TaskResults{Results: make([]TaskResult, 0, len(ids))}
+
+	var found []string
+
+	for _, id := range ids {
+		if _, err := ws.engine.DB().Get(id); err != nil {
+			res.Results = append(res.Results, actionResult(id, err))
+			continue
+		}
+
+		found = append(found, id)
+		res.Results = append(res.Results, TaskResult{ID: id, Status: http.StatusOK})
+	}
+
+	if len(found) == 0 {
+		return res
+	}
+
+	if _, err := ws.engine.RemoveTasks(found); err != nil {
+		for i := range res.Results {
+			if res.Results[i].Error == "" {
+				res.Results[i] = actionResult(res.Results[i].ID, err)
+			}
+		}
+
+		return res
+	}
+
+	for _, id := range found {
+		ws.msgChan <- sse.NewSSEBuilder().Event("remove-node").Data(id).Build()
+	}
+
+	if err := ws.announceRemoval(); err != nil {
+		log.Println("WebServer: Couldn't announce the removal:", err)
+	}
+
+	return res
+}
+
+// POST /api/v1/tasks/retry { IDs: [] }
+func (ws *Webserver) handleV1TaskRetryBatch(w http.ResponseWriter, r *http.Request) {
+	ids, ok := readTaskIDs(w, r)
+	if !ok {
+		return
+	}
+
+	res := ws.applyToTasks(ids, ws.retryTask)
+
+	WriteJSON(w, res.status(), res)
+}
+
+// POST /api/v1/tasks/abort { IDs: [] }
+func (ws *Webserver) handleV1TaskAbortBatch(w http.ResponseWriter, r *http.Request) {
+	ids, ok := readTaskIDs(w, r)
+	if !ok {
+		return
+	}
+
+	res := ws.applyToTasks(ids, ws.abortTask)
+
+	WriteJSON(w, res.status(), res)
+}
+
+// DELETE /api/v1/tasks { IDs: [] }
+func (ws *Webserver) handleV1TaskDeleteBatch(w http.ResponseWriter, r *http.Request) {
+	ids, ok := readTaskIDs(w, r)
+	if !ok {
+		return
+	}
+
+	res := ws.deleteTasks(ids)
+
+	WriteJSON(w, res.status(), res)
+}
+
+// POST /api/v1/tasks/{id}/retry
+func (ws *Webserver) handleV1TaskRetry(w http.ResponseWriter, r *http.Request) {
+	res := ws.applyToTasks([]string{r.PathValue("id")}, ws.retryTask).Results[0]
+
+	WriteJSON(w, res.Status, res)
+}
+
+// POST /api/v1/tasks/{id}/abort
+func (ws *Webserver) handleV1TaskAbort(w http.ResponseWriter, r *http.Request) {
+	res := ws.applyToTasks([]string{r.PathValue("id")}, ws.abortTask).Results[0]
+
+	WriteJSON(w, res.Status, res)
+}
+
+// DELETE /api/v1/tasks/{id}
+func (ws *Webserver) handleV1TaskDelete(w http.ResponseWriter, r *http.Request) {
+	res := ws.deleteTasks([]string{r.PathValue("id")}).Results[0]
+
+	WriteJSON(w, res.Status, res)
+}
+
+// GET /api/openapi.json
+func (ws *Webserver) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, openAPIPath)
+}
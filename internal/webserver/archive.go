@@ -0,0 +1,175 @@
+package v2
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
+)
+
+// Lists the archived tasks matching the "q" value, every archived task if it is empty,
+// returning at most "limit" tasks (defaultPageSize if missing)
+func (ws *Webserver) searchArchive(r *http.Request) (*SearchResults, error) {
+	return ws.searchStored(r, ws.engine.DB().SearchArchive)
+}
+
+// Lists the tasks found by search for the "q" value of the request, at most "limit" of them
+func (ws *Webserver) searchStored(
+	r *http.Request,
+	search func(text string, limit int) ([]*task.Task, error),
+) (*SearchResults, error) {
+	res := &SearchResults{
+		Query: strings.TrimSpace(r.FormValue("q")),
+		Tasks: make([]TaskSummary, 0),
+	}
+
+	limit := defaultPageSize
+
+	if l := strings.TrimSpace(r.FormValue("limit")); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return res, fmt.Errorf("Not a valid limit: %q", l)
+		}
+
+		limit = min(n, maxPageSize)
+	}
+
+	found, err := search(res.Query, limit)
+	if err != nil {
+		return res, err
+	}
+
+	for _, t := range found {
+		res.Tasks = append(res.Tasks, newTaskSummary(t))
+	}
+
+	return res, nil
+}
+
+// GET /api/archive?q=&limit=
+func (ws *Webserver) handleArchiveList(w http.ResponseWriter, r *http.Request) {
+	res, err := ws.searchArchive(r)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, res)
+}
+
+// GET /history, the archived tasks, optionally filtered by a search
+func (ws *Webserver) handleHistoryPage(w http.ResponseWriter, r *http.Request) {
+	res, err := ws.searchArchive(r)
+	if err != nil {
+		res.Err = err.Error()
+	}
+
+	err = ws.pageTemplates(r).ExecuteWithWriter(w, "history", res)
+	if err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+	}
+}
+
+// Queues an archived task again, returning the HTTP status and the message of an eventual error
+func (ws *Webserver) redownload(id string) (int, error) {
+	t, err := ws.engine.Redownload(id)
+
+	var dup *dsdl.DuplicateError
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, fmt.Errorf("Archived task not found")
+	case errors.As(err, &dup):
+		return http.StatusConflict, fmt.Errorf("The album is %s", dup.Reason())
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+
+	log.Printf("WebServer: Queued the archived task %s again\n", t.Id)
+
+	ws.publishTaskEvent(EventTaskCreated, t)
+
+	if err := ws.renderQueue(); err != nil {
+		log.Println("WebServer: Redownload:", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+// POST /api/archive/{id}/redownload
+func (ws *Webserver) handleArchiveRedownload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if status, err := ws.redownload(id); err != nil {
+		WriteJSON(w, status, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	t, err := ws.engine.DB().Get(id)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, newTaskSummary(t))
+}
+
+// POST /history/{id}/redownload, the form of the history and task pages
+func (ws *Webserver) handleHistoryRedownload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	status, err := ws.redownload(id)
+	if status == http.StatusNotFound {
+		ws.handleNotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	http.Redirect(w, r, "/task/"+id, http.StatusSeeOther)
+}
+
+// POST /api/task/acknowledge { IDs: id1|id2 }, moving failed and canceled tasks to the archive
+func (ws *Webserver) handleTaskAcknowledge(w http.ResponseWriter, r *http.Request) {
+	taskIDs := r.FormValue("IDs")
+
+	delimiter := "|"
+
+	if taskIDs == "" || taskIDs == delimiter {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "At least one Album ID is required")
+		return
+	}
+
+	var happenedErrors []string
+
+	for id := range strings.SplitSeq(taskIDs, delimiter) {
+		if id == "" {
+			continue
+		}
+
+		if err := ws.engine.Acknowledge(id); err != nil {
+			happenedErrors = append(happenedErrors, err.Error())
+			continue
+		}
+
+		ws.msgChan <- sse.NewSSEBuilder().Event("remove-node").Data(id).Build()
+	}
+
+	if len(happenedErrors) != 0 {
+		ws.handleError(w, fmt.Errorf("%+v", happenedErrors))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,310 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/auth"
+	"github.com/relepega/doujinstyle-downloader/internal/webserver/templates"
+)
+
+const (
+	// Cookie storing the session of the web UI
+	sessionCookie = "dsdl_session"
+	// Slows down the guessing of the password
+	loginFailureDelay = time.Second
+)
+
+// Key of the identity of the sender in the request context
+type identityKey struct{}
+
+type LoginData struct {
+	// Where to go after logging in
+	Next string
+	User string
+	Err  string
+	// Logging in is impossible until a user is set up
+	NoUsers bool
+}
+
+type TokensData struct {
+	Tokens []auth.Token
+	Roles  []auth.Role
+	// The token just created, shown only once
+	Created     string
+	CreatedName string
+	Err         string
+}
+
+// Paths reachable without being authenticated
+func isPublicPath(path string) bool {
+	return path == "/login" ||
+		path == "/hello" ||
+		path == APIGroup+"/openapi.json" ||
+		strings.HasPrefix(path, "/css/") ||
+		strings.HasPrefix(path, "/js/")
+}
+
+// Reports whether the request is answered with JSON rather than with a page
+func isAPIPath(path string) bool {
+	return strings.HasPrefix(path, APIGroup+"/") || path == "/events-stream"
+}
+
+// Returns who sent the request, from its API token or else from its session cookie
+func (ws *Webserver) identify(r *http.Request) (auth.Identity, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return auth.Identity{}, false
+		}
+
+		return ws.auth.CheckToken(strings.TrimSpace(token))
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return auth.Identity{}, false
+	}
+
+	return ws.auth.Session(cookie.Value)
+}
+
+// Returns who sent the request, as found by requireAuth. Its role is zero if it is anonymous
+func identityOf(r *http.Request) auth.Identity {
+	id, _ := r.Context().Value(identityKey{}).(auth.Identity)
+
+	return id
+}
+
+/*
+Lets through the requests that are authenticated, or that don't need to be,
+storing who sent them in their context. Everyone is an admin when the
+authentication is disabled.
+
+The others get 401 Unauthorized from the API and the event stream,
+and are sent to the login page by the web UI
+*/
+func (ws *Webserver) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ws.auth.Enabled() {
+			ctx := context.WithValue(r.Context(), identityKey{}, auth.Identity{Role: auth.RoleAdmin})
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if id, ok := ws.identify(r); ok {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+			return
+		}
+
+		// CORS preflights never carry credentials
+		if r.Method == http.MethodOptions || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if isAPIPath(r.URL.Path) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="doujinstyle-downloader"`)
+			WriteJSON(w, http.StatusUnauthorized, map[string]string{"Error": "Authentication required"})
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+}
+
+// Lets through the requests sent by an identity with at least the given role, replying 403 Forbidden to the others
+func allow(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if identityOf(r).Can(role) {
+			next(w, r)
+			return
+		}
+
+		msg := fmt.Sprintf("This action requires the %s role", role)
+
+		if isAPIPath(r.URL.Path) {
+			WriteJSON(w, http.StatusForbidden, map[string]string{"Error": msg})
+			return
+		}
+
+		http.Error(w, msg, http.StatusForbidden)
+	}
+}
+
+// Returns the templates of the pages, showing only the controls the sender of the request can use
+func (ws *Webserver) pageTemplates(r *http.Request) *templates.Templates {
+	if t, ok := ws.pages[identityOf(r).Role]; ok {
+		return t
+	}
+
+	return ws.pages[auth.RoleViewer]
+}
+
+// Returns next if it is a path of this server, "/" otherwise, so that the login can't redirect elsewhere
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	return next
+}
+
+// GET /login
+func (ws *Webserver) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	next := safeRedirect(r.URL.Query().Get("next"))
+
+	if identityOf(r).Role != 0 {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	ws.renderLogin(w, r, http.StatusOK, &LoginData{Next: next, NoUsers: !ws.auth.HasUsers()})
+}
+
+func (ws *Webserver) renderLogin(w http.ResponseWriter, r *http.Request, status int, data *LoginData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	err := ws.pageTemplates(r).ExecuteWithWriter(w, "login", data)
+	if err != nil {
+		log.Println("WebServer: Login:", err)
+	}
+}
+
+// POST /login { User, Password, Next }
+func (ws *Webserver) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeRedirect(r.FormValue("Next"))
+
+	if !ws.auth.Enabled() {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	user := strings.TrimSpace(r.FormValue("User"))
+
+	session, expires, err := ws.auth.Login(user, r.FormValue("Password"))
+	if err != nil {
+		data := &LoginData{Next: next, User: user, NoUsers: errors.Is(err, auth.ErrNoUsers)}
+
+		if errors.Is(err, auth.ErrInvalidPassword) {
+			log.Printf("WebServer: Failed login of %q from %s\n", user, r.RemoteAddr)
+
+			time.Sleep(loginFailureDelay)
+
+			data.Err = "Wrong user or password"
+		} else if !data.NoUsers {
+			data.Err = err.Error()
+		}
+
+		ws.renderLogin(w, r, http.StatusUnauthorized, data)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   ws.isHTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// POST /logout
+func (ws *Webserver) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		ws.auth.Logout(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   ws.isHTTPS,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (ws *Webserver) renderTokens(w http.ResponseWriter, r *http.Request, status int, data *TokensData) {
+	data.Tokens = ws.auth.Tokens()
+	data.Roles = auth.Roles()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the page may show a token in clear
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := ws.pageTemplates(r).ExecuteWithWriter(w, "tokens", data)
+	if err != nil {
+		log.Println("WebServer: Tokens:", err)
+	}
+}
+
+// GET /tokens, the API tokens
+func (ws *Webserver) handleTokensPage(w http.ResponseWriter, r *http.Request) {
+	ws.renderTokens(w, r, http.StatusOK, &TokensData{})
+}
+
+// POST /tokens { Name, Role }, showing the new token once
+func (ws *Webserver) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("Name"))
+
+	role, err := auth.ParseRole(r.FormValue("Role"))
+	if err != nil {
+		ws.renderTokens(w, r, http.StatusBadRequest, &TokensData{Err: err.Error()})
+		return
+	}
+
+	token, err := ws.auth.CreateToken(name, role)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrTokenExists) || errors.Is(err, auth.ErrTokenName) {
+			status = http.StatusBadRequest
+		}
+
+		ws.renderTokens(w, r, status, &TokensData{Err: err.Error()})
+		return
+	}
+
+	log.Printf("WebServer: Created the API token %q, with the %s role\n", name, role)
+
+	ws.renderTokens(w, r, http.StatusOK, &TokensData{Created: token, CreatedName: name})
+}
+
+// POST /tokens/{name}/revoke
+func (ws *Webserver) handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	err := ws.auth.RevokeToken(name)
+	if errors.Is(err, auth.ErrTokenNotFound) {
+		ws.handleNotFound(w, r)
+		return
+	}
+	if err != nil {
+		ws.renderTokens(w, r, http.StatusInternalServerError, &TokensData{Err: err.Error()})
+		return
+	}
+
+	log.Printf("WebServer: Revoked the API token %q\n", name)
+
+	http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+}
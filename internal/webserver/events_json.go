@@ -0,0 +1,144 @@
+package v2
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
+	pubsub "github.com/relepega/doujinstyle-downloader/internal/pubSub"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
+)
+
+// Types of the events of the JSON stream, also used as the names of their SSE events
+const (
+	EventTaskCreated      = "task.created"
+	EventTaskStateChanged = "task.state_changed"
+	EventTaskProgress     = "task.progress"
+	EventTaskRemoved      = "task.removed"
+	EventError            = "error"
+)
+
+/*
+An event of GET /api/v1/events, sent as JSON in the data of the SSE event named
+after its Type.
+
+task.created is also sent for the tasks restored from the trash or queued again
+from the history, task.removed for every task moved to the trash.
+*/
+type StreamEvent struct {
+	Type string    `json:"Type"`
+	At   time.Time `json:"At"`
+	// Name of the state of the task, e.g. "Queued"
+	State string `json:"State,omitempty"`
+	// Error of the task, or the one reported by an "error" event
+	Error string `json:"Error,omitempty"`
+	// Removal the task has been trashed by, which POST /api/trash/undo restores
+	Batch string `json:"Batch,omitempty"`
+	// The task as returned by /api/v1/tasks
+	Task *TaskSummary `json:"Task,omitempty"`
+	// What the task is doing and how far it got, sent with the events of a task
+	Progress *progress.Progress `json:"Progress,omitempty"`
+}
+
+// Builds the event of a task. The task is copied, so the event can be encoded later on
+func newTaskStreamEvent(evtType string, t *task.Task) *StreamEvent {
+	summary := newTaskSummary(t)
+	prog := t.Progress
+
+	return &StreamEvent{
+		Type:     evtType,
+		State:    summary.State,
+		Error:    summary.Err,
+		Task:     &summary,
+		Progress: &prog,
+	}
+}
+
+// Sends an event to the clients of the JSON stream
+func (ws *Webserver) publishEvent(evt *StreamEvent) {
+	evt.At = time.Now()
+
+	data, err := json.Marshal(evt)
+	if err != nil {
+		log.Println("Webserver: JSONEventStream: Cannot encode the event:", err)
+		return
+	}
+
+	ws.jsonConnections.Broadcast(sse.NewSSEBuilder().Event(evt.Type).Data(string(data)).Build())
+}
+
+// Sends an event about a task to the clients of the JSON stream
+func (ws *Webserver) publishTaskEvent(evtType string, t *task.Task) {
+	ws.publishEvent(newTaskStreamEvent(evtType, t))
+}
+
+func (ws *Webserver) publishError(err error) {
+	ws.publishEvent(&StreamEvent{Type: EventError, Error: err.Error()})
+}
+
+// Sends a task.removed event for every task of the trash batch
+func (ws *Webserver) publishRemoval(batch string) error {
+	tasks, err := ws.engine.DB().GetTrashBatch(batch)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tasks {
+		evt := newTaskStreamEvent(EventTaskRemoved, t)
+		evt.Batch = batch
+
+		ws.publishEvent(evt)
+	}
+
+	return nil
+}
+
+/*
+Forwards the events of the engine to the JSON stream, until the publisher is closed.
+
+It has its own subscription, so that the JSON clients keep receiving the events
+while no web UI is connected. The runners publish snapshots of their tasks, which
+can be read here while the downloads go on
+*/
+func (ws *Webserver) jsonEventBroker(subscriber <-chan *pubsub.PublishEvent) {
+	for msg := range subscriber {
+		switch msg.EvtType {
+		case "activate-task", "requeue-task", "mark-task-as-done":
+			ws.publishTaskEvent(EventTaskStateChanged, msg.Data.(*task.Task))
+
+		case "update-node-content":
+			ws.publishTaskEvent(EventTaskProgress, msg.Data.(*task.Task))
+
+		case "error":
+			ws.publishError(msg.Data.(error))
+
+		default:
+		}
+	}
+}
+
+// GET /api/v1/events, the changes of the tasks as typed JSON events
+func (ws *Webserver) handleJSONEventStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// sends the headers right away, the first event may be far away
+	w.(http.Flusher).Flush()
+
+	client := ws.jsonConnections.AddClient(w, r)
+	log.Printf("Webserver: JSONEventStream: New client connected (ID: %v)", client.ID())
+
+	select {
+	case <-ws.closeStream:
+	case <-client.Close():
+	case <-r.Context().Done():
+	}
+
+	ws.jsonConnections.Removeclient(client)
+
+	log.Printf("Webserver: JSONEventStream: Client disconnected (ID: %v)", client.ID())
+}
@@ -0,0 +1,37 @@
+package v2
+
+import (
+	"net/http"
+
+	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
+)
+
+type QueueStatus struct {
+	Paused bool `json:"Paused"`
+}
+
+func (ws *Webserver) handleQueueStatus(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, &QueueStatus{Paused: ws.engine.IsQueuePaused()})
+}
+
+func (ws *Webserver) handleQueuePause(w http.ResponseWriter, r *http.Request) {
+	ws.engine.PauseQueue()
+	ws.sendQueueStatus(w)
+}
+
+func (ws *Webserver) handleQueueResume(w http.ResponseWriter, r *http.Request) {
+	ws.engine.ResumeQueue()
+	ws.sendQueueStatus(w)
+}
+
+// Notifies every client about the queue status and returns it to the caller
+func (ws *Webserver) sendQueueStatus(w http.ResponseWriter) {
+	status := "running"
+	if ws.engine.IsQueuePaused() {
+		status = "paused"
+	}
+
+	ws.msgChan <- sse.NewSSEBuilder().Event("queue-status").Data(status).Build()
+
+	WriteJSON(w, http.StatusOK, &QueueStatus{Paused: ws.engine.IsQueuePaused()})
+}
@@ -0,0 +1,121 @@
+package v2
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+type SearchResults struct {
+	Query string        `json:"Query"`
+	Tasks []TaskSummary `json:"Tasks"`
+	// Why the search couldn't be run, shown by the search page
+	Err string `json:"-"`
+}
+
+// Runs the search in the "q" value, returning at most "limit" tasks (defaultPageSize if missing)
+func (ws *Webserver) search(r *http.Request) (*SearchResults, error) {
+	res := &SearchResults{
+		Query: strings.TrimSpace(r.FormValue("q")),
+		Tasks: make([]TaskSummary, 0),
+	}
+
+	if res.Query == "" {
+		return res, fmt.Errorf("A search is required")
+	}
+
+	limit := defaultPageSize
+
+	if l := strings.TrimSpace(r.FormValue("limit")); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return res, fmt.Errorf("Not a valid limit: %q", l)
+		}
+
+		limit = min(n, maxPageSize)
+	}
+
+	found, err := ws.engine.DB().Search(res.Query, limit)
+	if err != nil {
+		return res, err
+	}
+
+	for _, t := range found {
+		res.Tasks = append(res.Tasks, newTaskSummary(t))
+	}
+
+	return res, nil
+}
+
+// GET /api/task/search
+func (ws *Webserver) handleTaskSearch(w http.ResponseWriter, r *http.Request) {
+	res, err := ws.search(r)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, res)
+}
+
+// GET /search, the search page. Without a query it only shows the search box
+func (ws *Webserver) handleSearchPage(w http.ResponseWriter, r *http.Request) {
+	res, err := ws.search(r)
+	if err != nil && res.Query != "" {
+		res.Err = err.Error()
+	}
+
+	err = ws.pageTemplates(r).ExecuteWithWriter(w, "search", res)
+	if err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+	}
+}
+
+// PUT /api/task/{id}/tags, replacing the tags with the ones in the {"Tags": [...]} body
+func (ws *Webserver) handleTaskTags(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Tags []string
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": "Invalid JSON body"})
+		return
+	}
+
+	id := r.PathValue("id")
+
+	if _, err := ws.engine.DB().Get(id); errors.Is(err, sql.ErrNoRows) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"Error": "Task not found"})
+		return
+	}
+
+	if err := ws.engine.DB().SetTags(id, body.Tags); err != nil {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string][]string{"Tags": task.CleanTags(body.Tags)})
+}
+
+// POST /task/{id}/tags, the tags form of the detail page, holding comma separated tags
+func (ws *Webserver) handleTaskTagsForm(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if _, err := ws.engine.DB().Get(id); errors.Is(err, sql.ErrNoRows) {
+		ws.handleNotFound(w, r)
+		return
+	}
+
+	if err := ws.engine.DB().SetTags(id, []string{r.FormValue("tags")}); err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+		return
+	}
+
+	http.Redirect(w, r, "/task/"+id, http.StatusSeeOther)
+}
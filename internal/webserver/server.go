@@ -8,10 +8,13 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/relepega/doujinstyle-downloader/internal/auth"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/progress"
 	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
 	"github.com/relepega/doujinstyle-downloader/internal/webserver/templates"
 )
@@ -20,6 +23,10 @@ const (
 	APIGroup      = "/api"
 	TaskGroup     = APIGroup + "/task"
 	InternalGroup = APIGroup + "/internal"
+	QueueGroup    = APIGroup + "/queue"
+	ArchiveGroup  = APIGroup + "/archive"
+	TrashGroup    = APIGroup + "/trash"
+	V1Group       = APIGroup + "/v1"
 )
 
 type Webserver struct {
@@ -32,13 +39,24 @@ type Webserver struct {
 
 	httpServer  *http.Server
 	connections *sse.Hub
+	// clients of the JSON event stream
+	jsonConnections *sse.Hub
 
+	// every control, for the fragments sent to all the clients
 	templates *templates.Templates
+	// the pages of every role, without the controls it can't use
+	pages map[auth.Role]*templates.Templates
 
 	msgChan      chan string
 	closeUpdater chan struct{}
 	closeStream  chan struct{}
 
+	// serializes the removals, so that the undo offered after one restores that very removal
+	removeMu sync.Mutex
+
+	// logins of the web UI and API tokens
+	auth *auth.Authenticator
+
 	engine *dsdl.DSDL
 }
 
@@ -49,29 +67,33 @@ func NewWebServer(
 	sslKey string,
 	sslCert string,
 	dsdl *dsdl.DSDL,
+	authenticator *auth.Authenticator,
 ) *Webserver {
 	log.Println("Webserver: Initializing webserver")
 
 	server := &http.Server{}
 
 	webServer := &Webserver{
-		address:      address,
-		port:         port,
-		isHTTPS:      isHTTPS,
-		sslKey:       sslKey,
-		sslCert:      sslCert,
-		httpServer:   server,
-		connections:  sse.NewHub(),
-		msgChan:      make(chan string),
-		closeUpdater: make(chan struct{}, 1),
-		closeStream:  make(chan struct{}, 1),
-		engine:       dsdl,
+		address:         address,
+		port:            port,
+		isHTTPS:         isHTTPS,
+		sslKey:          sslKey,
+		sslCert:         sslCert,
+		httpServer:      server,
+		connections:     sse.NewHub(),
+		jsonConnections: sse.NewHub(),
+		msgChan:         make(chan string),
+		closeUpdater:    make(chan struct{}, 1),
+		closeStream:     make(chan struct{}, 1),
+		auth:            authenticator,
+		engine:          dsdl,
 	}
 
 	return webServer
 }
 
-func (ws *Webserver) buildTemplates() *templates.Templates {
+// Builds the templates seen by the given role
+func (ws *Webserver) buildTemplates(role auth.Role) *templates.Templates {
 	t, err := templates.NewTemplates()
 	if err != nil {
 		log.Fatalln(err)
@@ -87,6 +109,67 @@ func (ws *Webserver) buildTemplates() *templates.Templates {
 
 	t.AddFunction("GetStateStr", states.GetStateStr)
 
+	t.AddFunction("IsEnded", states.IsEnded)
+
+	t.AddFunction("QueuePaused", func() bool {
+		return ws.engine.IsQueuePaused()
+	})
+
+	// tasks kept in memory are lost on restart
+	t.AddFunction("StoreVolatile", func() bool {
+		return !ws.engine.DB().Persistent()
+	})
+
+	// whether the pages offer to log out
+	t.AddFunction("AuthEnabled", func() bool {
+		return ws.auth.Enabled()
+	})
+
+	t.AddFunction("Role", role.String)
+
+	// whether the role is at least the named one, e.g. {{ if Can "operator" }}
+	t.AddFunction("Can", func(name string) (bool, error) {
+		r, err := auth.ParseRole(name)
+		if err != nil {
+			return false, err
+		}
+
+		return role >= r, nil
+	})
+
+	t.AddFunction("Inc", func(n int) int {
+		return n + 1
+	})
+
+	t.AddFunction("MaxAttempts", func() int {
+		return ws.engine.RetryPolicy().MaxAttempts
+	})
+
+	// time left before a retry, empty if it can already start
+	t.AddFunction("RetryIn", func(at time.Time) string {
+		left := time.Until(at).Round(time.Second)
+		if left <= 0 {
+			return ""
+		}
+
+		return left.String()
+	})
+
+	// empty for the times that haven't happened yet
+	t.AddFunction("FormatTime", func(at time.Time) string {
+		if at.IsZero() {
+			return ""
+		}
+
+		return at.Format("2006-01-02 15:04:05")
+	})
+
+	t.AddFunction("FormatDuration", func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	})
+
+	t.AddFunction("FormatBytes", progress.FormatBytes)
+
 	dir := filepath.Join(".", "views", "templates")
 	err = t.ParseGlob(fmt.Sprintf("%s/*.tmpl", dir))
 	if err != nil {
@@ -99,7 +182,17 @@ func (ws *Webserver) buildTemplates() *templates.Templates {
 func (ws *Webserver) buildRoutes() *http.ServeMux {
 	log.Println("Webserver: Building router")
 
-	ws.templates = ws.buildTemplates()
+	ws.templates = ws.buildTemplates(auth.RoleAdmin)
+
+	ws.pages = make(map[auth.Role]*templates.Templates)
+	for _, role := range auth.Roles() {
+		ws.pages[role] = ws.buildTemplates(role)
+	}
+
+	// what every route requires, checked once the sender has been identified
+	viewer := func(h http.HandlerFunc) http.HandlerFunc { return allow(auth.RoleViewer, h) }
+	operator := func(h http.HandlerFunc) http.HandlerFunc { return allow(auth.RoleOperator, h) }
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return allow(auth.RoleAdmin, h) }
 
 	mux := http.NewServeMux()
 
@@ -111,21 +204,109 @@ func (ws *Webserver) buildRoutes() *http.ServeMux {
 	mux.Handle("/js/", http.StripPrefix("/js/", http.FileServer(jsDir)))
 
 	// POST   /task { ids: []string }
-	mux.HandleFunc(fmt.Sprintf("POST %s/task", APIGroup), ws.handleTaskAdd)
+	mux.HandleFunc(fmt.Sprintf("POST %s/task", APIGroup), operator(ws.handleTaskAdd))
+	// OPTIONS /task, the CORS preflight of the userscript
+	mux.HandleFunc(fmt.Sprintf("OPTIONS %s/task", APIGroup), ws.handleTaskAdd)
 	// PATCH  /task { mode: "single|multiple|failed", ids: []string }
-	mux.HandleFunc(fmt.Sprintf("PATCH %s/task", APIGroup), ws.handleTaskUpdate)
+	mux.HandleFunc(fmt.Sprintf("PATCH %s/task", APIGroup), operator(ws.handleTaskUpdate))
 	// DELETE /task { mode: "single|multiple|queued|failed|succeeded", ids: []string }
-	mux.HandleFunc(fmt.Sprintf("DELETE %s/task", APIGroup), ws.handleTaskRemove)
-
-	mux.HandleFunc("GET /events-stream", ws.handleEventStream)
+	mux.HandleFunc(fmt.Sprintf("DELETE %s/task", APIGroup), operator(ws.handleTaskRemove))
+	// POST   /task/pause { ids: []string }
+	mux.HandleFunc(fmt.Sprintf("POST %s/pause", TaskGroup), operator(ws.handleTaskPause))
+	// POST   /task/resume { ids: []string }
+	mux.HandleFunc(fmt.Sprintf("POST %s/resume", TaskGroup), operator(ws.handleTaskResume))
+	// POST   /task/move { id: string, to: "top|bottom|before", before: string }
+	mux.HandleFunc(fmt.Sprintf("POST %s/move", TaskGroup), operator(ws.handleTaskMove))
+	// POST   /task/priority { ids: []string, priority: int }
+	mux.HandleFunc(fmt.Sprintf("POST %s/priority", TaskGroup), operator(ws.handleTaskPriority))
+	// GET    /task?state=&aggregator=&filehost=&category=&q=&sort=&order=&limit=&cursor=
+	mux.HandleFunc(fmt.Sprintf("GET %s/task", APIGroup), viewer(ws.handleTaskList))
+	// GET    /task/export?format=json|csv&IDs=
+	mux.HandleFunc(fmt.Sprintf("GET %s/export", TaskGroup), viewer(ws.handleTaskExport))
+	// POST   /task/import (JSON or CSV export, raw or in the File field)
+	mux.HandleFunc(fmt.Sprintf("POST %s/import", TaskGroup), operator(ws.handleTaskImport))
+	// GET    /task/search?q=&limit=
+	mux.HandleFunc(fmt.Sprintf("GET %s/search", TaskGroup), viewer(ws.handleTaskSearch))
+	// GET    /task/{id}
+	mux.HandleFunc(fmt.Sprintf("GET %s/{id}", TaskGroup), viewer(ws.handleTaskDetail))
+	// PUT    /task/{id}/tags { Tags: []string }
+	mux.HandleFunc(fmt.Sprintf("PUT %s/{id}/tags", TaskGroup), operator(ws.handleTaskTags))
+	// POST   /task/acknowledge { ids: []string }
+	mux.HandleFunc(fmt.Sprintf("POST %s/acknowledge", TaskGroup), operator(ws.handleTaskAcknowledge))
+
+	// GET    /archive?q=&limit=
+	mux.HandleFunc(fmt.Sprintf("GET %s", ArchiveGroup), viewer(ws.handleArchiveList))
+	// POST   /archive/{id}/redownload
+	mux.HandleFunc(fmt.Sprintf("POST %s/{id}/redownload", ArchiveGroup), operator(ws.handleArchiveRedownload))
+
+	// GET    /trash?q=&limit=
+	mux.HandleFunc(fmt.Sprintf("GET %s", TrashGroup), viewer(ws.handleTrashList))
+	// POST   /trash/undo { Batch: string }, the latest removal if empty
+	mux.HandleFunc(fmt.Sprintf("POST %s/undo", TrashGroup), operator(ws.handleTrashUndo))
+	// POST   /trash/{id}/restore
+	mux.HandleFunc(fmt.Sprintf("POST %s/{id}/restore", TrashGroup), operator(ws.handleTrashRestore))
+
+	// GET    /openapi.json, the description of the versioned API
+	mux.HandleFunc(fmt.Sprintf("GET %s/openapi.json", APIGroup), allowCORS(ws.handleOpenAPI))
+
+	// versioned JSON API, answering every item of a batch with its own status
+	//
+	// GET    /v1/tasks?state=&aggregator=&filehost=&category=&q=&sort=&order=&limit=&cursor=
+	mux.HandleFunc(fmt.Sprintf("GET %s/tasks", V1Group), allowCORS(viewer(ws.handleTaskList)))
+	// GET    /v1/tasks/{id}
+	mux.HandleFunc(fmt.Sprintf("GET %s/tasks/{id}", V1Group), allowCORS(viewer(ws.handleTaskDetail)))
+	// POST   /v1/tasks { Tasks: [{ Aggregator, Slug }] }
+	mux.HandleFunc(fmt.Sprintf("POST %s/tasks", V1Group), allowCORS(operator(ws.handleV1TaskCreate)))
+	// DELETE /v1/tasks { IDs: [] }
+	mux.HandleFunc(fmt.Sprintf("DELETE %s/tasks", V1Group), allowCORS(operator(ws.handleV1TaskDeleteBatch)))
+	// POST   /v1/tasks/retry { IDs: [] }
+	mux.HandleFunc(fmt.Sprintf("POST %s/tasks/retry", V1Group), allowCORS(operator(ws.handleV1TaskRetryBatch)))
+	// POST   /v1/tasks/abort { IDs: [] }
+	mux.HandleFunc(fmt.Sprintf("POST %s/tasks/abort", V1Group), allowCORS(operator(ws.handleV1TaskAbortBatch)))
+	// DELETE /v1/tasks/{id}
+	mux.HandleFunc(fmt.Sprintf("DELETE %s/tasks/{id}", V1Group), allowCORS(operator(ws.handleV1TaskDelete)))
+	// POST   /v1/tasks/{id}/retry
+	mux.HandleFunc(fmt.Sprintf("POST %s/tasks/{id}/retry", V1Group), allowCORS(operator(ws.handleV1TaskRetry)))
+	// POST   /v1/tasks/{id}/abort
+	mux.HandleFunc(fmt.Sprintf("POST %s/tasks/{id}/abort", V1Group), allowCORS(operator(ws.handleV1TaskAbort)))
+	// OPTIONS /v1/..., the CORS preflight
+	mux.HandleFunc(fmt.Sprintf("OPTIONS %s/", V1Group), allowCORS(nil))
+
+	// GET    /queue
+	mux.HandleFunc(fmt.Sprintf("GET %s", QueueGroup), viewer(ws.handleQueueStatus))
+	// POST   /queue/pause
+	mux.HandleFunc(fmt.Sprintf("POST %s/pause", QueueGroup), operator(ws.handleQueuePause))
+	// POST   /queue/resume
+	mux.HandleFunc(fmt.Sprintf("POST %s/resume", QueueGroup), operator(ws.handleQueueResume))
+
+	mux.HandleFunc("GET /events-stream", viewer(ws.handleEventStream))
+	// GET    /v1/events, typed JSON events for the scripts
+	mux.HandleFunc(fmt.Sprintf("GET %s/events", V1Group), allowCORS(viewer(ws.handleJSONEventStream)))
 
 	// handle hello test endpoint
 	mux.HandleFunc("/hello", ws.handleHelloRoute)
 
-	mux.HandleFunc("/", ws.handleIndexRoute)
+	mux.HandleFunc("GET /task/{id}", viewer(ws.handleTaskDetailPage))
+	mux.HandleFunc("POST /task/{id}/tags", operator(ws.handleTaskTagsForm))
+	mux.HandleFunc("GET /search", viewer(ws.handleSearchPage))
+	mux.HandleFunc("GET /tasks/ended", viewer(ws.handleEndedPage))
+	mux.HandleFunc("GET /history", viewer(ws.handleHistoryPage))
+	mux.HandleFunc("POST /history/{id}/redownload", operator(ws.handleHistoryRedownload))
+	mux.HandleFunc("GET /trash", viewer(ws.handleTrashPage))
+	mux.HandleFunc("POST /trash/{id}/restore", operator(ws.handleTrashRestoreForm))
+
+	// authentication, every other route requiring it when enabled
+	mux.HandleFunc("GET /login", ws.handleLoginPage)
+	mux.HandleFunc("POST /login", ws.handleLogin)
+	mux.HandleFunc("POST /logout", ws.handleLogout)
+	mux.HandleFunc("GET /tokens", admin(ws.handleTokensPage))
+	mux.HandleFunc("POST /tokens", admin(ws.handleTokenCreate))
+	mux.HandleFunc("POST /tokens/{name}/revoke", admin(ws.handleTokenRevoke))
+
+	mux.HandleFunc("/", viewer(ws.handleIndexRoute))
 
-	// maintenance
-	mux.HandleFunc(fmt.Sprintf("POST %s/restart", InternalGroup), ws.handleRestartServer)
+	// maintenance, for the admins only
+	mux.HandleFunc(fmt.Sprintf("POST %s/restart", InternalGroup), admin(ws.handleRestartServer))
 
 	return mux
 }
@@ -138,7 +319,7 @@ func (ws *Webserver) Start() error {
 	netAddr := fmt.Sprintf("%s:%d", ws.address, ws.port)
 
 	ws.httpServer.Addr = netAddr
-	ws.httpServer.Handler = mux
+	ws.httpServer.Handler = ws.requireAuth(mux)
 
 	go func() {
 		defer func() {
@@ -199,6 +380,7 @@ func (ws *Webserver) Shutdown(ctx context.Context) {
 	log.Println("Webserver: Started shutdown procedure")
 
 	ws.connections.Shutdown()
+	ws.jsonConnections.Shutdown()
 
 	ws.closeUpdater <- struct{}{}
 	close(ws.closeUpdater)
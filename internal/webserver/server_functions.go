@@ -4,20 +4,25 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"runtime"
 	"syscall"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
 )
 
 type IndexData struct {
-	Data any
-	Size int
+	Data  any
+	Ended *EndedPage
+	Size  int
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
-	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 
 	return json.NewEncoder(w).Encode(v)
 }
@@ -55,17 +60,33 @@ func (ws *Webserver) handleIndexRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	taskEntries, err := ws.engine.DB().GetAll()
+	// the queue and the running tasks are always shown in full,
+	// while the ended ones are loaded a page at a time
+	pending, err := ws.engine.DB().Query(db.TaskQuery{
+		States: []int{
+			states.TASK_STATE_QUEUED,
+			states.TASK_STATE_PAUSED,
+			states.TASK_STATE_RUNNING,
+		},
+	})
 	if err != nil {
 		ws.handleInternalServerError(w, r, err.Error())
+		return
+	}
+
+	ended, err := ws.endedPage(url.Values{})
+	if err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+		return
 	}
 
 	data := &IndexData{
-		Data: taskEntries,
-		Size: len(taskEntries),
+		Data:  pending.Tasks,
+		Ended: ended,
+		Size:  pending.Total + ended.Total,
 	}
 
-	err = ws.templates.ExecuteWithWriter(w, "index", data)
+	err = ws.pageTemplates(r).ExecuteWithWriter(w, "index", data)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -1,13 +1,17 @@
 package v2
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
 	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
 	"github.com/relepega/doujinstyle-downloader/internal/task"
 	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
 )
@@ -22,6 +26,17 @@ type TaskEvent struct {
 	GroupAction string `json:"GroupAction"`
 }
 
+// Parses the optional error category used to filter the "failed" modes
+func parseCategory(r *http.Request) (dsdlerr.Category, error) {
+	c := dsdlerr.Category(strings.TrimSpace(r.FormValue("Category")))
+
+	if c != "" && !dsdlerr.IsValidCategory(string(c)) {
+		return "", fmt.Errorf("Not a valid error category: %q", c)
+	}
+
+	return c, nil
+}
+
 func isValidMode(m string, ms []string) bool {
 	isValid := false
 	for _, v := range ms {
@@ -47,7 +62,7 @@ func (ws *Webserver) handleTaskAdd(w http.ResponseWriter, r *http.Request) {
 	// enable cors access for this endpoint only
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST") // can be multiple like this "GET, POST"
-	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 
 	// handle preflight
 	if r.Method == http.MethodOptions {
@@ -87,21 +102,21 @@ func (ws *Webserver) handleTaskAdd(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		// set values to struct fields
-		newTask := task.NewTask(slug)
-		newTask.Aggregator = service
-
-		if strings.HasPrefix(slug, "http") {
-			newTask.AggregatorPageURL = slug
-		}
-
 		// add task to ws.engine
-		_, err := ws.engine.DB().Insert(newTask)
+		newTask, err := ws.engine.AddTask(service, slug)
 		if err != nil {
-			happenedErrors = append(happenedErrors, err.Error())
+			var dup *dsdl.DuplicateError
+			if errors.As(err, &dup) {
+				happenedErrors = append(happenedErrors, fmt.Sprintf("%s: %s", slug, dup.Reason()))
+			} else {
+				happenedErrors = append(happenedErrors, err.Error())
+			}
+
 			continue
 		}
 
+		ws.publishTaskEvent(EventTaskCreated, newTask)
+
 		// render template
 		t, err := ws.templates.Execute("task", newTask)
 		if err != nil {
@@ -116,6 +131,7 @@ func (ws *Webserver) handleTaskAdd(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(happenedErrors) != 0 {
+		ws.publishError(fmt.Errorf("%+v", happenedErrors))
 		ws.msgChan <- sse.NewSSEBuilder().Event("error").Data(fmt.Errorf("%+v", happenedErrors).Error()).Build()
 	}
 
@@ -129,6 +145,12 @@ func (ws *Webserver) handleTaskUpdate(w http.ResponseWriter, r *http.Request) {
 	mode := strings.TrimSpace(r.FormValue("Mode"))
 	// fmt.Println("mode", mode)
 
+	category, err := parseCategory(r)
+	if err != nil {
+		ws.handleError(w, err)
+		return
+	}
+
 	var happenedErrors []string
 
 	proc := func(t *task.Task) {
@@ -140,6 +162,8 @@ func (ws *Webserver) handleTaskUpdate(w http.ResponseWriter, r *http.Request) {
 
 		t.DownloadState = newState
 
+		ws.publishTaskEvent(EventTaskStateChanged, t)
+
 		tmpl, err := ws.templates.Execute("task", t)
 		if err != nil {
 			happenedErrors = append(happenedErrors, err.Error())
@@ -179,13 +203,14 @@ func (ws *Webserver) handleTaskUpdate(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "failed":
-		nodes, err := ws.engine.DB().GetAllWithState(states.TASK_STATE_COMPLETED)
+		nodes, err := ws.engine.DB().GetAllWithState(states.TASK_STATE_FAILED)
 		if err != nil {
 			ws.handleError(w, err)
+			return
 		}
 
 		for _, t := range nodes {
-			if t.Err == nil {
+			if category != "" && t.ErrCategory() != category {
 				continue
 			}
 
@@ -208,30 +233,24 @@ func (ws *Webserver) handleTaskUpdate(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w)
 }
 
+/*
+Moves the tasks picked by the mode to the trash. Every request is a single removal,
+which the clients are offered to undo through a "removed" event
+*/
 func (ws *Webserver) handleTaskRemove(w http.ResponseWriter, r *http.Request) {
 	taskIDs := r.FormValue("IDs")
 	mode := strings.TrimSpace(r.FormValue("Mode"))
 
-	var happenedErrors []string
-
-	proc := func(division string, state int) {
-		tasks, err := ws.engine.DB().GetAllWithState(state)
-		if err != nil {
-			ws.handleInternalServerError(w, r, err.Error())
-			return
-		}
-
-		t, _ := ws.templates.Execute(division+"_tasks", tasks)
+	category, err := parseCategory(r)
+	if err != nil {
+		ws.handleError(w, err)
+		return
+	}
 
-		uievt := sse.NewUIEventBuilder().
-			Event(sse.UIEvent_ReplaceNodeContent).
-			ReceiverNodeSelector(division).
-			Content(t).
-			Position(sse.UIRenderPos_AfterBegin).
-			Build()
+	ws.removeMu.Lock()
+	defer ws.removeMu.Unlock()
 
-		ws.msgChan <- sse.NewSSEBuilder().Event("update-node-content").Data(uievt).Build()
-	}
+	var removed int
 
 	switch mode {
 	case "single", "multiple":
@@ -242,53 +261,80 @@ func (ws *Webserver) handleTaskRemove(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		idList := strings.SplitSeq(taskIDs, delimiter)
+		var ids []string
 
-		for id := range idList {
-			err := ws.engine.DB().RemoveFromID(id)
-			if err != nil {
-				happenedErrors = append(happenedErrors, err.Error())
-				continue
+		for id := range strings.SplitSeq(taskIDs, delimiter) {
+			if id != "" {
+				ids = append(ids, id)
 			}
+		}
+
+		// the running tasks are stopped first
+		removed, err = ws.engine.RemoveTasks(ids)
+		if errors.Is(err, dsdl.ErrTaskStopping) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			ws.handleError(w, err)
+			return
+		}
 
+		for _, id := range ids {
 			ws.msgChan <- sse.NewSSEBuilder().Event("remove-node").Data(id).Build()
 		}
 
 	case "queued":
-		_, err := ws.engine.DB().RemoveFromState(states.TASK_STATE_QUEUED)
+		removed, err = ws.engine.DB().RemoveFromState(states.TASK_STATE_QUEUED)
 		if err != nil {
 			ws.handleError(w, err)
 			return
 		}
 
-		proc("queued", states.TASK_STATE_QUEUED)
+		if err := ws.renderQueue(); err != nil {
+			ws.handleError(w, err)
+			return
+		}
 
 	case "completed":
-		_, err := ws.engine.DB().RemoveFromState(states.TASK_STATE_COMPLETED)
+		removed, err = ws.engine.DB().RemoveEnded()
 		if err != nil {
 			ws.handleError(w, err)
 			return
 		}
 
-		proc("ended", states.TASK_STATE_COMPLETED)
+		if err := ws.renderEnded(); err != nil {
+			ws.handleError(w, err)
+			return
+		}
 
 	case "failed":
-		_, err := ws.engine.DB().RemoveCompletedWithErr()
+		if category != "" {
+			removed, err = ws.engine.DB().RemoveFailedWithErrCategory(category)
+		} else {
+			removed, err = ws.engine.DB().RemoveFromState(states.TASK_STATE_FAILED)
+		}
 		if err != nil {
 			ws.handleError(w, err)
 			return
 		}
 
-		proc("ended", states.TASK_STATE_COMPLETED)
+		if err := ws.renderEnded(); err != nil {
+			ws.handleError(w, err)
+			return
+		}
 
 	case "succeeded":
-		_, err := ws.engine.DB().RemoveCompletedNoErr()
+		removed, err = ws.engine.DB().RemoveFromState(states.TASK_STATE_COMPLETED)
 		if err != nil {
 			ws.handleError(w, err)
 			return
 		}
 
-		proc("ended", states.TASK_STATE_COMPLETED)
+		if err := ws.renderEnded(); err != nil {
+			ws.handleError(w, err)
+			return
+		}
 
 	default:
 		w.WriteHeader(http.StatusBadRequest)
@@ -296,6 +342,204 @@ func (ws *Webserver) handleTaskRemove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if removed != 0 {
+		if err := ws.announceRemoval(); err != nil {
+			log.Println("WebServer: Couldn't announce the removal:", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w)
+}
+
+// Renders a task and moves its node at the end of the receiver division
+func (ws *Webserver) moveTaskNode(t *task.Task, receiverSelector string) error {
+	ws.publishTaskEvent(EventTaskStateChanged, t)
+
+	tmpl, err := ws.templates.Execute("task", t)
+	if err != nil {
+		return err
+	}
+
+	uievt := sse.NewUIEventBuilder().
+		Event(sse.UIEvent_ReplaceNode).
+		TargetNodeID(t.Id).
+		ReceiverNodeSelector(receiverSelector).
+		Content(appUtils.CleanString(tmpl)).
+		Position(sse.UIRenderPos_BeforeEnd).
+		Build()
+
+	ws.msgChan <- sse.NewSSEBuilder().Event("replace-node").Data(uievt).Build()
+
+	return nil
+}
+
+func (ws *Webserver) handleTaskPause(w http.ResponseWriter, r *http.Request) {
+	ws.handleTaskPauseToggle(w, r, ws.engine.PauseTask)
+}
+
+func (ws *Webserver) handleTaskResume(w http.ResponseWriter, r *http.Request) {
+	ws.handleTaskPauseToggle(w, r, ws.engine.ResumeTask)
+}
+
+func (ws *Webserver) handleTaskPauseToggle(
+	w http.ResponseWriter,
+	r *http.Request,
+	toggle func(id string) (*task.Task, error),
+) {
+	taskIDs := r.FormValue("IDs")
+
+	delimiter := "|"
+
+	if taskIDs == "" || taskIDs == delimiter {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "At least one Album ID is required")
+		return
+	}
+
+	var happenedErrors []string
+
+	for id := range strings.SplitSeq(taskIDs, delimiter) {
+		t, err := toggle(id)
+		if err != nil {
+			happenedErrors = append(happenedErrors, err.Error())
+			continue
+		}
+
+		// running tasks are moved by their runner once they are actually stopped
+		if t.DownloadState == states.TASK_STATE_RUNNING {
+			continue
+		}
+
+		err = ws.moveTaskNode(t, "#queued")
+		if err != nil {
+			happenedErrors = append(happenedErrors, err.Error())
+		}
+	}
+
+	if len(happenedErrors) != 0 {
+		ws.handleError(w, fmt.Errorf("%+v", happenedErrors))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w)
+}
+
+// Re-renders the whole content of a task division ("queued", "active" or "ended")
+func (ws *Webserver) renderDivision(division string, tasks []*task.Task) {
+	t, _ := ws.templates.Execute(division+"_tasks", tasks)
+
+	uievt := sse.NewUIEventBuilder().
+		Event(sse.UIEvent_ReplaceNodeContent).
+		ReceiverNodeSelector(division).
+		Content(t).
+		Position(sse.UIRenderPos_AfterBegin).
+		Build()
+
+	ws.msgChan <- sse.NewSSEBuilder().Event("update-node-content").Data(uievt).Build()
+}
+
+// Re-renders the queued division, which also holds the paused tasks
+func (ws *Webserver) renderQueue() error {
+	// the division template picks the tasks it shows, in queue order
+	tasks, err := ws.engine.DB().GetAll()
+	if err != nil {
+		return err
+	}
+
+	ws.renderDivision("queued", tasks)
+
+	return nil
+}
+
+func (ws *Webserver) handleTaskMove(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.FormValue("ID"))
+	to := strings.TrimSpace(r.FormValue("To"))
+
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "An Album ID is required")
+		return
+	}
+
+	var err error
+
+	switch to {
+	case "top":
+		err = ws.engine.DB().MoveToTop(id)
+
+	case "bottom":
+		err = ws.engine.DB().MoveToBottom(id)
+
+	case "before":
+		before := strings.TrimSpace(r.FormValue("Before"))
+		if before == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "The ID of the following task is required")
+			return
+		}
+
+		err = ws.engine.DB().MoveBefore(id, before)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Not a valid destination")
+		return
+	}
+
+	if err != nil {
+		ws.handleError(w, err)
+		return
+	}
+
+	err = ws.renderQueue()
+	if err != nil {
+		ws.handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w)
+}
+
+func (ws *Webserver) handleTaskPriority(w http.ResponseWriter, r *http.Request) {
+	taskIDs := r.FormValue("IDs")
+
+	delimiter := "|"
+
+	if taskIDs == "" || taskIDs == delimiter {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "At least one Album ID is required")
+		return
+	}
+
+	priority, err := strconv.Atoi(strings.TrimSpace(r.FormValue("Priority")))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Not a valid priority")
+		return
+	}
+
+	var happenedErrors []string
+
+	for id := range strings.SplitSeq(taskIDs, delimiter) {
+		err := ws.engine.DB().SetPriority(id, priority)
+		if err != nil {
+			happenedErrors = append(happenedErrors, err.Error())
+		}
+	}
+
+	err = ws.renderQueue()
+	if err != nil {
+		happenedErrors = append(happenedErrors, err.Error())
+	}
+
+	if len(happenedErrors) != 0 {
+		ws.handleError(w, fmt.Errorf("%+v", happenedErrors))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w)
 }
@@ -0,0 +1,155 @@
+package v2
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// A task as returned by the API
+type TaskSummary struct {
+	ID                string   `json:"ID"`
+	Aggregator        string   `json:"Aggregator"`
+	Slug              string   `json:"Slug"`
+	AggregatorPageURL string   `json:"AggregatorPageURL"`
+	AlbumID           string   `json:"AlbumID,omitempty"`
+	FilehostUrl       string   `json:"FilehostUrl"`
+	Filehost          string   `json:"Filehost,omitempty"`
+	FileID            string   `json:"FileID,omitempty"`
+	DisplayName       string   `json:"DisplayName"`
+	Filename          string   `json:"Filename"`
+	State             string   `json:"State"`
+	Err               string   `json:"Err,omitempty"`
+	ErrCategory       string   `json:"ErrCategory,omitempty"`
+	Priority          int      `json:"Priority"`
+	Tags              []string `json:"Tags"`
+
+	CreatedAt  time.Time `json:"CreatedAt"`
+	StartedAt  time.Time `json:"StartedAt,omitzero"`
+	FinishedAt time.Time `json:"FinishedAt,omitzero"`
+	// When a failure has been acknowledged, when the task has been archived and when it has been removed
+	AcknowledgedAt time.Time `json:"AcknowledgedAt,omitzero"`
+	ArchivedAt     time.Time `json:"ArchivedAt,omitzero"`
+	DeletedAt      time.Time `json:"DeletedAt,omitzero"`
+	// Milliseconds between the first start and the end of the task, or now if it is still running
+	DurationMs int64 `json:"DurationMs"`
+}
+
+// Everything known about a task, including its attempts and timeline
+type TaskDetail struct {
+	TaskSummary
+
+	Attempts []*task.Attempt `json:"Attempts"`
+	Events   []*task.Event   `json:"Events"`
+}
+
+func (s *TaskSummary) Duration() time.Duration {
+	return time.Duration(s.DurationMs) * time.Millisecond
+}
+
+func newTaskSummary(t *task.Task) TaskSummary {
+	s := TaskSummary{
+		ID:                t.Id,
+		Aggregator:        t.Aggregator,
+		Slug:              t.Slug,
+		AggregatorPageURL: t.AggregatorPageURL,
+		AlbumID:           t.AlbumID,
+		FilehostUrl:       t.FilehostUrl,
+		Filehost:          t.Filehost,
+		FileID:            t.FileID,
+		DisplayName:       t.DisplayName,
+		Filename:          t.Filename,
+		State:             states.GetStateStr(t.DownloadState),
+		ErrCategory:       string(t.ErrCategory()),
+		Priority:          t.Priority,
+		Tags:              t.Tags,
+		CreatedAt:         t.CreatedAt,
+		StartedAt:         t.StartedAt,
+		FinishedAt:        t.FinishedAt,
+		AcknowledgedAt:    t.AcknowledgedAt,
+		ArchivedAt:        t.ArchivedAt,
+		DeletedAt:         t.DeletedAt,
+		DurationMs:        t.Duration().Milliseconds(),
+	}
+
+	if t.Err != nil {
+		s.Err = t.Err.Error()
+	}
+
+	return s
+}
+
+// Collects the detail of a task, queued, archived or trashed, returning sql.ErrNoRows if it doesn't exist
+func (ws *Webserver) taskDetail(id string) (*TaskDetail, error) {
+	t, err := ws.engine.DB().Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		t, err = ws.engine.DB().GetArchived(id)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		t, err = ws.engine.DB().GetTrashed(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	summary := newTaskSummary(t)
+
+	// the running copy holds fresher data than the stored one, except for the tags
+	if active, ok := ws.engine.ActiveTask(id); ok {
+		summary = newTaskSummary(active)
+		summary.Tags = t.Tags
+	}
+
+	attempts, err := ws.engine.DB().GetAttempts(id)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := ws.engine.DB().GetEvents(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TaskDetail{
+		TaskSummary: summary,
+		Attempts:    attempts,
+		Events:      events,
+	}, nil
+}
+
+// GET /api/task/{id}
+func (ws *Webserver) handleTaskDetail(w http.ResponseWriter, r *http.Request) {
+	d, err := ws.taskDetail(r.PathValue("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"Error": "Task not found"})
+		return
+	}
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, d)
+}
+
+// GET /task/{id}
+func (ws *Webserver) handleTaskDetailPage(w http.ResponseWriter, r *http.Request) {
+	d, err := ws.taskDetail(r.PathValue("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		ws.handleNotFound(w, r)
+		return
+	}
+	if err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+		return
+	}
+
+	err = ws.pageTemplates(r).ExecuteWithWriter(w, "task_detail", d)
+	if err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+	}
+}
@@ -0,0 +1,216 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/appUtils"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/db/states"
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl/dsdlerr"
+	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
+)
+
+const (
+	// Tasks returned by a listing when no limit is requested
+	defaultPageSize = 50
+	// Upper bound of the limit of a listing
+	maxPageSize = 500
+)
+
+type TaskList struct {
+	Tasks []TaskSummary `json:"Tasks"`
+	// Pass it as the cursor parameter to get the following page. Empty on the last page
+	NextCursor string `json:"NextCursor"`
+	// Number of tasks matching the filters, across every page
+	Total int `json:"Total"`
+}
+
+// A page of ended tasks, along with the filters that produced it so that the next page keeps them
+type EndedPage struct {
+	*db.TaskPage
+	Filters url.Values
+}
+
+// Returns the URL of the page following this one
+func (p *EndedPage) NextURL() string {
+	v := url.Values{}
+	for k, vs := range p.Filters {
+		v[k] = vs
+	}
+
+	v.Set("cursor", p.NextCursor)
+
+	return "/tasks/ended?" + v.Encode()
+}
+
+/*
+Reads a task listing from the query string:
+
+	state     state name, repeatable or comma separated (e.g. "failed,canceled")
+	aggregator, filehost, category, q
+	sort      "queue", "created", "finished" or "name"
+	order     "asc" or "desc"
+	limit     page size, defaultPageSize if missing
+	cursor    NextCursor of the previous page
+*/
+func parseTaskQuery(v url.Values) (db.TaskQuery, error) {
+	q := db.TaskQuery{
+		Aggregator:  strings.TrimSpace(v.Get("aggregator")),
+		Filehost:    strings.TrimSpace(v.Get("filehost")),
+		ErrCategory: dsdlerr.Category(strings.TrimSpace(v.Get("category"))),
+		Text:        strings.TrimSpace(v.Get("q")),
+		Sort:        db.SortKey(strings.TrimSpace(v.Get("sort"))),
+		Cursor:      strings.TrimSpace(v.Get("cursor")),
+		Limit:       defaultPageSize,
+	}
+
+	for _, param := range v["state"] {
+		for name := range strings.SplitSeq(param, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			state, ok := states.FromString(name)
+			if !ok {
+				return q, fmt.Errorf("Not a valid state: %q", name)
+			}
+
+			q.States = append(q.States, state)
+		}
+	}
+
+	if q.ErrCategory != "" && !dsdlerr.IsValidCategory(string(q.ErrCategory)) {
+		return q, fmt.Errorf("Not a valid error category: %q", q.ErrCategory)
+	}
+
+	if q.Sort != "" && !db.IsValidSortKey(string(q.Sort)) {
+		return q, fmt.Errorf("Not a valid sort key: %q", q.Sort)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(v.Get("order"))) {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("Not a valid order: %q", v.Get("order"))
+	}
+
+	if limit := strings.TrimSpace(v.Get("limit")); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("Not a valid limit: %q", limit)
+		}
+
+		q.Limit = min(n, maxPageSize)
+	}
+
+	return q, nil
+}
+
+// GET /api/task
+func (ws *Webserver) handleTaskList(w http.ResponseWriter, r *http.Request) {
+	q, err := parseTaskQuery(r.URL.Query())
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	page, err := ws.engine.DB().Query(q)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	list := &TaskList{
+		Tasks:      make([]TaskSummary, 0, len(page.Tasks)),
+		NextCursor: page.NextCursor,
+		Total:      page.Total,
+	}
+
+	for _, t := range page.Tasks {
+		list.Tasks = append(list.Tasks, newTaskSummary(t))
+	}
+
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// Returns a page of ended tasks, most recently ended first, filtered by the "state", "category" and "q" values
+func (ws *Webserver) endedPage(v url.Values) (*EndedPage, error) {
+	filters := url.Values{}
+	for _, k := range []string{"state", "category", "q"} {
+		if val := strings.TrimSpace(v.Get(k)); val != "" {
+			filters.Set(k, val)
+		}
+	}
+
+	q, err := parseTaskQuery(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// only ended states can be picked
+	if len(q.States) == 0 {
+		q.States = states.EndedStates()
+	}
+
+	for _, s := range q.States {
+		if !states.IsEnded(s) {
+			return nil, fmt.Errorf("Not an ended state: %s", states.GetStateStr(s))
+		}
+	}
+
+	q.Sort = db.SortFinished
+	q.Desc = true
+	q.Cursor = strings.TrimSpace(v.Get("cursor"))
+
+	page, err := ws.engine.DB().Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EndedPage{TaskPage: page, Filters: filters}, nil
+}
+
+// GET /tasks/ended, the HTML of a page of the ended division
+func (ws *Webserver) handleEndedPage(w http.ResponseWriter, r *http.Request) {
+	page, err := ws.endedPage(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err.Error())
+		return
+	}
+
+	err = ws.pageTemplates(r).ExecuteWithWriter(w, "ended_page", page)
+	if err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+	}
+}
+
+// Re-renders the first page of the ended division on every client
+func (ws *Webserver) renderEnded() error {
+	page, err := ws.endedPage(url.Values{})
+	if err != nil {
+		return err
+	}
+
+	t, err := ws.templates.Execute("ended_page", page)
+	if err != nil {
+		return err
+	}
+
+	uievt := sse.NewUIEventBuilder().
+		Event(sse.UIEvent_ReplaceNodeContent).
+		ReceiverNodeSelector("ended").
+		Content(appUtils.CleanString(t)).
+		Position(sse.UIRenderPos_AfterBegin).
+		Build()
+
+	ws.msgChan <- sse.NewSSEBuilder().Event("update-node-content").Data(uievt).Build()
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package v2
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/task"
+)
+
+// Largest file accepted by the import
+const maxImportSize = 10 << 20
+
+type ImportReport struct {
+	Results    []dsdl.ImportResult `json:"Results"`
+	Inserted   int                 `json:"Inserted"`
+	Duplicates int                 `json:"Duplicates"`
+	Invalid    int                 `json:"Invalid"`
+}
+
+// GET /api/task/export?format=json|csv&IDs=id1|id2, every task if there are no IDs
+func (ws *Webserver) handleTaskExport(w http.ResponseWriter, r *http.Request) {
+	format, err := dsdl.ParseExportFormat(r.FormValue("format"))
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	var tasks []*task.Task
+
+	if ids := strings.TrimSpace(r.FormValue("IDs")); ids != "" {
+		for id := range strings.SplitSeq(ids, "|") {
+			if id == "" {
+				continue
+			}
+
+			t, err := ws.engine.DB().Get(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				WriteJSON(w, http.StatusNotFound, map[string]string{"Error": "Task not found: " + id})
+				return
+			}
+			if err != nil {
+				WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+				return
+			}
+
+			tasks = append(tasks, t)
+		}
+	} else {
+		tasks, err = ws.engine.DB().GetAll()
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+			return
+		}
+	}
+
+	// written to a buffer first, so that an error can still change the status
+	var buf bytes.Buffer
+
+	if err := dsdl.Export(&buf, tasks, format); err != nil {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	contentType := "application/json"
+	if format == dsdl.ExportCSV {
+		contentType = "text/csv; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf(`attachment; filename="tasks-%s.%s"`, time.Now().Format("20060102-150405"), format),
+	)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+/*
+POST /api/task/import
+
+The body is either a file made by the export, in JSON or CSV, or a multipart form
+holding it in the "File" field. Replies with the outcome of every task
+*/
+func (ws *Webserver) handleTaskImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var body io.Reader = r.Body
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("File")
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": "The File field is required"})
+			return
+		}
+		defer f.Close()
+
+		body = f
+	}
+
+	exported, err := dsdl.ReadExport(body)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	report := &ImportReport{Results: ws.engine.Import(exported)}
+
+	for _, res := range report.Results {
+		switch res.Status {
+		case dsdl.ImportInserted:
+			report.Inserted++
+		case dsdl.ImportDuplicate:
+			report.Duplicates++
+		default:
+			report.Invalid++
+		}
+	}
+
+	log.Printf(
+		"WebServer: Import: %d inserted, %d duplicates, %d invalid\n",
+		report.Inserted,
+		report.Duplicates,
+		report.Invalid,
+	)
+
+	if report.Inserted != 0 {
+		if err := ws.renderQueue(); err != nil {
+			log.Println("WebServer: Import:", err)
+		}
+	}
+
+	WriteJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,190 @@
+package v2
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/relepega/doujinstyle-downloader/internal/dsdl"
+	"github.com/relepega/doujinstyle-downloader/internal/webserver/sse"
+)
+
+// Sent to the clients after a removal, so that they can offer to undo it
+type RemovalNotice struct {
+	Batch string `json:"Batch"`
+	Count int    `json:"Count"`
+}
+
+// Tells the clients about the latest removal through a "removed" event, and a task.removed
+// event of the JSON stream for every removed task
+//
+// Must be called with ws.removeMu held, so that no other removal happened in the meantime
+func (ws *Webserver) announceRemoval() error {
+	batch, err := ws.engine.DB().LastTrashBatch()
+	if err != nil {
+		return err
+	}
+
+	if err := ws.publishRemoval(batch.ID); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(RemovalNotice{Batch: batch.ID, Count: batch.Count})
+	if err != nil {
+		return err
+	}
+
+	ws.msgChan <- sse.NewSSEBuilder().Event("removed").Data(string(data)).Build()
+
+	return nil
+}
+
+// Lists the trashed tasks matching the "q" value, every trashed task if it is empty,
+// returning at most "limit" tasks (defaultPageSize if missing)
+func (ws *Webserver) searchTrash(r *http.Request) (*SearchResults, error) {
+	return ws.searchStored(r, ws.engine.DB().SearchTrash)
+}
+
+// GET /api/trash?q=&limit=
+func (ws *Webserver) handleTrashList(w http.ResponseWriter, r *http.Request) {
+	res, err := ws.searchTrash(r)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, res)
+}
+
+// GET /trash, the removed tasks, optionally filtered by a search
+func (ws *Webserver) handleTrashPage(w http.ResponseWriter, r *http.Request) {
+	res, err := ws.searchTrash(r)
+	if err != nil {
+		res.Err = err.Error()
+	}
+
+	err = ws.pageTemplates(r).ExecuteWithWriter(w, "trash", res)
+	if err != nil {
+		ws.handleInternalServerError(w, r, err.Error())
+	}
+}
+
+// Re-renders the divisions restored tasks may have gone back to
+func (ws *Webserver) renderRestored() {
+	if err := ws.renderQueue(); err != nil {
+		log.Println("WebServer: Restore:", err)
+	}
+
+	if err := ws.renderEnded(); err != nil {
+		log.Println("WebServer: Restore:", err)
+	}
+}
+
+// POST /api/trash/undo { Batch: string }, restoring the tasks of a removal, the latest one if Batch is empty
+func (ws *Webserver) handleTrashUndo(w http.ResponseWriter, r *http.Request) {
+	batch := strings.TrimSpace(r.FormValue("Batch"))
+
+	if batch == "" {
+		last, err := ws.engine.DB().LastTrashBatch()
+		if errors.Is(err, sql.ErrNoRows) {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"Error": "The trash is empty"})
+			return
+		}
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+			return
+		}
+
+		batch = last.ID
+	}
+
+	// the duplicates stay in the trash: only the tasks back in the queue are announced
+	trashed, err := ws.engine.DB().GetTrashBatch(batch)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	restored, err := ws.engine.DB().RestoreBatch(batch)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	if restored != 0 {
+		log.Printf("WebServer: Restored %d removed tasks\n", restored)
+
+		for _, tr := range trashed {
+			if t, err := ws.engine.DB().Get(tr.Id); err == nil {
+				ws.publishTaskEvent(EventTaskCreated, t)
+			}
+		}
+
+		ws.renderRestored()
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]int{"Restored": restored})
+}
+
+// Restores a trashed task, returning the HTTP status and the message of an eventual error
+func (ws *Webserver) restore(id string) (int, error) {
+	t, err := ws.engine.Restore(id)
+
+	var dup *dsdl.DuplicateError
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, fmt.Errorf("Removed task not found")
+	case errors.As(err, &dup):
+		return http.StatusConflict, fmt.Errorf("The album is %s", dup.Reason())
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+
+	log.Printf("WebServer: Restored the removed task %s\n", t.Id)
+
+	ws.publishTaskEvent(EventTaskCreated, t)
+
+	ws.renderRestored()
+
+	return http.StatusOK, nil
+}
+
+// POST /api/trash/{id}/restore
+func (ws *Webserver) handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if status, err := ws.restore(id); err != nil {
+		WriteJSON(w, status, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	t, err := ws.engine.DB().Get(id)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, newTaskSummary(t))
+}
+
+// POST /trash/{id}/restore, the form of the trash and task pages
+func (ws *Webserver) handleTrashRestoreForm(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	status, err := ws.restore(id)
+	if status == http.StatusNotFound {
+		ws.handleNotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	http.Redirect(w, r, "/task/"+id, http.StatusSeeOther)
+}
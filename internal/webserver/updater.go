@@ -19,6 +19,8 @@ func (ws *Webserver) sseMessageBroker() {
 
 	subscriber := publisher.Subscribe()
 
+	go ws.jsonEventBroker(publisher.Subscribe())
+
 	log.Println("Webserver: Broker started")
 
 	for {
@@ -32,6 +34,8 @@ func (ws *Webserver) sseMessageBroker() {
 
 		case msg, ok := <-subscriber:
 			if !ok {
+				// the publisher has been closed: wait for the shutdown signal
+				subscriber = nil
 				continue
 			}
 
@@ -78,6 +82,31 @@ func (ws *Webserver) sseMessageBroker() {
 
 				ws.msgChan <- e
 
+			case "requeue-task":
+				t, err := ws.templates.Execute("task", msg.Data)
+				if err != nil {
+					e := sse.NewSSEBuilder().Event("error").Data(err.Error()).Build()
+					ws.msgChan <- e
+					continue
+				}
+
+				nodeId := msg.Data.(*task.Task).ID()
+
+				uievt := sse.NewUIEventBuilder().
+					Event(sse.UIEvent_ReplaceNode).
+					TargetNodeID(nodeId).
+					ReceiverNodeSelector("#queued").
+					Content(appUtils.CleanString(t)).
+					Position(sse.UIRenderPos_BeforeEnd).
+					Build()
+
+				e := sse.NewSSEBuilder().
+					Event("replace-node").
+					Data(uievt).
+					Build()
+
+				ws.msgChan <- e
+
 			case "mark-task-as-done":
 				t, err := ws.templates.Execute("task", msg.Data)
 				if err != nil {
@@ -130,14 +159,18 @@ func (ws *Webserver) sseMessageBroker() {
 
 				ws.msgChan <- e
 
+			// the retention moved some ended tasks to the archive
+			case "archived-tasks":
+				if err := ws.renderEnded(); err != nil {
+					ws.msgChan <- sse.NewSSEBuilder().Event("error").Data(err.Error()).Build()
+				}
+
 			case "error":
 				e := sse.NewSSEBuilder().Event("error").Data(msg.Data.(error).Error()).Build()
 				ws.msgChan <- e
 
 			default:
 			}
-
-		default:
 		}
 	}
 }
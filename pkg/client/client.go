@@ -0,0 +1,344 @@
+/*
+Package client talks to the versioned JSON API of a doujinstyle-downloader
+instance, as described by its /api/openapi.json document.
+
+	c := client.New("http://localhost:5151")
+	c.Token = os.Getenv("DSDL_TOKEN")
+
+	results, err := c.AddTasks(ctx, client.NewTask{Aggregator: "doujinstyle", Slug: "22816"})
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path of the versioned API, relative to the base URL of the instance
+const apiPath = "/api/v1"
+
+type Client struct {
+	// Address of the instance, e.g. "http://localhost:5151"
+	BaseURL string
+	// API token sent as a bearer token, needed when the instance requires authentication
+	Token string
+	// Used for every request, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Returned when the server answers a whole request with an error
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("client: %d %s", e.Status, http.StatusText(e.Status))
+	}
+
+	return fmt.Sprintf("client: %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+type Task struct {
+	ID                string   `json:"ID"`
+	Aggregator        string   `json:"Aggregator"`
+	Slug              string   `json:"Slug"`
+	AggregatorPageURL string   `json:"AggregatorPageURL"`
+	AlbumID           string   `json:"AlbumID,omitempty"`
+	FilehostUrl       string   `json:"FilehostUrl"`
+	Filehost          string   `json:"Filehost,omitempty"`
+	FileID            string   `json:"FileID,omitempty"`
+	DisplayName       string   `json:"DisplayName"`
+	Filename          string   `json:"Filename"`
+	State             string   `json:"State"`
+	Err               string   `json:"Err,omitempty"`
+	ErrCategory       string   `json:"ErrCategory,omitempty"`
+	Priority          int      `json:"Priority"`
+	Tags              []string `json:"Tags"`
+
+	CreatedAt      time.Time `json:"CreatedAt"`
+	StartedAt      time.Time `json:"StartedAt,omitzero"`
+	FinishedAt     time.Time `json:"FinishedAt,omitzero"`
+	AcknowledgedAt time.Time `json:"AcknowledgedAt,omitzero"`
+	ArchivedAt     time.Time `json:"ArchivedAt,omitzero"`
+	DeletedAt      time.Time `json:"DeletedAt,omitzero"`
+	DurationMs     int64     `json:"DurationMs"`
+}
+
+// A single run of a task
+type Attempt struct {
+	Number    int       `json:"Number"`
+	StartedAt time.Time `json:"StartedAt"`
+	EndedAt   time.Time `json:"EndedAt"`
+	Err       string    `json:"Err"`
+}
+
+// An entry of the timeline of a task
+type Event struct {
+	At      time.Time `json:"At"`
+	Kind    string    `json:"Kind"`
+	Message string    `json:"Message"`
+	Bytes   int64     `json:"Bytes"`
+}
+
+// Everything known about a task, including its attempts and timeline
+type TaskDetail struct {
+	Task
+
+	Attempts []Attempt `json:"Attempts"`
+	Events   []Event   `json:"Events"`
+}
+
+// A page of tasks
+type TaskList struct {
+	Tasks []Task `json:"Tasks"`
+	// Pass it as ListOptions.Cursor to get the following page. Empty on the last page
+	NextCursor string `json:"NextCursor"`
+	// Number of tasks matching the filters, across every page
+	Total int `json:"Total"`
+}
+
+// Filters, sorting and pagination of ListTasks. The zero value lists the first page of the queue
+type ListOptions struct {
+	// State names, e.g. "Failed"
+	States     []string
+	Aggregator string
+	Filehost   string
+	Category   string
+	// Text searched in the name, the slug and the filename
+	Text string
+	// "queue", "created", "finished" or "name"
+	Sort  string
+	Desc  bool
+	Limit int
+	// NextCursor of the previous page
+	Cursor string
+}
+
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+
+	for _, s := range o.States {
+		v.Add("state", s)
+	}
+
+	for key, value := range map[string]string{
+		"aggregator": o.Aggregator,
+		"filehost":   o.Filehost,
+		"category":   o.Category,
+		"q":          o.Text,
+		"sort":       o.Sort,
+		"cursor":     o.Cursor,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+
+	if o.Desc {
+		v.Set("order", "desc")
+	}
+
+	if o.Limit > 0 {
+		v.Set("limit", strconv.Itoa(o.Limit))
+	}
+
+	return v
+}
+
+// A task to be added to the queue
+type NewTask struct {
+	// Name of the aggregator, e.g. "doujinstyle"
+	Aggregator string `json:"Aggregator"`
+	// Page ID or full URL of the album
+	Slug string `json:"Slug"`
+}
+
+// Outcome of a single item of a request, with its own HTTP status
+type TaskResult struct {
+	ID     string `json:"ID,omitempty"`
+	Slug   string `json:"Slug,omitempty"`
+	Status int    `json:"Status"`
+	Error  string `json:"Error,omitempty"`
+	// Task already storing the album, when adding a duplicate
+	ExistingID string `json:"ExistingID,omitempty"`
+	// The task after the action, if it succeeded
+	Task *Task `json:"Task,omitempty"`
+}
+
+// Reports whether the action succeeded on this item
+func (r *TaskResult) OK() bool {
+	return r.Status >= 200 && r.Status < 300
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+// Sends req, authenticated by the token of the client if it has one
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	return c.httpClient().Do(req)
+}
+
+// Sends a request to the API, encoding body as JSON if it isn't nil
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+
+	var r io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return c.do(req)
+}
+
+// Decodes the body of res into v, or into an *APIError if the server answered with an error
+func decode(res *http.Response, v any) error {
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 400 {
+		var body struct {
+			Error string `json:"Error"`
+		}
+
+		_ = json.Unmarshal(data, &body)
+
+		return &APIError{Status: res.StatusCode, Message: body.Error}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+/*
+Runs a batch request, whose items all carry their own status.
+
+Results are returned whenever the server sent them, even if every item failed:
+only a rejected request (e.g. an empty batch) is reported as an *APIError
+*/
+func (c *Client) batch(ctx context.Context, method, path string, body any) ([]TaskResult, error) {
+	res, err := c.request(ctx, method, apiPath+path, nil, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out struct {
+		Results []TaskResult `json:"Results"`
+		Error   string       `json:"Error"`
+	}
+
+	if err := json.Unmarshal(data, &out); err != nil || out.Results == nil {
+		if res.StatusCode >= 400 {
+			return nil, &APIError{Status: res.StatusCode, Message: out.Error}
+		}
+
+		if err == nil {
+			err = fmt.Errorf("client: Missing results in the %d reply", res.StatusCode)
+		}
+
+		return nil, err
+	}
+
+	return out.Results, nil
+}
+
+// Adds tasks to the queue, returning the outcome of each of them in the same order
+func (c *Client) AddTasks(ctx context.Context, tasks ...NewTask) ([]TaskResult, error) {
+	return c.batch(ctx, http.MethodPost, "/tasks", map[string][]NewTask{"Tasks": tasks})
+}
+
+// Lists a page of tasks
+func (c *Client) ListTasks(ctx context.Context, opts ListOptions) (*TaskList, error) {
+	res, err := c.request(ctx, http.MethodGet, apiPath+"/tasks", opts.values(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &TaskList{}
+	if err := decode(res, list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Returns everything known about a task, queued, archived or trashed
+func (c *Client) GetTask(ctx context.Context, id string) (*TaskDetail, error) {
+	res, err := c.request(ctx, http.MethodGet, apiPath+"/tasks/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &TaskDetail{}
+	if err := decode(res, detail); err != nil {
+		return nil, err
+	}
+
+	return detail, nil
+}
+
+// Queues ended tasks again
+func (c *Client) RetryTasks(ctx context.Context, ids ...string) ([]TaskResult, error) {
+	return c.batch(ctx, http.MethodPost, "/tasks/retry", map[string][]string{"IDs": ids})
+}
+
+// Cancels tasks. Running tasks are stopped asynchronously, with a 202 result
+func (c *Client) AbortTasks(ctx context.Context, ids ...string) ([]TaskResult, error) {
+	return c.batch(ctx, http.MethodPost, "/tasks/abort", map[string][]string{"IDs": ids})
+}
+
+// Moves tasks to the trash, as a single removal that can be undone from the web UI
+func (c *Client) DeleteTasks(ctx context.Context, ids ...string) ([]TaskResult, error) {
+	return c.batch(ctx, http.MethodDelete, "/tasks", map[string][]string{"IDs": ids})
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /api/v1/tasks", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer dsdl_secret" {
+			t.Errorf("AddTasks: Expected the token in the Authorization header, got %q", got)
+		}
+
+		var req struct{ Tasks []NewTask }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Tasks) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"Error":"At least one task is required"}`)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `{"Results":[{"ID":"1","Slug":"22816","Status":201,"Task":{"ID":"1","State":"Queued"}},`+
+			`{"Slug":"22817","Status":409,"Error":"The album is already queued","ExistingID":"0"}]}`)
+	})
+
+	mux.HandleFunc("GET /api/v1/tasks", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query()["state"]; len(got) != 2 || r.URL.Query().Get("order") != "desc" {
+			t.Errorf("ListTasks: Unexpected query %q", r.URL.RawQuery)
+		}
+
+		fmt.Fprint(w, `{"Tasks":[{"ID":"1","State":"Failed"}],"NextCursor":"abc","Total":3}`)
+	})
+
+	mux.HandleFunc("GET /api/v1/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"Error":"Task not found"}`)
+	})
+
+	mux.HandleFunc("POST /api/v1/tasks/retry", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"Results":[{"ID":"missing","Status":404,"Error":"Task not found"}]}`)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL + "/")
+	c.Token = "dsdl_secret"
+	ctx := context.Background()
+
+	results, err := c.AddTasks(ctx, NewTask{"doujinstyle", "22816"}, NewTask{"doujinstyle", "22817"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || !results[0].OK() || results[0].Task.State != "Queued" ||
+		results[1].OK() || results[1].ExistingID != "0" {
+		t.Errorf("AddTasks: Unexpected results %+v", results)
+	}
+
+	var apiErr *APIError
+
+	if _, err := c.AddTasks(ctx); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("AddTasks: Expected a 400 APIError for an empty batch, got %v", err)
+	}
+
+	list, err := c.ListTasks(ctx, ListOptions{States: []string{"Failed", "Canceled"}, Desc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Tasks) != 1 || list.NextCursor != "abc" || list.Total != 3 {
+		t.Errorf("ListTasks: Unexpected page %+v", list)
+	}
+
+	if _, err := c.GetTask(ctx, "missing"); !errors.As(err, &apiErr) || apiErr.Message != "Task not found" {
+		t.Errorf("GetTask: Expected a 404 APIError, got %v", err)
+	}
+
+	// every item failed, but the results are still returned
+	results, err = c.RetryTasks(ctx, "missing")
+	if err != nil || len(results) != 1 || results[0].Status != http.StatusNotFound {
+		t.Errorf("RetryTasks: Expected a single 404 result, got %+v (%v)", results, err)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		fmt.Fprint(w, "event: queue-status\ndata: 2\n\n")
+		fmt.Fprint(w, ": comment\n\n")
+		fmt.Fprint(w, "event: removed\ndata: {\"Batch\":\"b\",\"Count\":1}\n\n")
+		fmt.Fprint(w, "data: first\ndata: second\n\n")
+	}))
+	defer srv.Close()
+
+	var got []StreamEvent
+
+	err := New(srv.URL).StreamEvents(context.Background(), func(ev StreamEvent) error {
+		got = append(got, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []StreamEvent{
+		{"queue-status", "2"},
+		{"removed", `{"Batch":"b","Count":1}`},
+		{"message", "first\nsecond"},
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("StreamEvents: Expected %d events, got %+v", len(expected), got)
+	}
+
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("StreamEvents: Expected %+v, got %+v", expected[i], got[i])
+		}
+	}
+
+	stop := errors.New("stop")
+
+	err = New(srv.URL).StreamEvents(context.Background(), func(StreamEvent) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("StreamEvents: Expected the callback error, got %v", err)
+	}
+}
+
+func TestWatchTasks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/events" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		fmt.Fprint(w, "event: task.progress\n")
+		fmt.Fprint(w, `data: {"Type":"task.progress","State":"Running","Task":{"ID":"a","State":"Running"},"Progress":{"Phase":"downloading","BytesDone":10,"BytesTotal":20}}`+"\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"Type\":\"error\",\"Error\":\"boom\"}\n\n")
+	}))
+	defer srv.Close()
+
+	var got []TaskEvent
+
+	err := New(srv.URL).WatchTasks(context.Background(), func(ev TaskEvent) error {
+		got = append(got, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("WatchTasks: Expected 2 events, got %+v", got)
+	}
+
+	if ev := got[0]; ev.Type != EventTaskProgress || ev.Task == nil || ev.Task.ID != "a" || ev.Task.State != "Running" ||
+		ev.Progress == nil || ev.Progress.BytesDone != 10 {
+		t.Errorf("WatchTasks: Unexpected progress event %+v", ev)
+	}
+
+	if ev := got[1]; ev.Type != EventError || ev.Error != "boom" || ev.Task != nil || ev.Progress != nil {
+		t.Errorf("WatchTasks: Unexpected error event %+v", ev)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An event of the /events-stream endpoint, e.g. "new-task" or "removed"
+type StreamEvent struct {
+	Name string
+	Data string
+}
+
+// Types of the events of WatchTasks
+const (
+	EventTaskCreated      = "task.created"
+	EventTaskStateChanged = "task.state_changed"
+	EventTaskProgress     = "task.progress"
+	EventTaskRemoved      = "task.removed"
+	EventError            = "error"
+)
+
+// How far a running task got
+type Progress struct {
+	// e.g. "downloading", empty while the task isn't running
+	Phase      string  `json:"Phase"`
+	BytesDone  int64   `json:"BytesDone"`
+	BytesTotal int64   `json:"BytesTotal"`
+	Rate       float64 `json:"Rate"`
+	// Estimated time left, 0 if unknown
+	ETA time.Duration `json:"ETA"`
+	// -1 if unknown
+	Pct        int8 `json:"Pct"`
+	FilesDone  int  `json:"FilesDone"`
+	FilesTotal int  `json:"FilesTotal"`
+}
+
+// An event of the JSON event stream
+type TaskEvent struct {
+	// One of the Event* constants
+	Type string    `json:"Type"`
+	At   time.Time `json:"At"`
+	// Name of the state of the task, e.g. "Queued"
+	State string `json:"State"`
+	// Error of the task, or the one reported by an EventError
+	Error string `json:"Error"`
+	// Removal the task has been trashed by, set by EventTaskRemoved
+	Batch string `json:"Batch"`
+	// The task as returned by ListTasks, nil for EventError
+	Task *Task `json:"Task"`
+	// How far the task got, nil for EventError
+	Progress *Progress `json:"Progress"`
+}
+
+/*
+Follows the event stream of the instance, calling fn for every event until
+ctx is done, the server closes the stream or fn returns an error.
+
+A canceled ctx ends the stream with ctx.Err()
+*/
+func (c *Client) StreamEvents(ctx context.Context, fn func(StreamEvent) error) error {
+	return c.stream(ctx, "/events-stream", fn)
+}
+
+/*
+Follows the JSON event stream of the instance, calling fn for every change of
+the tasks. It stops like StreamEvents.
+
+Unlike StreamEvents, which sends the HTML of the web UI, the events carry the
+tasks themselves
+*/
+func (c *Client) WatchTasks(ctx context.Context, fn func(TaskEvent) error) error {
+	return c.stream(ctx, apiPath+"/events", func(ev StreamEvent) error {
+		var te TaskEvent
+
+		if err := json.Unmarshal([]byte(ev.Data), &te); err != nil {
+			return fmt.Errorf("client: Decoding the %q event: %w", ev.Name, err)
+		}
+
+		return fn(te)
+	})
+}
+
+// Reads the SSE stream at path, calling fn for every event
+func (c *Client) stream(ctx context.Context, path string, fn func(StreamEvent) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &APIError{Status: res.StatusCode}
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	ev := StreamEvent{}
+	var data []string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			if data != nil {
+				if ev.Name == "" {
+					ev.Name = "message"
+				}
+
+				ev.Data = strings.Join(data, "\n")
+
+				if err := fn(ev); err != nil {
+					return err
+				}
+			}
+
+			ev, data = StreamEvent{}, nil
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			ev.Name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("client: Reading the event stream: %w", err)
+	}
+
+	return nil
+}
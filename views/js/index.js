@@ -3,15 +3,46 @@ const serviceSelect = document.querySelector('#ServiceNumber')
 document.addEventListener('DOMContentLoaded', () => {
     const value = localStorage.getItem('LastSelectedService')
 
-    if (value) {
+    if (value && serviceSelect) {
         serviceSelect.value = value
     }
 })
 
-serviceSelect.addEventListener('change', () => {
+serviceSelect?.addEventListener('change', () => {
     localStorage.setItem('LastSelectedService', serviceSelect.value)
 })
 
+/**
+ *
+ * @param {string} action either 'pause' or 'resume'
+ * @param {string} ids
+ *
+ */
+async function taskPauseAction(action, ids) {
+    let data = new FormData()
+    data.append("IDs", ids)
+
+    const res = await fetch('/api/task/' + action, { method: 'POST', body: data })
+
+    if (!res.ok) {
+        const text = await res.text()
+        window.alert(text)
+    }
+}
+
+/**
+ *
+ * @param {boolean} paused
+ *
+ */
+function setQueueToggle(paused) {
+    const btn = document.querySelector('#toggle-queue')
+    if (!btn) return
+
+    btn.setAttribute('data-paused', paused)
+    btn.innerText = paused ? 'Resume queue' : 'Pause queue'
+}
+
 /**
  *
  * @param {string} method
@@ -78,6 +109,36 @@ document.addEventListener('click', async function(evt) {
             break
         }
 
+        case 'toggle-queue': {
+            const paused = evt.target.getAttribute('data-paused') === 'true'
+
+            const res = await fetch('/api/queue/' + (paused ? 'resume' : 'pause'), { method: 'POST' })
+            if (res.ok) {
+                const status = await res.json()
+                setQueueToggle(status.Paused)
+            }
+
+            break
+        }
+
+        case 'task-ctrl-pause': {
+            const taskID = evt.target.getAttribute('data-id')
+            if (!taskID) break
+
+            await taskPauseAction('pause', taskID)
+
+            break
+        }
+
+        case 'task-ctrl-resume': {
+            const taskID = evt.target.getAttribute('data-id')
+            if (!taskID) break
+
+            await taskPauseAction('resume', taskID)
+
+            break
+        }
+
         case 'task-ctrl-remove-task': {
             const taskID = evt.target.getAttribute('data-id')
             if (!taskID) break
@@ -99,6 +160,50 @@ document.addEventListener('click', async function(evt) {
             break
         }
 
+        case 'load-more-ended': {
+            const url = evt.target.getAttribute('data-url')
+            if (!url) break
+
+            evt.target.remove()
+            await loadEnded(url, true)
+
+            break
+        }
+
+        case 'task-ctrl-acknowledge': {
+            const taskID = evt.target.getAttribute('data-id')
+            if (!taskID) break
+
+            let data = new FormData()
+            data.append("IDs", taskID)
+
+            const res = await fetch('/api/task/acknowledge', { method: 'POST', body: data })
+            if (!res.ok) {
+                const text = await res.text()
+                window.alert(text)
+            }
+
+            break
+        }
+
+        case 'undo-removal': {
+            const batch = evt.target.getAttribute('data-batch')
+            if (!batch) break
+
+            hideUndoToast()
+
+            let data = new FormData()
+            data.append("Batch", batch)
+
+            const res = await fetch('/api/trash/undo', { method: 'POST', body: data })
+            if (!res.ok) {
+                const body = await res.json()
+                window.alert(body.Error)
+            }
+
+            break
+        }
+
         case 'task-ctrl-retry': {
             const taskID = evt.target.getAttribute('data-id')
             if (!taskID) break
@@ -113,17 +218,64 @@ document.addEventListener('click', async function(evt) {
     }
 })
 
+/**
+ *
+ * @param {string} url page of ended tasks to load
+ * @param {boolean} append whether to add the tasks to the shown ones instead of replacing them
+ *
+ */
+async function loadEnded(url, append) {
+    const res = await fetch(url)
+    const html = await res.text()
+
+    if (!res.ok) {
+        window.alert(html)
+        return
+    }
+
+    const ended = document.querySelector('#ended')
+
+    if (append) {
+        ended.insertAdjacentHTML('beforeend', html)
+    } else {
+        ended.innerHTML = html
+    }
+}
+
+// ended tasks filters
+const endedSearch = document.querySelector('#ended-search')
+const endedState = document.querySelector('#ended-state')
+
+let endedSearchTimeout
+
+function filterEnded() {
+    const params = new URLSearchParams()
+
+    if (endedSearch.value.trim()) params.set('q', endedSearch.value.trim())
+    if (endedState.value) params.set('state', endedState.value)
+
+    loadEnded('/tasks/ended?' + params.toString(), false)
+}
+
+endedSearch.addEventListener('input', () => {
+    clearTimeout(endedSearchTimeout)
+    endedSearchTimeout = setTimeout(filterEnded, 300)
+})
+
+endedState.addEventListener('change', filterEnded)
+
 // document.querySelector("#clear-queued-btn").addEventListener("click", function() {
 //     await taskAction("DELETE", "", "clear")
 //     console.log("button pressed")
 // })
 
+// the viewers have neither the form nor the restart button
 document
-    .querySelector('form > button')
-    .addEventListener('click', async function(e) {
+    .querySelector('#add-task-form > button')
+    ?.addEventListener('click', async function(e) {
         e.preventDefault()
 
-        const form = document.querySelector('form')
+        const form = document.querySelector('#add-task-form')
 
         const formData = new FormData(form)
         try {
@@ -137,7 +289,7 @@ document
 
 document
     .querySelector('#restart-btn')
-    .addEventListener('click', async function(e) {
+    ?.addEventListener('click', async function(e) {
         const res = window.confirm(
             'WARNING: this operation will restart the application. All unsaved progress will be discarded.\n\n Continue?',
         )
@@ -153,6 +305,80 @@ document
         }
     })
 
+/**
+ *
+ * @param {string} id
+ * @param {string} to either 'top', 'bottom' or 'before'
+ * @param {string} before ID of the task that will follow the moved one
+ *
+ */
+async function moveTask(id, to, before = '') {
+    let data = new FormData()
+    data.append("ID", id)
+    data.append("To", to)
+    data.append("Before", before)
+
+    const res = await fetch('/api/task/move', { method: 'POST', body: data })
+
+    if (!res.ok) {
+        const text = await res.text()
+        window.alert(text)
+    }
+}
+
+// queue reordering
+const queuedNode = document.querySelector('#queued')
+
+queuedNode.addEventListener('dragstart', (evt) => {
+    if (document.body.dataset.role === 'viewer') {
+        evt.preventDefault()
+        return
+    }
+
+    evt.dataTransfer.setData('text/plain', evt.target.id)
+    evt.dataTransfer.effectAllowed = 'move'
+})
+
+queuedNode.addEventListener('dragover', (evt) => {
+    evt.preventDefault()
+    evt.dataTransfer.dropEffect = 'move'
+})
+
+queuedNode.addEventListener('drop', async (evt) => {
+    evt.preventDefault()
+
+    const taskID = evt.dataTransfer.getData('text/plain')
+    if (!taskID) return
+
+    const target = evt.target.closest('.download-queue-element')
+    if (!target) {
+        await moveTask(taskID, 'bottom')
+        return
+    }
+
+    // dropping on the lower half of a task places the moved one after it
+    const rect = target.getBoundingClientRect()
+    const next = evt.clientY > rect.top + rect.height / 2 ? target.nextElementSibling : target
+
+    if (!next) {
+        await moveTask(taskID, 'bottom')
+        return
+    }
+
+    if (next.id === taskID) return
+
+    await moveTask(taskID, 'before', next.id)
+})
+
+// retry countdowns
+setInterval(() => {
+    document.querySelectorAll('.retry-countdown').forEach((el) => {
+        const left = Math.round((Number(el.getAttribute('data-retry-at')) - Date.now()) / 1000)
+
+        el.innerText = left > 0 ? left + 's' : 'now'
+    })
+}, 1000)
+
 // SSE things
 //  0=CONNECTING, 1=OPEN, 2=CLOSED
 const source = new EventSource(window.location.origin + '/events-stream')
@@ -171,6 +397,13 @@ source.onopen = () => {
 }
 
 source.onerror = () => {
+    // the stream has been refused rather than lost, e.g. because the session expired:
+    // reloading leads to the login page
+    if (source.readyState === EventSource.CLOSED) {
+        window.location.reload()
+        return
+    }
+
     if (!sseHadError) {
         console.log('Connection lost, attempting to reconnect...')
 
@@ -246,6 +479,36 @@ source.addEventListener('update-node-content', function(event) {
     document.getElementById(data.ReceiverNodeSelector).innerHTML = data.NewContent
 })
 
+// how long the undo of a removal is offered
+const undoToastTimeout = 10000
+
+let undoToastTimer
+
+function hideUndoToast() {
+    clearTimeout(undoToastTimer)
+    document.querySelector('#undo-toast').hidden = true
+}
+
+source.addEventListener('removed', function(event) {
+    const toast = document.querySelector('#undo-toast')
+    if (!toast) return
+
+    const data = JSON.parse(event.data)
+
+    document.querySelector('#undo-toast-text').innerText =
+        data.Count === 1 ? 'Removed 1 task' : 'Removed ' + data.Count + ' tasks'
+    document.querySelector('#undo-removal').setAttribute('data-batch', data.Batch)
+
+    toast.hidden = false
+
+    clearTimeout(undoToastTimer)
+    undoToastTimer = setTimeout(hideUndoToast, undoToastTimeout)
+})
+
+source.addEventListener('queue-status', function(event) {
+    setQueueToggle(event.data === 'paused')
+})
+
 source.addEventListener('error', async function(event) {
     if (event.data == undefined) {
         return